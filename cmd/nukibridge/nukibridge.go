@@ -4,7 +4,11 @@ import (
 	"crypto/rand"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mapero/nuki-bridge/pkg/nukibridge"
 	log "github.com/sirupsen/logrus"
@@ -15,7 +19,67 @@ var (
 	tokenFlag      = flag.String("token", "", "authentication token for api calls")
 	configPathFlag = flag.String("config", "", "configuration path")
 	portFlag       = flag.String("port", ":8080", "api port")
-	done           = make(chan struct{})
+
+	verifyAuditFlag = flag.Bool("verify-audit", false, "verify the hash chain of the audit log in the configuration path and exit")
+
+	passphraseFileFlag    = flag.String("passphrase-file", "", "file with the passphrase encrypting the keys in the configuration, NUKI_PASSPHRASE or the systemd credential nukibridge-passphrase are used otherwise")
+	changePassphraseFlag  = flag.Bool("change-passphrase", false, "encrypt the configuration with the passphrase of NUKI_NEW_PASSPHRASE or -new-passphrase-file and exit")
+	newPassphraseFileFlag = flag.String("new-passphrase-file", "", "file with the new passphrase for -change-passphrase")
+
+	exportIdentityFlag         = flag.String("export-identity", "", "write the keys, locks and identities as encrypted archive to the file and exit")
+	importIdentityFlag         = flag.String("import-identity", "", "import the identity archive of the file when starting and verify the authorizations of the locks")
+	importForceFlag            = flag.Bool("import-force", false, "replace paired locks by the imported identity")
+	identityPassphraseFileFlag = flag.String("identity-passphrase-file", "", "file with the passphrase of the identity archive, NUKI_IDENTITY_PASSPHRASE is used otherwise")
+
+	eventHistoryFlag    = flag.Int("event-history", 100, "number of events kept for replay to reconnecting sse clients")
+	eventHistoryAgeFlag = flag.Duration("event-history-age", time.Hour, "maximum age of events kept for replay")
+
+	mqttBrokerFlag   = flag.String("mqtt-broker", "", "mqtt broker url, e.g. tcp://localhost:1883, mqtt is disabled if empty")
+	mqttClientIDFlag = flag.String("mqtt-client-id", "nukibridge", "mqtt client id")
+	mqttUsernameFlag = flag.String("mqtt-username", "", "mqtt username")
+	mqttPasswordFlag = flag.String("mqtt-password", "", "mqtt password")
+	mqttTopicFlag    = flag.String("mqtt-topic", "nukibridge", "mqtt base topic")
+	mqttCAFlag       = flag.String("mqtt-ca", "", "ca certificate file to verify the mqtt broker")
+	mqttCertFlag     = flag.String("mqtt-cert", "", "client certificate file for the mqtt broker")
+	mqttKeyFlag      = flag.String("mqtt-key", "", "client key file for the mqtt broker")
+	mqttInsecureFlag = flag.Bool("mqtt-insecure", false, "skip verification of the mqtt broker certificate")
+
+	mqttDiscoveryFlag       = flag.Bool("mqtt-discovery", false, "announce locks to home assistant by mqtt discovery")
+	mqttDiscoveryPrefixFlag = flag.String("mqtt-discovery-prefix", "homeassistant", "home assistant mqtt discovery prefix")
+
+	influxURLFlag             = flag.String("influx-url", "", "influxdb url, e.g. http://localhost:8086, the export is disabled if empty")
+	influxDatabaseFlag        = flag.String("influx-database", "nukibridge", "influxdb database")
+	influxUsernameFlag        = flag.String("influx-username", "", "influxdb username")
+	influxPasswordFlag        = flag.String("influx-password", "", "influxdb password")
+	influxRetentionPolicyFlag = flag.String("influx-retention-policy", "", "influxdb retention policy, the default policy is used if empty")
+	influxFlushIntervalFlag   = flag.Duration("influx-flush-interval", 10*time.Second, "maximum time points are buffered before they are written to influxdb")
+
+	historySyncFlag     = flag.Duration("history-sync-interval", 15*time.Minute, "time between synchronizations of the lock logs into the history store, disabled if 0")
+	historyBackfillFlag = flag.Int("history-backfill", 500, "maximum number of log entries read on the first synchronization of a lock, all if 0")
+
+	publicURLFlag = flag.String("public-url", "", "base url of links handed out to guests, e.g. https://door.example.com")
+
+	tlsFlag         = flag.Bool("tls", false, "serve https, with a self-signed certificate in the config path if no certificate is set")
+	tlsCertFlag     = flag.String("tls-cert", "", "certificate file (PEM) for https, enables https if set")
+	tlsKeyFlag      = flag.String("tls-key", "", "key file (PEM) of the certificate")
+	tlsHostsFlag    = flag.String("tls-hosts", "", "comma separated additional names and addresses of the self-signed certificate")
+	tlsClientCAFlag = flag.String("tls-client-ca", "", "ca file (PEM) signing client certificates, enables client certificate authentication if set")
+	tlsRequireFlag  = flag.Bool("tls-require-client-cert", false, "reject connections without valid client certificate")
+	tlsRedirectFlag = flag.String("tls-redirect-port", "", "port redirecting http to https, e.g. :8081, disabled if empty")
+
+	authFailuresFlag      = flag.Int("auth-failures", 10, "ban a source address after that many failed authentications, disabled if 0")
+	authFailureWindowFlag = flag.Duration("auth-failure-window", 10*time.Minute, "time window of counted authentication failures")
+	authBanFlag           = flag.Duration("auth-ban", 15*time.Minute, "time a source address is banned")
+	lockActionsFlag       = flag.Int("lock-action-limit", 10, "maximum lock actions per identity and lock within the window, disabled if 0")
+	lockActionWindowFlag  = flag.Duration("lock-action-window", time.Minute, "time window of the lock action limit")
+
+	wsOriginsFlag = flag.String("ws-origins", "", "comma separated origins of pages allowed to open websockets besides the bridge host, * allows all")
+
+	healthTokenFlag       = flag.String("health-token", "", "token for the health endpoints, they are public if empty")
+	healthLockTimeoutFlag = flag.Duration("health-lock-timeout", 0, "report the bridge as not ready if a lock did not advertise for longer, disabled if 0")
+	configBackupsFlag     = flag.Int("config-backups", 5, "number of previous configurations kept as backup, disabled if 0")
+
+	done = make(chan struct{})
 )
 
 func main() {
@@ -49,16 +113,189 @@ func main() {
 		}
 	}
 
+	if *verifyAuditFlag {
+		result := nukibridge.VerifyAuditLog(nukibridge.AuditLogPath(configPath))
+		if !result.Valid {
+			fmt.Printf("Audit log is invalid after %d entries: %s\n", result.Entries, result.Error)
+			os.Exit(1)
+		}
+		fmt.Printf("Audit log is valid, %d entries, head %s\n", result.Entries, result.Head)
+		return
+	}
+
+	passphrase, err := nukibridge.ReadPassphrase(os.Getenv("NUKI_PASSPHRASE"), envString("NUKI_PASSPHRASE_FILE", *passphraseFileFlag))
+	if err != nil {
+		log.WithError(err).Fatalln("Failed to read passphrase")
+	}
+
+	if *changePassphraseFlag {
+		newPassphrase := envSecret("NUKI_NEW_PASSPHRASE", *newPassphraseFileFlag)
+		if err := nukibridge.ChangePassphrase(configPath, passphrase, newPassphrase); err != nil {
+			log.WithError(err).Fatalln("Failed to change passphrase")
+		}
+		fmt.Println("Passphrase changed, backups of the configuration were removed")
+		return
+	}
+
+	if *exportIdentityFlag != "" {
+		data, err := nukibridge.ExportIdentity(configPath, passphrase, envSecret("NUKI_IDENTITY_PASSPHRASE", *identityPassphraseFileFlag))
+		if err != nil {
+			log.WithError(err).Fatalln("Failed to export identity")
+		}
+		if err := ioutil.WriteFile(*exportIdentityFlag, data, 0600); err != nil {
+			log.WithError(err).Fatalln("Failed to write identity archive")
+		}
+		fmt.Printf("Identity exported to %s\n", *exportIdentityFlag)
+		return
+	}
+
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
 		port = *portFlag
 	}
 
-	_, err := nukibridge.NewBridge(configPath, port, token)
+	options := nukibridge.Options{
+		EventHistorySize: envInt("NUKI_EVENT_HISTORY", *eventHistoryFlag),
+		EventHistoryAge:  envDuration("NUKI_EVENT_HISTORY_AGE", *eventHistoryAgeFlag),
+		MQTT: nukibridge.MQTTOptions{
+			Broker:    envString("NUKI_MQTT_BROKER", *mqttBrokerFlag),
+			ClientID:  envString("NUKI_MQTT_CLIENT_ID", *mqttClientIDFlag),
+			Username:  envString("NUKI_MQTT_USERNAME", *mqttUsernameFlag),
+			Password:  envString("NUKI_MQTT_PASSWORD", *mqttPasswordFlag),
+			BaseTopic: envString("NUKI_MQTT_TOPIC", *mqttTopicFlag),
+			CAFile:    envString("NUKI_MQTT_CA", *mqttCAFlag),
+			CertFile:  envString("NUKI_MQTT_CERT", *mqttCertFlag),
+			KeyFile:   envString("NUKI_MQTT_KEY", *mqttKeyFlag),
+			Insecure:  envBool("NUKI_MQTT_INSECURE", *mqttInsecureFlag),
+
+			Discovery:       envBool("NUKI_MQTT_DISCOVERY", *mqttDiscoveryFlag),
+			DiscoveryPrefix: envString("NUKI_MQTT_DISCOVERY_PREFIX", *mqttDiscoveryPrefixFlag),
+		},
+		TLS: nukibridge.TLSOptions{
+			Enabled:           envBool("NUKI_TLS", *tlsFlag),
+			CertFile:          envString("NUKI_TLS_CERT", *tlsCertFlag),
+			KeyFile:           envString("NUKI_TLS_KEY", *tlsKeyFlag),
+			Hosts:             envList("NUKI_TLS_HOSTS", *tlsHostsFlag),
+			ClientCAFile:      envString("NUKI_TLS_CLIENT_CA", *tlsClientCAFlag),
+			RequireClientCert: envBool("NUKI_TLS_REQUIRE_CLIENT_CERT", *tlsRequireFlag),
+			RedirectPort:      envString("NUKI_TLS_REDIRECT_PORT", *tlsRedirectFlag),
+		},
+		RateLimit: nukibridge.RateLimitOptions{
+			AuthFailures:      envInt("NUKI_AUTH_FAILURES", *authFailuresFlag),
+			AuthFailureWindow: envDuration("NUKI_AUTH_FAILURE_WINDOW", *authFailureWindowFlag),
+			BanDuration:       envDuration("NUKI_AUTH_BAN", *authBanFlag),
+			LockActions:       envInt("NUKI_LOCK_ACTION_LIMIT", *lockActionsFlag),
+			LockActionWindow:  envDuration("NUKI_LOCK_ACTION_WINDOW", *lockActionWindowFlag),
+		},
+		HistorySyncInterval: envDuration("NUKI_HISTORY_SYNC_INTERVAL", *historySyncFlag),
+		HistoryBackfill:     envInt("NUKI_HISTORY_BACKFILL", *historyBackfillFlag),
+		PublicURL:           envString("NUKI_PUBLIC_URL", *publicURLFlag),
+		WebsocketOrigins:    envList("NUKI_WS_ORIGINS", *wsOriginsFlag),
+		HealthToken:         envString("NUKI_HEALTH_TOKEN", *healthTokenFlag),
+		HealthLockTimeout:   envDuration("NUKI_HEALTH_LOCK_TIMEOUT", *healthLockTimeoutFlag),
+		ConfigBackups:       envInt("NUKI_CONFIG_BACKUPS", *configBackupsFlag),
+		Passphrase:          passphrase,
+		Influx: nukibridge.InfluxOptions{
+			URL:             envString("NUKI_INFLUX_URL", *influxURLFlag),
+			Database:        envString("NUKI_INFLUX_DATABASE", *influxDatabaseFlag),
+			Username:        envString("NUKI_INFLUX_USERNAME", *influxUsernameFlag),
+			Password:        envString("NUKI_INFLUX_PASSWORD", *influxPasswordFlag),
+			RetentionPolicy: envString("NUKI_INFLUX_RETENTION_POLICY", *influxRetentionPolicyFlag),
+			FlushInterval:   envDuration("NUKI_INFLUX_FLUSH_INTERVAL", *influxFlushIntervalFlag),
+		},
+	}
+	if options.EventHistorySize < 0 {
+		log.WithField("size", options.EventHistorySize).Fatalln("Event history size must not be negative")
+	}
+
+	bridge, err := nukibridge.NewBridge(configPath, port, token, options)
 	if err != nil {
 		panic(err)
 	}
 
+	if *importIdentityFlag != "" {
+		data, err := ioutil.ReadFile(*importIdentityFlag)
+		if err != nil {
+			log.WithError(err).Fatalln("Failed to read identity archive")
+		}
+		result, err := bridge.ImportIdentity(data, envSecret("NUKI_IDENTITY_PASSPHRASE", *identityPassphraseFileFlag), *importForceFlag)
+		if err != nil {
+			log.WithError(err).Fatalln("Failed to import identity")
+		}
+		for _, l := range result.Locks {
+			if !l.Verified {
+				log.WithField("nukiId", l.NukiId).WithField("error", l.Error).Warnln("Lock did not accept the imported authorization")
+			}
+		}
+	}
+
 	<-done
 	log.Infoln("Done")
 }
+
+// envString returns the environment variable if set, otherwise the flag value
+func envString(name string, value string) string {
+	if env, ok := os.LookupEnv(name); ok {
+		return env
+	}
+	return value
+}
+
+// envSecret returns the environment variable if set, otherwise the content of the file
+func envSecret(name string, file string) string {
+	if env, ok := os.LookupEnv(name); ok || file == "" {
+		return env
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).Fatalln("Failed to read secret")
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// envList splits the comma separated environment variable or flag value
+func envList(name string, value string) []string {
+	var list []string
+	for _, item := range strings.Split(envString(name, value), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envBool(name string, value bool) bool {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return value
+	}
+	b, err := strconv.ParseBool(env)
+	if err != nil {
+		log.WithError(err).WithField("variable", name).Fatalln("Invalid environment variable")
+	}
+	return b
+}
+
+func envInt(name string, value int) int {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return value
+	}
+	i, err := strconv.Atoi(env)
+	if err != nil {
+		log.WithError(err).WithField("variable", name).Fatalln("Invalid environment variable")
+	}
+	return i
+}
+
+func envDuration(name string, value time.Duration) time.Duration {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return value
+	}
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		log.WithError(err).WithField("variable", name).Fatalln("Invalid environment variable")
+	}
+	return d
+}
@@ -10,6 +10,7 @@
 package api
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -18,12 +19,19 @@ import (
 // pass the data to a EventsApiServicer to perform the required actions, then write the service results to the http response.
 type EventsApiRouter interface {
 	EventsGet(http.ResponseWriter, *http.Request)
+	WsGet(http.ResponseWriter, *http.Request)
 }
 
 // InofficialApiRouter defines the required methods for binding the api requests to a responses for the InofficialApi
 // The InofficialApiRouter implementation should parse necessary information from the http request,
 // pass the data to a InofficialApiServicer to perform the required actions, then write the service results to the http response.
 type InofficialApiRouter interface {
+	ApiKeysGet(http.ResponseWriter, *http.Request)
+	ApiKeysIdDelete(http.ResponseWriter, *http.Request)
+	ApiKeysPost(http.ResponseWriter, *http.Request)
+	CallbacksGet(http.ResponseWriter, *http.Request)
+	CallbacksIdDelete(http.ResponseWriter, *http.Request)
+	CallbacksPost(http.ResponseWriter, *http.Request)
 	BridgeConfigGet(http.ResponseWriter, *http.Request)
 	BridgeConfigPut(http.ResponseWriter, *http.Request)
 	LocksGet(http.ResponseWriter, *http.Request)
@@ -34,6 +42,22 @@ type InofficialApiRouter interface {
 	LocksIdHistoryGet(http.ResponseWriter, *http.Request)
 	LocksIdLastStateGet(http.ResponseWriter, *http.Request)
 	LocksIdPut(http.ResponseWriter, *http.Request)
+	TokensPost(http.ResponseWriter, *http.Request)
+	GrantsGet(http.ResponseWriter, *http.Request)
+	GrantsIdDelete(http.ResponseWriter, *http.Request)
+	GrantsPost(http.ResponseWriter, *http.Request)
+	ClientCertsGet(http.ResponseWriter, *http.Request)
+	ClientCertsIdDelete(http.ResponseWriter, *http.Request)
+	ClientCertsPost(http.ResponseWriter, *http.Request)
+	AuditGet(http.ResponseWriter, *http.Request)
+	AuditVerifyGet(http.ResponseWriter, *http.Request)
+	LocksIdHistoryExportGet(http.ResponseWriter, *http.Request)
+	IdentityExportPost(http.ResponseWriter, *http.Request)
+	IdentityImportPost(http.ResponseWriter, *http.Request)
+	BridgeKeysRotatePost(http.ResponseWriter, *http.Request)
+	BridgeKeysRotationGet(http.ResponseWriter, *http.Request)
+	PairingPost(http.ResponseWriter, *http.Request)
+	PairingIdGet(http.ResponseWriter, *http.Request)
 }
 
 // OfficialApiRouter defines the required methods for binding the api requests to a responses for the OfficialApi
@@ -54,6 +78,7 @@ type OfficialApiRouter interface {
 // and updated with the logic required for the API.
 type EventsApiServicer interface {
 	EventsGet(http.ResponseWriter, *http.Request)
+	WsGet(http.ResponseWriter, *http.Request)
 }
 
 // InofficialApiServicer defines the api actions for the InofficialApi service
@@ -61,16 +86,38 @@ type EventsApiServicer interface {
 // while the service implementation can ignored with the .openapi-generator-ignore file
 // and updated with the logic required for the API.
 type InofficialApiServicer interface {
+	ApiKeysGet() (interface{}, error)
+	ApiKeysIdDelete(string) (interface{}, error)
+	ApiKeysPost(ApiKey) (interface{}, error)
+	CallbacksGet() (interface{}, error)
+	CallbacksIdDelete(string) (interface{}, error)
+	CallbacksPost(CallbackConfig) (interface{}, error)
 	BridgeConfigGet() (interface{}, error)
 	BridgeConfigPut(BridgeConfig) (interface{}, error)
-	LocksGet() (interface{}, error)
+	LocksGet(context.Context) (interface{}, error)
 	LocksIdConfigGet(string) (interface{}, error)
 	LocksIdCurrentStateGet(string) (interface{}, error)
-	LocksIdDelete(string) (interface{}, error)
+	LocksIdDelete(string, string) (interface{}, error)
 	LocksIdGet(string) (interface{}, error)
-	LocksIdHistoryGet(string, string, string) (interface{}, error)
+	LocksIdHistoryGet(string, string, string, string, string, string, string, string, string, string, string) (interface{}, error)
 	LocksIdLastStateGet(string) (interface{}, error)
 	LocksIdPut(string, Lock) (interface{}, error)
+	TokensPost(TokenRequest) (interface{}, error)
+	GrantsGet() (interface{}, error)
+	GrantsIdDelete(string) (interface{}, error)
+	GrantsPost(Grant) (interface{}, error)
+	ClientCertsGet() (interface{}, error)
+	ClientCertsIdDelete(string) (interface{}, error)
+	ClientCertsPost(ClientCert) (interface{}, error)
+	AuditGet(string, string, string, string, string, string, string) (interface{}, error)
+	AuditVerifyGet() (interface{}, error)
+	LocksIdHistoryExportGet(http.ResponseWriter, *http.Request)
+	IdentityExportPost(IdentityExportRequest) (interface{}, error)
+	IdentityImportPost(IdentityImportRequest) (interface{}, error)
+	BridgeKeysRotatePost(KeyRotationRequest) (interface{}, error)
+	BridgeKeysRotationGet() (interface{}, error)
+	PairingPost(PairingRequest) (interface{}, error)
+	PairingIdGet(string) (interface{}, error)
 }
 
 // OfficialApiServicer defines the api actions for the OfficialApi service
@@ -81,7 +128,7 @@ type OfficialApiServicer interface {
 	CallbackAddGet(string) (interface{}, error)
 	CallbackListGet() (interface{}, error)
 	CallbackRemoveGet(string) (interface{}, error)
-	ListGet() (interface{}, error)
+	ListGet(context.Context) (interface{}, error)
 	LockActionGet(string, string, string) (interface{}, error)
 	LockStateGet(string) (interface{}, error)
 }
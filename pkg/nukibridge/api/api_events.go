@@ -33,6 +33,12 @@ func (c *EventsApiController) Routes() Routes {
 			"/api/v1/events",
 			c.EventsGet,
 		},
+		{
+			"WsGet",
+			strings.ToUpper("Get"),
+			"/api/v1/ws",
+			c.WsGet,
+		},
 	}
 }
 
@@ -40,3 +46,8 @@ func (c *EventsApiController) Routes() Routes {
 func (c *EventsApiController) EventsGet(w http.ResponseWriter, r *http.Request) {
 	c.service.EventsGet(w, r)
 }
+
+// WsGet - Bidirectional websocket channel for events and commands
+func (c *EventsApiController) WsGet(w http.ResponseWriter, r *http.Request) {
+	c.service.WsGet(w, r)
+}
@@ -30,3 +30,10 @@ func (s *EventsApiService) EventsGet(w http.ResponseWriter, r *http.Request) {
 	// Add api_events_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return
 }
+
+// WsGet - Bidirectional websocket channel for events and commands
+func (s *EventsApiService) WsGet(w http.ResponseWriter, r *http.Request) {
+	// TODO - update WsGet with the required logic for this service method.
+	// Add api_events_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return
+}
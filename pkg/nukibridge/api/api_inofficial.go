@@ -30,6 +30,42 @@ func NewInofficialApiController(s InofficialApiServicer) Router {
 // Routes returns all of the api route for the InofficialApiController
 func (c *InofficialApiController) Routes() Routes {
 	return Routes{ 
+		{
+			"ApiKeysGet",
+			strings.ToUpper("Get"),
+			"/api/v1/apikeys",
+			c.ApiKeysGet,
+		},
+		{
+			"ApiKeysIdDelete",
+			strings.ToUpper("Delete"),
+			"/api/v1/apikeys/{id}",
+			c.ApiKeysIdDelete,
+		},
+		{
+			"ApiKeysPost",
+			strings.ToUpper("Post"),
+			"/api/v1/apikeys",
+			c.ApiKeysPost,
+		},
+		{
+			"CallbacksGet",
+			strings.ToUpper("Get"),
+			"/api/v1/callbacks",
+			c.CallbacksGet,
+		},
+		{
+			"CallbacksIdDelete",
+			strings.ToUpper("Delete"),
+			"/api/v1/callbacks/{id}",
+			c.CallbacksIdDelete,
+		},
+		{
+			"CallbacksPost",
+			strings.ToUpper("Post"),
+			"/api/v1/callbacks",
+			c.CallbacksPost,
+		},
 		{
 			"BridgeConfigGet",
 			strings.ToUpper("Get"),
@@ -90,9 +126,187 @@ func (c *InofficialApiController) Routes() Routes {
 			"/api/v1/locks/{id}",
 			c.LocksIdPut,
 		},
+		{
+			"TokensPost",
+			strings.ToUpper("Post"),
+			"/api/v1/tokens",
+			c.TokensPost,
+		},
+		{
+			"GrantsGet",
+			strings.ToUpper("Get"),
+			"/api/v1/grants",
+			c.GrantsGet,
+		},
+		{
+			"GrantsIdDelete",
+			strings.ToUpper("Delete"),
+			"/api/v1/grants/{id}",
+			c.GrantsIdDelete,
+		},
+		{
+			"GrantsPost",
+			strings.ToUpper("Post"),
+			"/api/v1/grants",
+			c.GrantsPost,
+		},
+		{
+			"ClientCertsGet",
+			strings.ToUpper("Get"),
+			"/api/v1/clientcerts",
+			c.ClientCertsGet,
+		},
+		{
+			"ClientCertsIdDelete",
+			strings.ToUpper("Delete"),
+			"/api/v1/clientcerts/{id}",
+			c.ClientCertsIdDelete,
+		},
+		{
+			"ClientCertsPost",
+			strings.ToUpper("Post"),
+			"/api/v1/clientcerts",
+			c.ClientCertsPost,
+		},
+		{
+			"AuditGet",
+			strings.ToUpper("Get"),
+			"/api/v1/audit",
+			c.AuditGet,
+		},
+		{
+			"AuditVerifyGet",
+			strings.ToUpper("Get"),
+			"/api/v1/audit/verify",
+			c.AuditVerifyGet,
+		},
+		{
+			"LocksIdHistoryExportGet",
+			strings.ToUpper("Get"),
+			"/api/v1/locks/{id}/history/export",
+			c.LocksIdHistoryExportGet,
+		},
+		{
+			"IdentityExportPost",
+			strings.ToUpper("Post"),
+			"/api/v1/identity/export",
+			c.IdentityExportPost,
+		},
+		{
+			"IdentityImportPost",
+			strings.ToUpper("Post"),
+			"/api/v1/identity/import",
+			c.IdentityImportPost,
+		},
+		{
+			"BridgeKeysRotatePost",
+			strings.ToUpper("Post"),
+			"/api/v1/bridge/keys/rotate",
+			c.BridgeKeysRotatePost,
+		},
+		{
+			"BridgeKeysRotationGet",
+			strings.ToUpper("Get"),
+			"/api/v1/bridge/keys/rotation",
+			c.BridgeKeysRotationGet,
+		},
+		{
+			"PairingPost",
+			strings.ToUpper("Post"),
+			"/api/v1/pairing",
+			c.PairingPost,
+		},
+		{
+			"PairingIdGet",
+			strings.ToUpper("Get"),
+			"/api/v1/pairing/{id}",
+			c.PairingIdGet,
+		},
 	}
 }
 
+// ApiKeysGet - Returns all api keys without their tokens
+func (c *InofficialApiController) ApiKeysGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.ApiKeysGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// ApiKeysIdDelete - Revokes an api key
+func (c *InofficialApiController) ApiKeysIdDelete(w http.ResponseWriter, r *http.Request) { 
+	params := mux.Vars(r)
+	id := params["id"]
+	result, err := c.service.ApiKeysIdDelete(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// ApiKeysPost - Creates an api key, the token is only returned once
+func (c *InofficialApiController) ApiKeysPost(w http.ResponseWriter, r *http.Request) { 
+	apiKey := &ApiKey{}
+	if err := json.NewDecoder(r.Body).Decode(&apiKey); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.ApiKeysPost(*apiKey)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// CallbacksGet - Returns all registered callbacks including their filters
+func (c *InofficialApiController) CallbacksGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.CallbacksGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// CallbacksIdDelete - Removes a callback
+func (c *InofficialApiController) CallbacksIdDelete(w http.ResponseWriter, r *http.Request) { 
+	params := mux.Vars(r)
+	id := params["id"]
+	result, err := c.service.CallbacksIdDelete(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// CallbacksPost - Registers a new callback with filters and payload format
+func (c *InofficialApiController) CallbacksPost(w http.ResponseWriter, r *http.Request) { 
+	callbackConfig := &CallbackConfig{}
+	if err := json.NewDecoder(r.Body).Decode(&callbackConfig); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.CallbacksPost(*callbackConfig)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
 // BridgeConfigGet - Read the current bridge configuration
 func (c *InofficialApiController) BridgeConfigGet(w http.ResponseWriter, r *http.Request) { 
 	result, err := c.service.BridgeConfigGet()
@@ -123,7 +337,7 @@ func (c *InofficialApiController) BridgeConfigPut(w http.ResponseWriter, r *http
 
 // LocksGet - Returns a list of linked locks
 func (c *InofficialApiController) LocksGet(w http.ResponseWriter, r *http.Request) { 
-	result, err := c.service.LocksGet()
+	result, err := c.service.LocksGet(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
 		return
@@ -162,7 +376,14 @@ func (c *InofficialApiController) LocksIdCurrentStateGet(w http.ResponseWriter,
 func (c *InofficialApiController) LocksIdDelete(w http.ResponseWriter, r *http.Request) { 
 	params := mux.Vars(r)
 	id := params["id"]
-	result, err := c.service.LocksIdDelete(id)
+	query := r.URL.Query()
+	force := query.Get("force")
+	result, err := c.service.LocksIdDelete(id, force)
+	if err != nil && result != nil {
+		status := http.StatusConflict
+		EncodeJSONResponse(result, &status, w)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(500)
 		return
@@ -191,7 +412,15 @@ func (c *InofficialApiController) LocksIdHistoryGet(w http.ResponseWriter, r *ht
 	id := params["id"]
 	offset := query.Get("offset")
 	count := query.Get("count")
-	result, err := c.service.LocksIdHistoryGet(id, offset, count)
+	from := query.Get("from")
+	to := query.Get("to")
+	type_ := query.Get("type")
+	authId := query.Get("authId")
+	name := query.Get("name")
+	action := query.Get("action")
+	order := query.Get("order")
+	sync := query.Get("sync")
+	result, err := c.service.LocksIdHistoryGet(id, offset, count, from, to, type_, authId, name, action, order, sync)
 	if err != nil {
 		w.WriteHeader(500)
 		return
@@ -231,3 +460,229 @@ func (c *InofficialApiController) LocksIdPut(w http.ResponseWriter, r *http.Requ
 	
 	EncodeJSONResponse(result, nil, w)
 }
+
+// TokensPost - Issues a signed json web token
+func (c *InofficialApiController) TokensPost(w http.ResponseWriter, r *http.Request) { 
+	tokenRequest := &TokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&tokenRequest); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.TokensPost(*tokenRequest)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// GrantsGet - Returns all guest grants including their uses
+func (c *InofficialApiController) GrantsGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.GrantsGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// GrantsIdDelete - Revokes a guest grant
+func (c *InofficialApiController) GrantsIdDelete(w http.ResponseWriter, r *http.Request) { 
+	params := mux.Vars(r)
+	id := params["id"]
+	result, err := c.service.GrantsIdDelete(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// GrantsPost - Creates a guest grant, the link is only returned once
+func (c *InofficialApiController) GrantsPost(w http.ResponseWriter, r *http.Request) { 
+	grant := &Grant{}
+	if err := json.NewDecoder(r.Body).Decode(&grant); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.GrantsPost(*grant)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// ClientCertsGet - Returns all client certificate identities
+func (c *InofficialApiController) ClientCertsGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.ClientCertsGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// ClientCertsIdDelete - Removes a client certificate identity
+func (c *InofficialApiController) ClientCertsIdDelete(w http.ResponseWriter, r *http.Request) { 
+	params := mux.Vars(r)
+	id := params["id"]
+	result, err := c.service.ClientCertsIdDelete(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// ClientCertsPost - Maps a client certificate subject to an identity
+func (c *InofficialApiController) ClientCertsPost(w http.ResponseWriter, r *http.Request) { 
+	clientCert := &ClientCert{}
+	if err := json.NewDecoder(r.Body).Decode(&clientCert); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.ClientCertsPost(*clientCert)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// AuditGet - Returns entries of the audit log, the newest first
+func (c *InofficialApiController) AuditGet(w http.ResponseWriter, r *http.Request) { 
+	query := r.URL.Query()
+	from := query.Get("from")
+	to := query.Get("to")
+	nukiId := query.Get("nukiId")
+	identity := query.Get("identity")
+	operation := query.Get("operation")
+	outcome := query.Get("outcome")
+	limit := query.Get("limit")
+	result, err := c.service.AuditGet(from, to, nukiId, identity, operation, outcome, limit)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// AuditVerifyGet - Verifies the hash chain of the audit log
+func (c *InofficialApiController) AuditVerifyGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.AuditVerifyGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// LocksIdHistoryExportGet - Exports the stored history of the lock as csv, json lines or icalendar
+func (c *InofficialApiController) LocksIdHistoryExportGet(w http.ResponseWriter, r *http.Request) { 
+	c.service.LocksIdHistoryExportGet(w, r)
+}
+
+// IdentityExportPost - Exports the identity of the bridge as encrypted archive
+func (c *InofficialApiController) IdentityExportPost(w http.ResponseWriter, r *http.Request) { 
+	identityExportRequest := &IdentityExportRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&identityExportRequest); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.IdentityExportPost(*identityExportRequest)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// IdentityImportPost - Imports the identity of an archive and verifies the authorizations of the locks
+func (c *InofficialApiController) IdentityImportPost(w http.ResponseWriter, r *http.Request) { 
+	identityImportRequest := &IdentityImportRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&identityImportRequest); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.IdentityImportPost(*identityImportRequest)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// BridgeKeysRotatePost - Starts the rotation of the bridge key pair
+func (c *InofficialApiController) BridgeKeysRotatePost(w http.ResponseWriter, r *http.Request) { 
+	keyRotationRequest := &KeyRotationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&keyRotationRequest); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.BridgeKeysRotatePost(*keyRotationRequest)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// BridgeKeysRotationGet - Returns the progress of the last key rotation
+func (c *InofficialApiController) BridgeKeysRotationGet(w http.ResponseWriter, r *http.Request) { 
+	result, err := c.service.BridgeKeysRotationGet()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// PairingPost - Starts a pairing job
+func (c *InofficialApiController) PairingPost(w http.ResponseWriter, r *http.Request) { 
+	pairingRequest := &PairingRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&pairingRequest); err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	result, err := c.service.PairingPost(*pairingRequest)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
+
+// PairingIdGet - Returns the progress of a pairing job
+func (c *InofficialApiController) PairingIdGet(w http.ResponseWriter, r *http.Request) { 
+	params := mux.Vars(r)
+	id := params["id"]
+	result, err := c.service.PairingIdGet(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	
+	EncodeJSONResponse(result, nil, w)
+}
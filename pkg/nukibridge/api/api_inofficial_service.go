@@ -10,7 +10,9 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"net/http"
 )
 
 // InofficialApiService is a service that implents the logic for the InofficialApiServicer
@@ -24,6 +26,48 @@ func NewInofficialApiService() InofficialApiServicer {
 	return &InofficialApiService{}
 }
 
+// ApiKeysGet - Returns all api keys without their tokens
+func (s *InofficialApiService) ApiKeysGet() (interface{}, error) {
+	// TODO - update ApiKeysGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ApiKeysGet' not implemented")
+}
+
+// ApiKeysIdDelete - Revokes an api key
+func (s *InofficialApiService) ApiKeysIdDelete(id string) (interface{}, error) {
+	// TODO - update ApiKeysIdDelete with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ApiKeysIdDelete' not implemented")
+}
+
+// ApiKeysPost - Creates an api key, the token is only returned once
+func (s *InofficialApiService) ApiKeysPost(apiKey ApiKey) (interface{}, error) {
+	// TODO - update ApiKeysPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ApiKeysPost' not implemented")
+}
+
+// CallbacksGet - Returns all registered callbacks including their filters
+func (s *InofficialApiService) CallbacksGet() (interface{}, error) {
+	// TODO - update CallbacksGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'CallbacksGet' not implemented")
+}
+
+// CallbacksIdDelete - Removes a callback
+func (s *InofficialApiService) CallbacksIdDelete(id string) (interface{}, error) {
+	// TODO - update CallbacksIdDelete with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'CallbacksIdDelete' not implemented")
+}
+
+// CallbacksPost - Registers a new callback with filters and payload format
+func (s *InofficialApiService) CallbacksPost(callbackConfig CallbackConfig) (interface{}, error) {
+	// TODO - update CallbacksPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'CallbacksPost' not implemented")
+}
+
 // BridgeConfigGet - Read the current bridge configuration
 func (s *InofficialApiService) BridgeConfigGet() (interface{}, error) {
 	// TODO - update BridgeConfigGet with the required logic for this service method.
@@ -39,7 +83,7 @@ func (s *InofficialApiService) BridgeConfigPut(bridgeConfig BridgeConfig) (inter
 }
 
 // LocksGet - Returns a list of linked locks
-func (s *InofficialApiService) LocksGet() (interface{}, error) {
+func (s *InofficialApiService) LocksGet(ctx context.Context) (interface{}, error) {
 	// TODO - update LocksGet with the required logic for this service method.
 	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return nil, errors.New("service method 'LocksGet' not implemented")
@@ -60,7 +104,7 @@ func (s *InofficialApiService) LocksIdCurrentStateGet(id string) (interface{}, e
 }
 
 // LocksIdDelete - Update a linked lock
-func (s *InofficialApiService) LocksIdDelete(id string) (interface{}, error) {
+func (s *InofficialApiService) LocksIdDelete(id string, force string) (interface{}, error) {
 	// TODO - update LocksIdDelete with the required logic for this service method.
 	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return nil, errors.New("service method 'LocksIdDelete' not implemented")
@@ -74,7 +118,7 @@ func (s *InofficialApiService) LocksIdGet(id string) (interface{}, error) {
 }
 
 // LocksIdHistoryGet - Returns the history of log action
-func (s *InofficialApiService) LocksIdHistoryGet(id string, offset string, count string) (interface{}, error) {
+func (s *InofficialApiService) LocksIdHistoryGet(id string, offset string, count string, from string, to string, type_ string, authId string, name string, action string, order string, sync string) (interface{}, error) {
 	// TODO - update LocksIdHistoryGet with the required logic for this service method.
 	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return nil, errors.New("service method 'LocksIdHistoryGet' not implemented")
@@ -93,3 +137,115 @@ func (s *InofficialApiService) LocksIdPut(id string, lock Lock) (interface{}, er
 	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return nil, errors.New("service method 'LocksIdPut' not implemented")
 }
+
+// TokensPost - Issues a signed json web token
+func (s *InofficialApiService) TokensPost(tokenRequest TokenRequest) (interface{}, error) {
+	// TODO - update TokensPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'TokensPost' not implemented")
+}
+
+// GrantsGet - Returns all guest grants including their uses
+func (s *InofficialApiService) GrantsGet() (interface{}, error) {
+	// TODO - update GrantsGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'GrantsGet' not implemented")
+}
+
+// GrantsIdDelete - Revokes a guest grant
+func (s *InofficialApiService) GrantsIdDelete(id string) (interface{}, error) {
+	// TODO - update GrantsIdDelete with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'GrantsIdDelete' not implemented")
+}
+
+// GrantsPost - Creates a guest grant, the link is only returned once
+func (s *InofficialApiService) GrantsPost(grant Grant) (interface{}, error) {
+	// TODO - update GrantsPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'GrantsPost' not implemented")
+}
+
+// ClientCertsGet - Returns all client certificate identities
+func (s *InofficialApiService) ClientCertsGet() (interface{}, error) {
+	// TODO - update ClientCertsGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ClientCertsGet' not implemented")
+}
+
+// ClientCertsIdDelete - Removes a client certificate identity
+func (s *InofficialApiService) ClientCertsIdDelete(id string) (interface{}, error) {
+	// TODO - update ClientCertsIdDelete with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ClientCertsIdDelete' not implemented")
+}
+
+// ClientCertsPost - Maps a client certificate subject to an identity
+func (s *InofficialApiService) ClientCertsPost(clientCert ClientCert) (interface{}, error) {
+	// TODO - update ClientCertsPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'ClientCertsPost' not implemented")
+}
+
+// AuditGet - Returns entries of the audit log, the newest first
+func (s *InofficialApiService) AuditGet(from string, to string, nukiId string, identity string, operation string, outcome string, limit string) (interface{}, error) {
+	// TODO - update AuditGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'AuditGet' not implemented")
+}
+
+// AuditVerifyGet - Verifies the hash chain of the audit log
+func (s *InofficialApiService) AuditVerifyGet() (interface{}, error) {
+	// TODO - update AuditVerifyGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'AuditVerifyGet' not implemented")
+}
+
+// LocksIdHistoryExportGet - Exports the stored history of the lock as csv, json lines or icalendar
+func (s *InofficialApiService) LocksIdHistoryExportGet(w http.ResponseWriter, r *http.Request) {
+	// TODO - update LocksIdHistoryExportGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return
+}
+
+// IdentityExportPost - Exports the identity of the bridge as encrypted archive
+func (s *InofficialApiService) IdentityExportPost(identityExportRequest IdentityExportRequest) (interface{}, error) {
+	// TODO - update IdentityExportPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'IdentityExportPost' not implemented")
+}
+
+// IdentityImportPost - Imports the identity of an archive and verifies the authorizations of the locks
+func (s *InofficialApiService) IdentityImportPost(identityImportRequest IdentityImportRequest) (interface{}, error) {
+	// TODO - update IdentityImportPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'IdentityImportPost' not implemented")
+}
+
+// BridgeKeysRotatePost - Starts the rotation of the bridge key pair
+func (s *InofficialApiService) BridgeKeysRotatePost(keyRotationRequest KeyRotationRequest) (interface{}, error) {
+	// TODO - update BridgeKeysRotatePost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'BridgeKeysRotatePost' not implemented")
+}
+
+// BridgeKeysRotationGet - Returns the progress of the last key rotation
+func (s *InofficialApiService) BridgeKeysRotationGet() (interface{}, error) {
+	// TODO - update BridgeKeysRotationGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'BridgeKeysRotationGet' not implemented")
+}
+
+// PairingPost - Starts a pairing job
+func (s *InofficialApiService) PairingPost(pairingRequest PairingRequest) (interface{}, error) {
+	// TODO - update PairingPost with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'PairingPost' not implemented")
+}
+
+// PairingIdGet - Returns the progress of a pairing job
+func (s *InofficialApiService) PairingIdGet(id string) (interface{}, error) {
+	// TODO - update PairingIdGet with the required logic for this service method.
+	// Add api_inofficial_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
+	return nil, errors.New("service method 'PairingIdGet' not implemented")
+}
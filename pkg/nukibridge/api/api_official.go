@@ -105,7 +105,7 @@ func (c *OfficialApiController) CallbackRemoveGet(w http.ResponseWriter, r *http
 
 // ListGet -
 func (c *OfficialApiController) ListGet(w http.ResponseWriter, r *http.Request) {
-	result, err := c.service.ListGet()
+	result, err := c.service.ListGet(r.Context())
 	if err != nil {
 		w.WriteHeader(500)
 		return
@@ -10,6 +10,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 )
 
@@ -46,7 +47,7 @@ func (s *OfficialApiService) CallbackRemoveGet(id string) (interface{}, error) {
 }
 
 // ListGet - 
-func (s *OfficialApiService) ListGet() (interface{}, error) {
+func (s *OfficialApiService) ListGet(ctx context.Context) (interface{}, error) {
 	// TODO - update ListGet with the required logic for this service method.
 	// Add api_official_service.go to the .openapi-generator-ignore to avoid overwriting this service implementation when updating open api generation.
 	return nil, errors.New("service method 'ListGet' not implemented")
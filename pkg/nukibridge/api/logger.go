@@ -10,19 +10,98 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
 	"strings"
 
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
 	"net/http"
 	"time"
 )
 
-func Logger(inner http.Handler, name string) http.Handler {
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "api_requests_total",
+		Help:      "Number of api requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nukibridge",
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of api requests by route. Streaming requests last until the client disconnects.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}, []string{"route"})
+)
+
+type contextKey int
+
+const callerKey contextKey = 0
+
+// requestCaller is filled in by the authorization running inside the logger
+type requestCaller struct {
+	name string
+}
+
+// WithCaller adds the name of the authenticated caller to the request log
+func WithCaller(ctx context.Context, name string) context.Context {
+	if caller, ok := ctx.Value(callerKey).(*requestCaller); ok {
+		caller.name = name
+	}
+	return ctx
+}
+
+func init() {
+	prometheus.MustRegister(apiRequests, apiRequestDuration)
+}
+
+// statusRecorder keeps the status code of the response. Flushing and
+// hijacking are passed through for server-sent events and websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	// A hijacked connection is upgraded, e.g. to a websocket
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Logger records every request of the matched routes in the log and the metrics. It is the
+// outermost middleware, so rejected authorizations and rate limited requests are recorded too.
+func Logger(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		name := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		caller := &requestCaller{}
+		r = r.WithContext(context.WithValue(r.Context(), callerKey, caller))
 
-		inner.ServeHTTP(w, r)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(recorder, r)
 		uri := r.RequestURI
 		tokens, ok := r.URL.Query()["token"]
 		if ok {
@@ -31,6 +110,12 @@ func Logger(inner http.Handler, name string) http.Handler {
 			}
 		}
 
-		log.WithField("method", r.Method).WithField("uri", uri).WithField("rtt", time.Since(start)).Infoln("API request")
+		apiRequests.WithLabelValues(name, r.Method, strconv.Itoa(recorder.status)).Inc()
+		apiRequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		entry := log.WithField("method", r.Method)
+		if caller.name != "" {
+			entry = entry.WithField("identity", caller.name)
+		}
+		entry.WithField("uri", uri).WithField("status", recorder.status).WithField("rtt", time.Since(start)).Infoln("API request")
 	})
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type loggerTestRouter struct{}
+
+func (loggerTestRouter) Routes() Routes {
+	return Routes{
+		{"LoggerTest", http.MethodGet, "/logger", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}},
+	}
+}
+
+func TestLoggerRecordsRejectedRequests(t *testing.T) {
+	router := NewRouter(loggerTestRouter{})
+	// Like the authorization of the bridge, registered after the logger
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("token") {
+			case "":
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			case "limited":
+				WithCaller(r.Context(), "alice")
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			default:
+				next.ServeHTTP(w, r.WithContext(WithCaller(r.Context(), "alice")))
+			}
+		})
+	})
+	tests := []struct {
+		name   string
+		target string
+		status string
+	}{
+		{"unauthorized", "/logger", "401"},
+		{"rate limited", "/logger?token=limited", "429"},
+		{"served", "/logger?token=secret", "204"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := apiRequests.WithLabelValues("LoggerTest", http.MethodGet, tt.status)
+			before := testutil.ToFloat64(counter)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("%v requests with status %s recorded, want 1", got, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type ApiKey struct {
+
+	Id string `json:"id,omitempty"`
+
+	Name string `json:"name"`
+
+	Scopes []string `json:"scopes"`
+
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	Created string `json:"created,omitempty"`
+
+	Token string `json:"token,omitempty"`
+}
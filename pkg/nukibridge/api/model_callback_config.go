@@ -0,0 +1,29 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type CallbackConfig struct {
+
+	Id int32 `json:"id,omitempty"`
+
+	Url string `json:"url"`
+
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	Events []string `json:"events,omitempty"`
+
+	States []int32 `json:"states,omitempty"`
+
+	Format string `json:"format,omitempty"`
+
+	ContentType string `json:"contentType,omitempty"`
+
+	Template string `json:"template,omitempty"`
+}
@@ -0,0 +1,24 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type ClientCert struct {
+
+	Id string `json:"id,omitempty"`
+
+	// Common name or subject alternative name of the client certificate
+	Subject string `json:"subject"`
+
+	Scopes []string `json:"scopes"`
+
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	Created string `json:"created,omitempty"`
+}
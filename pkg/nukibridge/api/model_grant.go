@@ -0,0 +1,41 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type Grant struct {
+
+	Id string `json:"id,omitempty"`
+
+	Name string `json:"name"`
+
+	NukiIds []int32 `json:"nukiIds"`
+
+	Actions []string `json:"actions"`
+
+	Start string `json:"start,omitempty"`
+
+	End string `json:"end,omitempty"`
+
+	MaxUses int32 `json:"maxUses,omitempty"`
+
+	Uses int32 `json:"uses"`
+
+	Weekdays []int32 `json:"weekdays,omitempty"`
+
+	From string `json:"from,omitempty"`
+
+	To string `json:"to,omitempty"`
+
+	Created string `json:"created,omitempty"`
+
+	Link string `json:"link,omitempty"`
+
+	History []GrantUse `json:"history,omitempty"`
+}
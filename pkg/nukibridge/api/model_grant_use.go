@@ -0,0 +1,25 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type GrantUse struct {
+
+	Time string `json:"time"`
+
+	NukiId int32 `json:"nukiId"`
+
+	Action string `json:"action"`
+
+	Source string `json:"source,omitempty"`
+
+	Success bool `json:"success"`
+
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,37 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type IdentityArchive struct {
+
+	Format string `json:"format"`
+
+	Version int32 `json:"version"`
+
+	Created string `json:"created,omitempty"`
+
+	// Public key of the bridge
+	PublicKey string `json:"publicKey,omitempty"`
+
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	Kdf string `json:"kdf"`
+
+	Salt string `json:"salt"`
+
+	N int32 `json:"n"`
+
+	R int32 `json:"r"`
+
+	P int32 `json:"p"`
+
+	// Nonce followed by the encrypted identity
+	Data string `json:"data"`
+}
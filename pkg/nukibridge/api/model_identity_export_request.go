@@ -0,0 +1,16 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type IdentityExportRequest struct {
+
+	// Passphrase encrypting the archive
+	Passphrase string `json:"passphrase"`
+}
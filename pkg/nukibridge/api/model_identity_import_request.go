@@ -0,0 +1,20 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type IdentityImportRequest struct {
+
+	Passphrase string `json:"passphrase"`
+
+	// Replace the identity even if locks are paired
+	Force bool `json:"force,omitempty"`
+
+	Archive IdentityArchive `json:"archive"`
+}
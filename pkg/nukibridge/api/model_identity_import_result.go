@@ -0,0 +1,17 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type IdentityImportResult struct {
+
+	Locks []LockVerification `json:"locks"`
+
+	Callbacks int32 `json:"callbacks"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type KeyRotation struct {
+
+	// running, completed or failed
+	State string `json:"state"`
+
+	// Public key of the new key pair
+	PublicKey string `json:"publicKey,omitempty"`
+
+	Started string `json:"started,omitempty"`
+
+	Finished string `json:"finished,omitempty"`
+
+	Locks []KeyRotationLock `json:"locks"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type KeyRotationLock struct {
+
+	NukiId int32 `json:"nukiId"`
+
+	Name string `json:"name,omitempty"`
+
+	// pending, waitingForPairing, authenticating, removingAuthorization, completed or failed
+	Step string `json:"step"`
+
+	Error string `json:"error,omitempty"`
+
+	// The lock uses the new keys but the old authorization could not be removed
+	Warning string `json:"warning,omitempty"`
+}
@@ -0,0 +1,19 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type KeyRotationRequest struct {
+
+	// Locks to authorize with the new keys, all locks if empty
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	// Seconds to wait for the pairing window of each lock
+	Timeout int32 `json:"timeout,omitempty"`
+}
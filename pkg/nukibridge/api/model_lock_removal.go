@@ -0,0 +1,24 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type LockRemoval struct {
+
+	NukiId int32 `json:"nukiId"`
+
+	// The lock was removed from the bridge
+	Removed bool `json:"removed"`
+
+	// The authorization of the bridge was removed from the lock
+	Unpaired bool `json:"unpaired"`
+
+	// Reason the authorization could not be removed from the lock
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,22 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type LockVerification struct {
+
+	NukiId int32 `json:"nukiId"`
+
+	Name string `json:"name,omitempty"`
+
+	// The lock accepted the authorization of the bridge
+	Verified bool `json:"verified"`
+
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,40 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type PairingJob struct {
+
+	Id string `json:"id"`
+
+	// running, completed or failed
+	State string `json:"state"`
+
+	// waitingForPairing, connecting, publicKeyExchange, challenge, authorizationData, idConfirmation, readConfig, verifyPIN or completed. The step that failed for failed jobs.
+	Step string `json:"step"`
+
+	Address string `json:"address,omitempty"`
+
+	NukiId int32 `json:"nukiId,omitempty"`
+
+	// Name of the authorization
+	Name string `json:"name"`
+
+	// Name of the paired lock
+	LockName string `json:"lockName,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// Problem of a completed job, e.g. an admin PIN the lock did not accept
+	Warning string `json:"warning,omitempty"`
+
+	Started string `json:"started,omitempty"`
+
+	Finished string `json:"finished,omitempty"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type PairingRequest struct {
+
+	// Address of the lock, the first unpaired lock opening its pairing window if empty
+	Address string `json:"address,omitempty"`
+
+	// Seconds to wait for the pairing window of the lock
+	Timeout int32 `json:"timeout,omitempty"`
+
+	// Name of the authorization shown by the Nuki app, at most 32 bytes
+	Name string `json:"name,omitempty"`
+
+	// Admin PIN of the lock
+	Pin *int32 `json:"pin,omitempty"`
+}
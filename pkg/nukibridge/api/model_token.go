@@ -0,0 +1,21 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type Token struct {
+
+	Id string `json:"id,omitempty"`
+
+	Token string `json:"token,omitempty"`
+
+	NotBefore string `json:"notBefore,omitempty"`
+
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
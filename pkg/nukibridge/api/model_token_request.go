@@ -0,0 +1,27 @@
+/*
+ * Keyturner api
+ *
+ * Keyturner api
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package api
+
+type TokenRequest struct {
+
+	Name string `json:"name,omitempty"`
+
+	Scopes []string `json:"scopes"`
+
+	NukiIds []int32 `json:"nukiIds,omitempty"`
+
+	Actions []string `json:"actions,omitempty"`
+
+	NotBefore string `json:"notBefore,omitempty"`
+
+	ExpiresAt string `json:"expiresAt,omitempty"`
+
+	ValidFor string `json:"validFor,omitempty"`
+}
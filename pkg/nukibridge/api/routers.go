@@ -41,7 +41,6 @@ func NewRouter(routers ...Router) *mux.Router {
 		for _, route := range api.Routes() {
 			var handler http.Handler
 			handler = route.HandlerFunc
-			handler = Logger(handler, route.Name)
 
 			router.
 				Methods(route.Method).
@@ -50,6 +49,7 @@ func NewRouter(routers ...Router) *mux.Router {
 				Handler(handler)
 		}
 	}
+	router.Use(Logger)
 
 	return router
 }
@@ -0,0 +1,6 @@
+// +build tools
+
+package main
+
+// The generator of the assets is ignored by go mod tidy, this keeps its requirements
+import _ "github.com/shurcooL/vfsgen"
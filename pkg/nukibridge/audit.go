@@ -0,0 +1,425 @@
+package nukibridge
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	auditFilename = "audit.log"
+	// auditBodyLimit is the maximum request body recorded as parameters
+	auditBodyLimit = 64 * 1024
+	auditRedacted  = "***"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// auditedRoutes maps the privileged api routes to the operation recorded in the audit log.
+// The lock is taken from the path variable id or the query parameter nukiId if lockParam is set.
+var auditedRoutes = map[string]struct {
+	operation string
+	lockParam bool
+}{
+	"LockActionGet":        {"lockAction", true},
+	"LocksIdPut":           {"pinChange", true},
+	"LocksIdDelete":        {"lockDelete", true},
+	"BridgeConfigPut":      {"pairing", false},
+	"CallbackAddGet":       {"callbackAdd", false},
+	"CallbackRemoveGet":    {"callbackRemove", false},
+	"CallbacksPost":        {"callbackAdd", false},
+	"CallbacksIdDelete":    {"callbackRemove", false},
+	"ApiKeysPost":          {"apiKeyCreate", false},
+	"ApiKeysIdDelete":      {"apiKeyRevoke", false},
+	"TokensPost":           {"tokenIssue", false},
+	"GrantsPost":           {"grantCreate", false},
+	"GrantsIdDelete":       {"grantRevoke", false},
+	"ClientCertsPost":      {"clientCertCreate", false},
+	"ClientCertsIdDelete":  {"clientCertRemove", false},
+	"IdentityExportPost":   {"identityExport", false},
+	"IdentityImportPost":   {"identityImport", false},
+	"BridgeKeysRotatePost": {"keyRotation", false},
+	"PairingPost":          {"pairing", false},
+}
+
+// redactedParams are never written to the audit log, compared case insensitive
+var redactedParams = map[string]bool{
+	"pin":        true,
+	"adminpin":   true,
+	"token":      true,
+	"password":   true,
+	"secret":     true,
+	"passphrase": true,
+}
+
+// AuditEntry is a line of the audit log. Hash covers all other fields
+// including the hash of the previous entry, so changing or removing an
+// entry breaks the chain.
+type AuditEntry struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Identity  string            `json:"identity"`
+	Source    string            `json:"source,omitempty"`
+	Operation string            `json:"operation"`
+	NukiId    uint32            `json:"nukiId,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+	PrevHash  string            `json:"prevHash"`
+	Hash      string            `json:"hash"`
+}
+
+// AuditFilter selects entries of the audit log, zero values match all entries
+type AuditFilter struct {
+	From      time.Time
+	To        time.Time
+	NukiId    uint32
+	Identity  string
+	Operation string
+	Outcome   string
+	Limit     int
+}
+
+// AuditVerification is the result of verifying the hash chain
+type AuditVerification struct {
+	Entries uint64 `json:"entries"`
+	Head    string `json:"head"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (e AuditEntry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	switch {
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	case f.NukiId != 0 && e.NukiId != f.NukiId:
+		return false
+	case f.Identity != "" && e.Identity != f.Identity:
+		return false
+	case f.Operation != "" && e.Operation != f.Operation:
+		return false
+	case f.Outcome != "" && e.Outcome != f.Outcome:
+		return false
+	}
+	return true
+}
+
+// auditLog appends entries to a hash chained file, it is never rewritten
+type auditLog struct {
+	mutex    sync.Mutex
+	file     string
+	seq      uint64
+	lastHash string
+}
+
+// AuditLogPath is the audit log file in the configuration path
+func AuditLogPath(dir string) string {
+	return path.Join(dir, auditFilename)
+}
+
+// newAuditLog continues the chain of an existing audit log
+func newAuditLog(dir string) (*auditLog, error) {
+	a := &auditLog{file: AuditLogPath(dir)}
+	err := readAuditLog(a.file, func(e AuditEntry) error {
+		a.seq = e.Seq
+		a.lastHash = e.Hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	log.WithField("entries", a.seq).WithField("head", a.lastHash).Infoln("Audit log opened")
+	return a, nil
+}
+
+// readAuditLog calls fn for every entry in the order they were written
+func readAuditLog(file string, fn func(AuditEntry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	line := 0
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			line++
+			var e AuditEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				return fmt.Errorf("Invalid audit entry in line %d: %v", line, err)
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// record completes the entry and appends it to the log
+func (a *auditLog) record(e AuditEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	e.Seq = a.seq + 1
+	e.Time = time.Now().UTC()
+	e.PrevHash = a.lastHash
+	hash, err := e.computeHash()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to hash audit entry")
+		return
+	}
+	e.Hash = hash
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to encode audit entry")
+		return
+	}
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to open audit log")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.WithError(err).Errorln("Failed to write audit entry")
+		return
+	}
+	if err := f.Sync(); err != nil {
+		log.WithError(err).Errorln("Failed to sync audit log")
+		return
+	}
+	a.seq = e.Seq
+	a.lastHash = e.Hash
+}
+
+// query returns the matching entries, the newest first
+func (a *auditLog) query(filter AuditFilter) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	err := readAuditLog(a.file, func(e AuditEntry) error {
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq > entries[j].Seq
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+func (a *auditLog) verify() AuditVerification {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return VerifyAuditLog(a.file)
+}
+
+// VerifyAuditLog checks the hash chain of the audit log file. Removed entries at the
+// end are only detected by comparing the head hash with one recorded earlier.
+func VerifyAuditLog(file string) AuditVerification {
+	result := AuditVerification{}
+	err := readAuditLog(file, func(e AuditEntry) error {
+		if e.Seq != result.Entries+1 {
+			return fmt.Errorf("Entry %d follows entry %d", e.Seq, result.Entries)
+		}
+		if e.PrevHash != result.Head {
+			return fmt.Errorf("Entry %d does not follow the previous entry", e.Seq)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("Entry %d was modified", e.Seq)
+		}
+		result.Entries = e.Seq
+		result.Head = e.Hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		result.Error = err.Error()
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// auditRecorder keeps the status code of audited requests
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// redactParam hides secrets, including those in nested objects and arrays
+func redactParam(name string, value interface{}) string {
+	if redactedParams[strings.ToLower(name)] {
+		return auditRedacted
+	}
+	if v, ok := value.(string); ok {
+		return v
+	}
+	data, _ := json.Marshal(redactNested(value))
+	return string(data)
+}
+
+// redactNested replaces the values of secret fields in place
+func redactNested(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if redactedParams[strings.ToLower(key)] {
+				v[key] = auditRedacted
+			} else {
+				v[key] = redactNested(nested)
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactNested(nested)
+		}
+	}
+	return value
+}
+
+// requestParams collects the path variables, query parameters and the json body of the request
+func requestParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key, value := range mux.Vars(r) {
+		params[key] = redactParam(key, value)
+	}
+	for key, values := range r.URL.Query() {
+		params[key] = redactParam(key, strings.Join(values, ","))
+	}
+	if r.Body == nil {
+		return params
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, auditBodyLimit))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil || len(body) == 0 {
+		return params
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return params
+	}
+	for key, value := range fields {
+		params[key] = redactParam(key, value)
+	}
+	return params
+}
+
+// auditRequest records the privileged request with the given status, other requests are ignored
+func (b *bridge) auditRequest(r *http.Request, id *identity, params map[string]string, status int) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return
+	}
+	audited, ok := auditedRoutes[route.GetName()]
+	if !ok {
+		return
+	}
+	e := AuditEntry{
+		Identity:  id.Name,
+		Source:    sourceAddress(r),
+		Operation: audited.operation,
+		Params:    params,
+		Outcome:   AuditSuccess,
+	}
+	if audited.lockParam {
+		lockID := mux.Vars(r)["id"]
+		if lockID == "" {
+			lockID = r.URL.Query().Get("nukiId")
+		}
+		if nukiId, err := strconv.ParseUint(lockID, 10, 32); err == nil {
+			e.NukiId = uint32(nukiId)
+		}
+	}
+	if status >= http.StatusBadRequest {
+		e.Outcome = AuditFailure
+		e.Error = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	b.audit.record(e)
+}
+
+// auditRequests is the middleware recording privileged requests of the api router
+func (b *bridge) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := auditedRoutes[route.GetName()]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		params := requestParams(r)
+		recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		b.auditRequest(r, identityFrom(r.Context()), params, recorder.status)
+	})
+}
+
+// auditLockAction records lock actions outside of the api, e.g. by websocket, mqtt or guest links
+func (b *bridge) auditLockAction(name string, source string, nukiId uint32, action string, err error) {
+	e := AuditEntry{
+		Identity:  name,
+		Source:    source,
+		Operation: "lockAction",
+		NukiId:    nukiId,
+		Params:    map[string]string{"action": action},
+		Outcome:   AuditSuccess,
+	}
+	if err != nil {
+		e.Outcome = AuditFailure
+		e.Error = err.Error()
+	}
+	b.audit.record(e)
+}
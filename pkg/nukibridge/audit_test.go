@@ -0,0 +1,155 @@
+package nukibridge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newAuditTestLog(t *testing.T, entries int) *auditLog {
+	dir, err := ioutil.TempDir("", "nukibridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	a, err := newAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < entries; i++ {
+		a.record(AuditEntry{Identity: "master", Operation: "lockAction", NukiId: uint32(i%2 + 1), Outcome: AuditSuccess})
+	}
+	return a
+}
+
+func TestAuditLogChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		valid  bool
+	}{
+		{"unchanged", func(lines [][]byte) [][]byte { return lines }, true},
+		{"last entry removed", func(lines [][]byte) [][]byte { return lines[:len(lines)-1] }, true},
+		{"entry modified", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"master"`), []byte(`"other"`), 1)
+			return lines
+		}, false},
+		{"entry removed", func(lines [][]byte) [][]byte { return append(lines[:1], lines[2:]...) }, false},
+		{"entries swapped", func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, false},
+		{"broken line", func(lines [][]byte) [][]byte {
+			lines[2] = []byte("{")
+			return lines
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuditTestLog(t, 4)
+			data, err := ioutil.ReadFile(a.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+			data = append(bytes.Join(tt.tamper(lines), []byte("\n")), '\n')
+			if err := ioutil.WriteFile(a.file, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			result := a.verify()
+			if result.Valid != tt.valid {
+				t.Errorf("valid %v (%s), want %v", result.Valid, result.Error, tt.valid)
+			}
+		})
+	}
+}
+
+func TestAuditLogContinuesChain(t *testing.T) {
+	a := newAuditTestLog(t, 3)
+	dir := strings.TrimSuffix(a.file, auditFilename)
+	reopened, err := newAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.seq != 3 || reopened.lastHash != a.lastHash {
+		t.Fatalf("reopened at %d %s, want 3 %s", reopened.seq, reopened.lastHash, a.lastHash)
+	}
+	reopened.record(AuditEntry{Identity: "master", Operation: "apiKeyCreate", Outcome: AuditSuccess})
+	result := reopened.verify()
+	if !result.Valid || result.Entries != 4 || result.Head != reopened.lastHash {
+		t.Errorf("verification %+v, want 4 valid entries with head %s", result, reopened.lastHash)
+	}
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	a := newAuditTestLog(t, 5)
+	a.record(AuditEntry{Identity: "key:alice", Operation: "apiKeyCreate", Outcome: AuditFailure})
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []uint64
+	}{
+		{"all newest first", AuditFilter{}, []uint64{6, 5, 4, 3, 2, 1}},
+		{"limit", AuditFilter{Limit: 2}, []uint64{6, 5}},
+		{"lock", AuditFilter{NukiId: 2}, []uint64{4, 2}},
+		{"identity", AuditFilter{Identity: "key:alice"}, []uint64{6}},
+		{"operation", AuditFilter{Operation: "lockAction", Limit: 1}, []uint64{5}},
+		{"outcome", AuditFilter{Outcome: AuditFailure}, []uint64{6}},
+		{"no match", AuditFilter{Identity: "key:bob"}, []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := a.query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint64, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.Seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("entries %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("entries %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  string
+	}{
+		{"plain", "action", "2", "2"},
+		{"pin", "pin", "1234", auditRedacted},
+		{"case insensitive", "adminPIN", "1234", auditRedacted},
+		{"passphrase", "passphrase", "secret", auditRedacted},
+		{"nested", "lock", map[string]interface{}{"name": "door", "pin": "1234"}, `{"name":"door","pin":"***"}`},
+		{"number", "maxUses", 3.0, "3"},
+		{"deeply nested", "lock", map[string]interface{}{"config": map[string]interface{}{"name": "door", "adminPIN": 1234.0}},
+			`{"config":{"adminPIN":"***","name":"door"}}`},
+		{"array of objects", "locks", []interface{}{map[string]interface{}{"id": 1.0, "pin": "1234"}, "plain"},
+			`[{"id":1,"pin":"***"},"plain"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactParam(tt.key, tt.value); got != tt.want {
+				t.Errorf("redacted %s, want %s", got, tt.want)
+			}
+		})
+	}
+	nested := map[string]interface{}{"inner": map[string]interface{}{"token": "abc"}}
+	if got := redactParam("archive", nested); strings.Contains(got, "abc") {
+		t.Errorf("nested secret not redacted: %s", got)
+	}
+}
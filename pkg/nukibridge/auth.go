@@ -0,0 +1,407 @@
+package nukibridge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scopes of api keys. The operate scopes build on each other, unlatch
+// includes unlock and unlock includes lock. Admin includes all scopes.
+const (
+	ScopeRead    = "read"
+	ScopeLock    = "lock"
+	ScopeUnlock  = "unlock"
+	ScopeUnlatch = "unlatch"
+	ScopeAdmin   = "admin"
+)
+
+var (
+	errUnauthorized = errors.New("Unauthorized")
+	errForbidden    = errors.New("Forbidden")
+)
+
+var scopeLevels = map[string]int{
+	ScopeLock:    1,
+	ScopeUnlock:  2,
+	ScopeUnlatch: 3,
+}
+
+// routeScopes maps the api routes to the required scope. Routes not listed
+// need the admin scope. The lock is taken from the path variable id or the
+// query parameter nukiId if lockParam is set.
+var routeScopes = map[string]struct {
+	scope     string
+	lockParam bool
+}{
+	"ListGet":                 {ScopeRead, false},
+	"LockStateGet":            {ScopeRead, true},
+	"LockActionGet":           {ScopeLock, true},
+	"LocksGet":                {ScopeRead, false},
+	"LocksIdGet":              {ScopeRead, true},
+	"LocksIdConfigGet":        {ScopeRead, true},
+	"LocksIdCurrentStateGet":  {ScopeRead, true},
+	"LocksIdLastStateGet":     {ScopeRead, true},
+	"LocksIdHistoryGet":       {ScopeRead, true},
+	"LocksIdHistoryExportGet": {ScopeRead, true},
+	"BridgeConfigGet":         {ScopeRead, false},
+	"EventsGet":               {ScopeRead, false},
+	"WsGet":                   {ScopeRead, false},
+}
+
+type contextKey int
+
+const identityKey contextKey = 0
+
+// identity is the authenticated caller of the api
+type identity struct {
+	Name   string
+	Scopes []string
+	// NukiIds limits the access to these locks, all locks if empty
+	NukiIds []uint32
+	// Actions limits the lock actions in addition to the scopes, all actions if empty
+	Actions []enums.LockAction
+}
+
+var masterIdentity = &identity{
+	Name:   "master",
+	Scopes: []string{ScopeAdmin},
+}
+
+func (i *identity) hasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == ScopeAdmin || s == scope {
+			return true
+		}
+		if level, ok := scopeLevels[s]; ok && level >= scopeLevels[scope] && scopeLevels[scope] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *identity) allowsLock(nukiId uint32) bool {
+	if len(i.NukiIds) == 0 {
+		return true
+	}
+	for _, id := range i.NukiIds {
+		if id == nukiId {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAction checks the scope required by the lock action. The fob actions
+// are configurable and may unlatch, so they need the unlatch scope.
+func (i *identity) allowsAction(nukiId uint32, action enums.LockAction) bool {
+	if !i.allowsLock(nukiId) || !i.hasScope(actionScope(action)) {
+		return false
+	}
+	if len(i.Actions) == 0 {
+		return true
+	}
+	for _, a := range i.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func actionScope(action enums.LockAction) string {
+	switch action {
+	case enums.LockActionLock, enums.LockActionFullLock:
+		return ScopeLock
+	case enums.LockActionUnlock, enums.LockActionLocknGo:
+		return ScopeUnlock
+	default:
+		return ScopeUnlatch
+	}
+}
+
+// allowsEvent hides events of other locks, events of the bridge itself are visible to all
+func (i *identity) allowsEvent(event Event) bool {
+	e, ok := event.Data.(lockEventer)
+	if !ok || e.lockEvent().NukiId == 0 {
+		return true
+	}
+	return i.allowsLock(e.lockEvent().NukiId)
+}
+
+func withIdentity(ctx context.Context, id *identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// identityFrom returns the identity of the request, the master identity for internal calls
+func identityFrom(ctx context.Context) *identity {
+	if id, ok := ctx.Value(identityKey).(*identity); ok {
+		return id
+	}
+	return masterIdentity
+}
+
+// apiKey is a named token with limited access, only the hash of the token is stored
+type apiKey struct {
+	ID      string
+	Name    string
+	Hash    string
+	Scopes  []string
+	NukiIds []uint32
+	Created time.Time
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("At least one scope is required")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeRead, ScopeLock, ScopeUnlock, ScopeUnlatch, ScopeAdmin:
+		default:
+			return fmt.Errorf("Unknown scope %s", scope)
+		}
+	}
+	return nil
+}
+
+func (k *apiKey) identity() *identity {
+	return &identity{
+		Name:    "key:" + k.Name,
+		Scopes:  k.Scopes,
+		NukiIds: k.NukiIds,
+	}
+}
+
+func (k *apiKey) toApi() api.ApiKey {
+	nukiIds := make([]int32, 0, len(k.NukiIds))
+	for _, id := range k.NukiIds {
+		nukiIds = append(nukiIds, int32(id))
+	}
+	return api.ApiKey{
+		Id:      k.ID,
+		Name:    k.Name,
+		Scopes:  k.Scopes,
+		NukiIds: nukiIds,
+		Created: k.Created.Format(time.RFC3339),
+	}
+}
+
+// requestToken returns the bearer token of the authorization header or the token query parameter
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	tokens, ok := r.URL.Query()["token"]
+	if !ok || len(tokens) != 1 {
+		return ""
+	}
+	return tokens[0]
+}
+
+// authenticate resolves the token of the request to an identity. The token
+// is either the master token, an api key or a json web token. Requests
+// without token are authenticated by their client certificate.
+func (b *bridge) authenticate(r *http.Request) (*identity, error) {
+	token := requestToken(r)
+	if token == "" {
+		if id := b.certificateIdentity(r); id != nil {
+			return id, nil
+		}
+		return nil, errUnauthorized
+	}
+	if isJWT(token) {
+		id, err := b.verifyToken(token)
+		if err != nil {
+			log.WithError(err).WithField("source", r.RemoteAddr).Debugln("Invalid json web token")
+			return nil, errUnauthorized
+		}
+		return id, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) == 1 {
+		return masterIdentity, nil
+	}
+	hash := hashToken(token)
+	b.apiKeysMutex.RLock()
+	defer b.apiKeysMutex.RUnlock()
+	for _, k := range b.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) == 1 {
+			return k.identity(), nil
+		}
+	}
+	return nil, errUnauthorized
+}
+
+// authorizeRoute checks the scope and lock of the matched api route
+func authorizeRoute(id *identity, r *http.Request) error {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return errForbidden
+	}
+	required, ok := routeScopes[route.GetName()]
+	if !ok {
+		if !id.hasScope(ScopeAdmin) {
+			return errForbidden
+		}
+		return nil
+	}
+	if !id.hasScope(required.scope) {
+		return errForbidden
+	}
+	if !required.lockParam {
+		return nil
+	}
+	lockID := mux.Vars(r)["id"]
+	if lockID == "" {
+		lockID = r.URL.Query().Get("nukiId")
+	}
+	nukiId, err := strconv.ParseUint(lockID, 10, 32)
+	if err != nil {
+		// Invalid ids are rejected by the service itself, as long as all locks are allowed
+		if len(id.NukiIds) == 0 {
+			return nil
+		}
+		return errForbidden
+	}
+	if route.GetName() == "LockActionGet" {
+		action, err := strconv.ParseUint(r.URL.Query().Get("action"), 10, 8)
+		if err != nil || !id.allowsAction(uint32(nukiId), enums.LockAction(action)) {
+			return errForbidden
+		}
+		return nil
+	}
+	if !id.allowsLock(uint32(nukiId)) {
+		return errForbidden
+	}
+	return nil
+}
+
+// authorize is the middleware of the api router
+func (b *bridge) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.rejectBanned(w, r) {
+			return
+		}
+		id, err := b.authenticate(r)
+		if err != nil {
+			b.limiter.authFailed(sourceAddress(r))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			log.WithField("source", r.RemoteAddr).Warningln("Unauthorized request")
+			return
+		}
+		ctx := api.WithCaller(withIdentity(r.Context(), id), id.Name)
+		if err := authorizeRoute(id, r); err != nil {
+			b.auditRequest(r, id, requestParams(r), http.StatusForbidden)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			log.WithField("source", r.RemoteAddr).WithField("identity", id.Name).WithField("uri", r.URL.Path).Warningln("Forbidden request")
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && route.GetName() == "LockActionGet" {
+			nukiId, _ := strconv.ParseUint(r.URL.Query().Get("nukiId"), 10, 32)
+			if !b.limiter.allowLockAction(id.Name, uint32(nukiId)) {
+				b.auditRequest(r, id, requestParams(r), http.StatusTooManyRequests)
+				http.Error(w, errRateLimited.Error(), http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope protects handlers outside of the api router
+func (b *bridge) requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.rejectBanned(w, r) {
+			return
+		}
+		id, err := b.authenticate(r)
+		if err != nil {
+			b.limiter.authFailed(sourceAddress(r))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			log.WithField("source", r.RemoteAddr).Warningln("Unauthorized request")
+			return
+		}
+		if !id.hasScope(scope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+	})
+}
+
+func (b *bridge) addAPIKey(name string, scopes []string, nukiIds []uint32) (*apiKey, string, error) {
+	if name == "" {
+		return nil, "", errors.New("Name is required")
+	}
+	if err := validateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	k := &apiKey{
+		ID:      id,
+		Name:    name,
+		Hash:    hashToken(token),
+		Scopes:  scopes,
+		NukiIds: nukiIds,
+		Created: time.Now(),
+	}
+	b.apiKeysMutex.Lock()
+	b.apiKeys = append(b.apiKeys, k)
+	b.apiKeysMutex.Unlock()
+	if err := b.saveConfig(); err != nil {
+		return nil, "", err
+	}
+	log.WithField("name", name).WithField("scopes", scopes).Infoln("Api key created")
+	return k, token, nil
+}
+
+func (b *bridge) removeAPIKey(id string) error {
+	b.apiKeysMutex.Lock()
+	found := false
+	for i, k := range b.apiKeys {
+		if k.ID == id {
+			b.apiKeys = append(b.apiKeys[:i], b.apiKeys[i+1:]...)
+			found = true
+			break
+		}
+	}
+	b.apiKeysMutex.Unlock()
+	if !found {
+		return fmt.Errorf("Api key %s not found", id)
+	}
+	log.WithField("id", id).Infoln("Api key revoked")
+	return b.saveConfig()
+}
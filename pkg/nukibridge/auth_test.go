@@ -0,0 +1,134 @@
+package nukibridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{[]string{ScopeAdmin}, ScopeUnlatch, true},
+		{[]string{ScopeRead}, ScopeRead, true},
+		{[]string{ScopeRead}, ScopeLock, false},
+		{[]string{ScopeLock}, ScopeRead, false},
+		{[]string{ScopeUnlatch}, ScopeLock, true},
+		{[]string{ScopeUnlock}, ScopeUnlock, true},
+		{[]string{ScopeUnlock}, ScopeUnlatch, false},
+		{[]string{ScopeUnlatch}, ScopeAdmin, false},
+		{[]string{ScopeRead, ScopeLock}, ScopeLock, true},
+		{nil, ScopeRead, false},
+	}
+	for _, tt := range tests {
+		id := &identity{Scopes: tt.scopes}
+		if got := id.hasScope(tt.scope); got != tt.want {
+			t.Errorf("scopes %v have %s: %v, want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestAllowsAction(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     identity
+		nukiId uint32
+		action enums.LockAction
+		want   bool
+	}{
+		{"lock scope locks", identity{Scopes: []string{ScopeLock}}, 1, enums.LockActionLock, true},
+		{"lock scope full locks", identity{Scopes: []string{ScopeLock}}, 1, enums.LockActionFullLock, true},
+		{"lock scope can't unlock", identity{Scopes: []string{ScopeLock}}, 1, enums.LockActionUnlock, false},
+		{"unlock scope lock n go", identity{Scopes: []string{ScopeUnlock}}, 1, enums.LockActionLocknGo, true},
+		{"unlock scope can't unlatch", identity{Scopes: []string{ScopeUnlock}}, 1, enums.LockActionUnlatch, false},
+		{"fob actions need unlatch", identity{Scopes: []string{ScopeUnlock}}, 1, enums.LockActionFobAction1, false},
+		{"unlatch scope fob action", identity{Scopes: []string{ScopeUnlatch}}, 1, enums.LockActionFobAction2, true},
+		{"allowed lock", identity{Scopes: []string{ScopeAdmin}, NukiIds: []uint32{1, 2}}, 2, enums.LockActionUnlock, true},
+		{"other lock", identity{Scopes: []string{ScopeAdmin}, NukiIds: []uint32{1}}, 2, enums.LockActionLock, false},
+		{"allowed action", identity{Scopes: []string{ScopeUnlatch}, Actions: []enums.LockAction{enums.LockActionUnlock}}, 1, enums.LockActionUnlock, true},
+		{"other action", identity{Scopes: []string{ScopeUnlatch}, Actions: []enums.LockAction{enums.LockActionUnlock}}, 1, enums.LockActionLock, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.id.allowsAction(tt.nukiId, tt.action); got != tt.want {
+				t.Errorf("allowsAction %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRoute(t *testing.T) {
+	readOne := &identity{Scopes: []string{ScopeRead}, NukiIds: []uint32{1}}
+	readAll := &identity{Scopes: []string{ScopeRead}}
+	lockAll := &identity{Scopes: []string{ScopeLock}}
+	tests := []struct {
+		name   string
+		id     *identity
+		method string
+		target string
+		want   error
+	}{
+		{"master", masterIdentity, http.MethodDelete, "/locks/2", nil},
+		{"read listed route", readOne, http.MethodGet, "/locks", nil},
+		{"read allowed lock", readOne, http.MethodGet, "/locks/1", nil},
+		{"read other lock", readOne, http.MethodGet, "/locks/2", errForbidden},
+		{"read invalid lock id", readOne, http.MethodGet, "/locks/abc", errForbidden},
+		{"invalid lock id of all locks", readAll, http.MethodGet, "/locks/abc", nil},
+		{"lock can't read", lockAll, http.MethodGet, "/locks/1", errForbidden},
+		{"admin route", lockAll, http.MethodDelete, "/locks/1", errForbidden},
+		{"read can't lock", readOne, http.MethodGet, "/lockAction?nukiId=1&action=2", errForbidden},
+		{"lock action", lockAll, http.MethodGet, "/lockAction?nukiId=1&action=2", nil},
+		{"lock scope can't unlock", lockAll, http.MethodGet, "/lockAction?nukiId=1&action=1", errForbidden},
+		{"missing action", lockAll, http.MethodGet, "/lockAction?nukiId=1", errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got error
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				got = authorizeRoute(tt.id, r)
+			}
+			router := mux.NewRouter()
+			router.Methods(http.MethodGet).Path("/locks").Name("LocksGet").HandlerFunc(handler)
+			router.Methods(http.MethodGet).Path("/locks/{id}").Name("LocksIdGet").HandlerFunc(handler)
+			router.Methods(http.MethodDelete).Path("/locks/{id}").Name("LocksIdDelete").HandlerFunc(handler)
+			router.Methods(http.MethodGet).Path("/lockAction").Name("LockActionGet").HandlerFunc(handler)
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.target, nil))
+			if got != tt.want {
+				t.Errorf("authorizeRoute %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		target string
+		want   string
+	}{
+		{"bearer", "Bearer abc", "/", "abc"},
+		{"query", "", "/?token=abc", "abc"},
+		{"header wins", "Bearer abc", "/?token=def", "abc"},
+		{"repeated query", "", "/?token=abc&token=def", ""},
+		{"other scheme", "Basic abc", "/", ""},
+		{"none", "", "/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := requestToken(r); got != tt.want {
+				t.Errorf("token %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,44 +7,101 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/go-ble/ble"
 	"github.com/go-ble/ble/linux"
 	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
 	"github.com/mapero/nuki-bridge/pkg/nukibridge/assets/templates"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
 	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
 )
 
 var (
 	filename = "bridge.json"
+
+	errDeviceUnavailable = errors.New("Bluetooth device is not available")
+	errAdminPINRequired  = errors.New("Admin PIN of the lock is not set, set it by PUT /locks/{id} or remove the lock with force=true")
+	errLockNotRemoved    = errors.New("Lock was not removed")
 )
 
 type Bridge interface {
 	GetLocks() map[uint]*lock
 	GetLock(id uint) (*lock, error)
+	ImportIdentity(data []byte, passphrase string, force bool) (api.IdentityImportResult, error)
+}
+
+// Options contains the optional settings of the bridge
+type Options struct {
+	// EventHistorySize is the number of events kept for replay to reconnecting clients
+	EventHistorySize int
+	// EventHistoryAge is the maximum age of events kept for replay
+	EventHistoryAge time.Duration
+	// MQTT publishes lock states and events and accepts commands if a broker is set
+	MQTT MQTTOptions
+	// Influx exports lock telemetry to influxdb if an url is set
+	Influx InfluxOptions
+	// PublicURL is the base of links handed out to guests, e.g. https://door.example.com
+	PublicURL string
+	// RateLimit throttles failed authentications and lock actions
+	RateLimit RateLimitOptions
+	// HistorySyncInterval is the time between synchronizations of the lock logs into the history store, disabled if 0
+	HistorySyncInterval time.Duration
+	// HistoryBackfill is the maximum number of log entries read on the first synchronization of a lock, all if 0
+	HistoryBackfill int
+	// TLS serves the api by https if enabled
+	TLS TLSOptions
+	// WebsocketOrigins are the origins of pages allowed to open websockets besides the bridge host, * allows all
+	WebsocketOrigins []string
+	// HealthToken protects the health endpoints, they are public if empty
+	HealthToken string
+	// HealthLockTimeout marks the bridge as not ready if a lock did not advertise for longer, disabled if 0
+	HealthLockTimeout time.Duration
+	// ConfigBackups is the number of previous configurations kept, disabled if 0
+	ConfigBackups int
+	// Passphrase encrypts the keys in the configuration if set
+	Passphrase string
 }
 
 type bridge struct {
-	PublicKey      [32]byte       `json:"public_key"`
-	PrivateKey     [32]byte       `json:"private_key"`
-	Locks          map[uint]*lock `json:"locks"`
-	dir            string
-	service        *NukiBridgeService
-	advCh          map[string]chan ble.Advertisement
-	deviceLock     chan bool
-	cancelScan     context.CancelFunc
-	scanCtx        context.Context
-	token          string
-	port           string
-	skipAdv        chan bool
-	pairingEnabled bool
+	PublicKey        [32]byte       `json:"public_key"`
+	PrivateKey       [32]byte       `json:"private_key"`
+	Locks            map[uint]*lock `json:"locks"`
+	locksMutex       sync.RWMutex
+	dir              string
+	service          *NukiBridgeService
+	advCh            map[string]chan ble.Advertisement
+	deviceLock       chan bool
+	cancelScan       context.CancelFunc
+	scanCtx          context.Context
+	token            string
+	port             string
+	skipAdv          chan bool
+	pairingEnabled   bool
+	options          Options
+	mqtt             *mqttPublisher
+	influx           *influxExporter
+	health           health
+	apiKeysMutex     sync.RWMutex
+	apiKeys          []*apiKey
+	jwtKey           []byte
+	clientCertsMutex sync.RWMutex
+	clientCerts      []*clientCert
+	configMutex      sync.Mutex // guards the key pair and writes of the configuration file
+	savedKeys        string     // fingerprint of the keys and locks last written, see keysFingerprint
+	secrets          *secretKey
+	limiter          *rateLimiter
+	audit            *auditLog
+	history          *historyStore
+	guests           guests
+	rotation         keyRotation
+	pairing          pairingJobs
 }
 
 func (b *bridge) EnablePairing() {
@@ -52,37 +109,67 @@ func (b *bridge) EnablePairing() {
 		return
 	}
 	b.pairingEnabled = true
-	timer := time.NewTimer(10 * time.Second)
+	timeout := 10 * time.Second
+	timer := time.NewTimer(timeout)
 	go func() {
 		<-timer.C
 		b.DisablePairing()
 	}()
 	log.Infoln("Pairing mode enabled for 10 sec")
-
+	b.publish(Event{
+		Event: EventPairingWindowOpened,
+		Data: PairingWindowEvent{
+			LockEvent: newLockEvent(0),
+			Timeout:   timeout.Seconds(),
+		},
+	})
 }
 
 func (b *bridge) DisablePairing() {
+	if !b.pairingEnabled {
+		return
+	}
 	log.Infoln("Pairing mode disabled")
 	b.pairingEnabled = false
+	b.publish(Event{
+		Event: EventPairingWindowClosed,
+		Data: PairingWindowEvent{
+			LockEvent: newLockEvent(0),
+		},
+	})
+}
+
+// publish sends an event to all event subscribers
+func (b *bridge) publish(event Event) {
+	b.service.events.publish(event)
 }
 
 func (b *bridge) IsPairingEnabled() bool {
 	return b.pairingEnabled
 }
 
+// GetLocks returns a snapshot of the paired locks, safe to range over while locks are paired or removed
 func (b *bridge) GetLocks() map[uint]*lock {
-	return b.Locks
+	b.locksMutex.RLock()
+	defer b.locksMutex.RUnlock()
+	locks := make(map[uint]*lock, len(b.Locks))
+	for id, l := range b.Locks {
+		locks[id] = l
+	}
+	return locks
 }
 
 func (b *bridge) GetLock(id uint) (*lock, error) {
+	b.locksMutex.RLock()
 	l, ok := b.Locks[id]
+	b.locksMutex.RUnlock()
 	if !ok {
 		return nil, errors.New("Not found")
 	}
 	return l, nil
 }
 
-func NewBridge(dir string, port string, token string) (Bridge, error) {
+func NewBridge(dir string, port string, token string, options Options) (Bridge, error) {
 	log.Println("Creating new bridge")
 
 	b := &bridge{
@@ -92,8 +179,21 @@ func NewBridge(dir string, port string, token string) (Bridge, error) {
 		token:      token,
 		port:       port,
 		skipAdv:    make(chan bool, 1),
+		options:    options,
+		limiter:    newRateLimiter(options.RateLimit),
 	}
-	if _, err := os.Stat(path.Join(dir, filename)); err != nil {
+	b.service = NewBridgeService(b)
+	audit, err := newAuditLog(dir)
+	if err != nil {
+		return nil, err
+	}
+	b.audit = audit
+	history, err := openHistoryStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	b.history = history
+	if !b.configExists() {
 		if err := b.init(); err != nil {
 			return nil, err
 		}
@@ -106,16 +206,51 @@ func NewBridge(dir string, port string, token string) (Bridge, error) {
 			return nil, err
 		}
 	}
+	if len(b.jwtKey) == 0 {
+		key, err := newJWTKey()
+		if err != nil {
+			return nil, err
+		}
+		b.jwtKey = key
+		if err := b.saveConfig(); err != nil {
+			return nil, err
+		}
+	}
 	dev, err := linux.NewDevice()
+	b.health.setDevice(err)
 	if err != nil {
-		panic(err)
+		// The api keeps running to report the failure by the health endpoints
+		log.WithError(err).Errorln("Failed to open bluetooth device")
+		go b.startAPIService()
+		return b, nil
 	}
 
 	ble.SetDefaultDevice(dev)
 
 	log.Println("Initializing known locks")
-	for _, lock := range b.Locks {
-		lock.Init(b.PublicKey, b.PrivateKey)
+	for id, lock := range b.GetLocks() {
+		lock.nukiID = uint32(id)
+		lock.publish = b.publish
+		lock.Init(lock.bridgePublicKey, lock.bridgePrivateKey)
+	}
+	b.startHistorySync()
+
+	if options.MQTT.Broker != "" {
+		m, err := newMQTTPublisher(b, options.MQTT)
+		if err != nil {
+			return nil, err
+		}
+		b.mqtt = m
+		b.mqtt.start()
+	}
+
+	if options.Influx.URL != "" {
+		e, err := newInfluxExporter(b, options.Influx)
+		if err != nil {
+			return nil, err
+		}
+		b.influx = e
+		b.influx.start()
 	}
 
 	go b.startAPIService()
@@ -125,13 +260,21 @@ func NewBridge(dir string, port string, token string) (Bridge, error) {
 	return b, nil
 }
 
-func (b *bridge) aquireDevice() {
+// aquireDevice stops scanning and reserves the bluetooth device. It fails if the device
+// could not be opened, the device must not be released then.
+func (b *bridge) aquireDevice() error {
+	if !b.deviceAvailable() {
+		return errDeviceUnavailable
+	}
 	log.Println("Aquiring device lock")
+	start := time.Now()
 	b.deviceLock <- true
+	deviceLockWait.Observe(time.Since(start).Seconds())
 	b.cancelScan()
 	<-b.scanCtx.Done()
 	time.Sleep(500 * time.Millisecond)
 	log.Println("Lock aquired")
+	return nil
 }
 
 func (b *bridge) releaseDevice() {
@@ -143,23 +286,323 @@ func (b *bridge) releaseDevice() {
 func (b *bridge) addAndAuthorizeLock(address string) {
 	lock := &lock{
 		address: address,
+		publish: b.publish,
 	}
 	if err := lock.Connect(); err != nil {
 		log.WithField("lock", address).WithError(err).Errorln("Failed to add and authorize lock")
 		return
 	}
-	if err := lock.Authenticate(b.PublicKey, b.PrivateKey); err != nil {
+	pairing := AuditEntry{
+		Identity:  "bridge",
+		Source:    address,
+		Operation: "lockPaired",
+		Outcome:   AuditFailure,
+	}
+	pub, priv := b.keys()
+	if err := lock.Authenticate(pub, priv); err != nil {
 		log.WithField("lock", address).WithError(err).Errorln("Failed to add and authorize lock")
+		pairing.Error = err.Error()
+		b.audit.record(pairing)
 		return
 	}
 	config, err := lock.RequestConfig()
 	if err != nil {
 		log.WithField("lock", address).WithError(err).Errorln("Failed to add and authorize lock")
+		pairing.Error = err.Error()
+		b.audit.record(pairing)
 		return
 	}
 	lock.Disconnect()
-	b.Locks[uint(config.NukiID)] = lock
+	lock.nukiID = config.NukiID
+	lock.lastConfig = config
+	pairing.NukiId = config.NukiID
+	pairing.Params = map[string]string{"name": config.Name}
+	pairing.Outcome = AuditSuccess
+	b.audit.record(pairing)
+	b.addPairedLock(lock)
+}
+
+// addPairedLock adds the authorized lock to the bridge and announces it
+func (b *bridge) addPairedLock(l *lock) {
+	if watermark, err := b.history.watermark(l.nukiID); err == nil {
+		l.lastLogIndex = watermark
+	}
+	b.locksMutex.Lock()
+	b.Locks[uint(l.nukiID)] = l
+	b.locksMutex.Unlock()
 	b.saveConfig()
+	b.publish(Event{
+		Event: EventLockPaired,
+		Data: LockPairedEvent{
+			LockEvent: newLockEvent(l.nukiID),
+			Address:   l.address,
+			Name:      l.lastConfig.Name,
+		},
+	})
+}
+
+// removeLock removes the authorization of the bridge from the lock and forgets the lock.
+// Locks that can't be unpaired are only forgotten if forced, the lock keeps the authorization then.
+// errLockNotRemoved is returned with the result if the lock was kept.
+func (b *bridge) removeLock(id uint, force bool) (api.LockRemoval, error) {
+	l, err := b.GetLock(id)
+	if err != nil {
+		return api.LockRemoval{}, err
+	}
+	result := api.LockRemoval{NukiId: int32(l.nukiID)}
+	if l.adminPIN == 0 {
+		// The lock rejects the removal without admin PIN
+		err = errAdminPINRequired
+	} else if err = b.aquireDevice(); err == nil {
+		err = l.RemoveUserAuthorization(l.authorizationID)
+		l.Disconnect()
+		b.releaseDevice()
+	}
+	unpairing := AuditEntry{
+		Identity:  "bridge",
+		Source:    l.address,
+		Operation: "lockUnpaired",
+		NukiId:    l.nukiID,
+		Outcome:   AuditSuccess,
+	}
+	if err != nil {
+		log.WithField("nukiId", l.nukiID).WithError(err).Warnln("Failed to remove authorization from lock")
+		result.Error = err.Error()
+		unpairing.Outcome = AuditFailure
+		unpairing.Error = err.Error()
+	} else {
+		result.Unpaired = true
+	}
+	b.audit.record(unpairing)
+	if !result.Unpaired && !force {
+		return result, errLockNotRemoved
+	}
+	b.locksMutex.Lock()
+	delete(b.Locks, id)
+	b.locksMutex.Unlock()
+	if err := b.saveConfig(); err != nil {
+		return result, err
+	}
+	result.Removed = true
+	return result, nil
+}
+
+// lockAction runs the action on the lock and publishes its progress
+func (b *bridge) lockAction(id uint, action enums.LockAction, suffix string) (models.KeyturnerStates, error) {
+	return b.runLockAction(id, action, suffix, nil)
+}
+
+// lockActionNoWait runs the action in the background and returns as soon as the lock accepted it
+func (b *bridge) lockActionNoWait(id uint, action enums.LockAction, suffix string) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.runLockAction(id, action, suffix, func() {
+			select {
+			case result <- nil:
+			default:
+			}
+		})
+		// Locks may complete an action without accepting it first
+		select {
+		case result <- err:
+		default:
+		}
+	}()
+	return <-result
+}
+
+// runLockAction runs the action, accepted is called once the lock accepted it if set
+func (b *bridge) runLockAction(id uint, action enums.LockAction, suffix string, accepted func()) (models.KeyturnerStates, error) {
+	l, err := b.GetLock(id)
+	if err != nil {
+		return models.KeyturnerStates{}, err
+	}
+	event := LockActionEvent{
+		LockEvent:  newLockEvent(uint32(id)),
+		Action:     action,
+		ActionName: action.String(),
+		Suffix:     suffix,
+	}
+	b.publish(Event{Event: EventLockActionRequested, Data: event})
+
+	if err := b.aquireDevice(); err != nil {
+		event.LockEvent = newLockEvent(uint32(id))
+		event.Error = err.Error()
+		b.publish(Event{Event: EventLockActionFailed, Data: event})
+		return models.KeyturnerStates{}, err
+	}
+	previous := l.lastState
+	start := time.Now()
+	state, err := l.LockAction(action, suffix, func() {
+		event.LockEvent = newLockEvent(uint32(id))
+		b.publish(Event{Event: EventLockActionAccepted, Data: event})
+		if accepted != nil {
+			accepted()
+		}
+	})
+	b.releaseDevice()
+
+	event.LockEvent = newLockEvent(uint32(id))
+	event.Duration = time.Since(start).Seconds()
+	if err != nil {
+		event.Error = err.Error()
+		b.publish(Event{Event: EventLockActionFailed, Data: event})
+		return state, err
+	}
+	status := state.LastLockActionCompletionStatus
+	event.CompletionStatus = &status
+	event.CompletionStatusName = status.String()
+	if status != enums.CompletionStatusSuccess {
+		event.Error = status.String()
+		b.publish(Event{Event: EventLockActionFailed, Data: event})
+	} else {
+		b.publish(Event{Event: EventLockActionCompleted, Data: event})
+	}
+	b.notifyState(l, previous, state, CallbackEventActionCompleted)
+	return state, nil
+}
+
+// refreshState reads the current state of the lock and notifies about changes
+func (b *bridge) refreshState(l *lock) (models.KeyturnerStates, error) {
+	previous := l.lastState
+	if err := b.aquireDevice(); err != nil {
+		return previous, err
+	}
+	l.Connect()
+	state, err := l.RequestKeyturnerState()
+	if err != nil {
+		l.Disconnect()
+		b.releaseDevice()
+		return state, err
+	}
+	if !previous.CurrentTime.IsZero() && previous.ConfigUpdateCount != state.ConfigUpdateCount {
+		if _, err := l.RequestConfig(); err != nil {
+			log.WithError(err).Warnln("Failed to update lock config")
+		}
+	}
+	l.Disconnect()
+	b.releaseDevice()
+	log.WithField("state", fmt.Sprintf("%+v", state)).WithField("nukiID", l.nukiID).Debugln("Received state")
+	b.notifyState(l, previous, state)
+	return state, nil
+}
+
+// notifyState informs callbacks and event subscribers about a refreshed lock state
+func (b *bridge) notifyState(l *lock, previous models.KeyturnerStates, state models.KeyturnerStates, kinds ...CallbackEventKind) {
+	observeState(l, state)
+	b.service.notifyCallbacks(CallbackEvent{
+		Kinds:     append(stateEventKinds(previous, state), kinds...),
+		NukiId:    l.nukiID,
+		Name:      l.lastConfig.Name,
+		Timestamp: time.Now(),
+		Object: api.CallbackObject{
+			DeviceType:      0x02,
+			BatteryCritical: state.CriticalBatteryState,
+			Mode:            int32(state.NukiState),
+			NukiId:          int32(l.nukiID),
+			State:           int32(state.LockState),
+			StateName:       state.LockState.String(),
+		},
+		State: state,
+	})
+	b.publish(Event{
+		Event: EventState,
+		Data: StateEvent{
+			LockEvent:       newLockEvent(l.nukiID),
+			KeyturnerStates: state,
+		},
+	})
+
+	// Without a previous state there is nothing to compare with
+	if previous.CurrentTime.IsZero() {
+		return
+	}
+	if previous.CriticalBatteryState != state.CriticalBatteryState {
+		name := EventBatteryCriticalClear
+		if state.CriticalBatteryState {
+			name = EventBatteryCriticalRaised
+		}
+		b.publish(Event{
+			Event: name,
+			Data: BatteryEvent{
+				LockEvent:       newLockEvent(l.nukiID),
+				BatteryCritical: state.CriticalBatteryState,
+			},
+		})
+	}
+	if previous.DoorSensorState != state.DoorSensorState {
+		name := ""
+		switch state.DoorSensorState {
+		case enums.DoorSensorStateDoorOpened:
+			name = EventDoorOpened
+		case enums.DoorSensorStateDoorClosed:
+			name = EventDoorClosed
+		}
+		if name != "" {
+			b.publish(Event{
+				Event: name,
+				Data: DoorSensorEvent{
+					LockEvent:           newLockEvent(l.nukiID),
+					DoorSensorState:     state.DoorSensorState,
+					DoorSensorStateName: state.DoorSensorState.String(),
+				},
+			})
+		}
+	}
+	if previous.ConfigUpdateCount != state.ConfigUpdateCount {
+		event := ConfigChangedEvent{
+			LockEvent:         newLockEvent(l.nukiID),
+			ConfigUpdateCount: state.ConfigUpdateCount,
+		}
+		if l.lastConfig.NukiID == l.nukiID {
+			config := toLockConfig(fmt.Sprint(l.nukiID), l.lastConfig)
+			event.Config = &config
+		}
+		b.publish(Event{Event: EventConfigChanged, Data: event})
+	}
+}
+
+// notifyLogEntries publishes all log entries newer than the last known one
+func (b *bridge) notifyLogEntries(l *lock, entries []models.LogEntry) {
+	last := l.lastLogIndex
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Index <= l.lastLogIndex {
+			continue
+		}
+		if entry.Index > last {
+			last = entry.Index
+		}
+		// The first read only establishes the baseline
+		if l.lastLogIndex == 0 {
+			continue
+		}
+		b.publish(Event{
+			Event: EventLogEntry,
+			Data: LogEntryEvent{
+				LockEvent: newLockEvent(l.nukiID),
+				Entry:     entry,
+			},
+		})
+		logEntry := entry
+		b.service.notifyCallbacks(CallbackEvent{
+			Kinds:     []CallbackEventKind{CallbackEventLogEntry},
+			NukiId:    l.nukiID,
+			Name:      l.lastConfig.Name,
+			Timestamp: time.Now(),
+			Object: api.CallbackObject{
+				DeviceType:      0x02,
+				BatteryCritical: l.lastState.CriticalBatteryState,
+				Mode:            int32(l.lastState.NukiState),
+				NukiId:          int32(l.nukiID),
+				State:           int32(l.lastState.LockState),
+				StateName:       l.lastState.LockState.String(),
+			},
+			State:    l.lastState,
+			LogEntry: &logEntry,
+		})
+	}
+	l.lastLogIndex = last
 }
 
 func (b *bridge) startAdvertisingMonitor() {
@@ -171,15 +614,23 @@ func (b *bridge) startAdvertisingMonitor() {
 		select {
 		case b.skipAdv <- true:
 			defer func() { <-b.skipAdv }()
-			if b.IsPairingEnabled() && len(a.ServiceData()) > 0 && a.ServiceData()[0].UUID.String() == "a92ee100550111e4916c0800200c9a66" {
+			advertisements.WithLabelValues("handled").Inc()
+			if len(a.ServiceData()) > 0 && a.ServiceData()[0].UUID.String() == pairingServiceData {
+				address := strings.ToUpper(a.Addr().String())
+				if b.rotation.pairingWindowOpened(address) || (!b.pairedAddress(address) && b.pairing.pairingWindowOpened(address)) {
+					return
+				}
+			}
+			if b.IsPairingEnabled() && len(a.ServiceData()) > 0 && a.ServiceData()[0].UUID.String() == pairingServiceData {
 				address := strings.ToUpper(a.Addr().String())
-				for _, lock := range b.Locks {
-					if lock.address == address {
-						return
-					}
+				if b.pairedAddress(address) {
+					return
 				}
 				log.WithField("lock", address).Infoln("Adding and authorizing lock")
-				b.aquireDevice()
+				if err := b.aquireDevice(); err != nil {
+					log.WithError(err).Errorln("Failed to add and authorize lock")
+					return
+				}
 				b.addAndAuthorizeLock(address)
 				b.releaseDevice()
 			}
@@ -195,39 +646,17 @@ func (b *bridge) startAdvertisingMonitor() {
 					log.WithError(err).Debugln("Skipping")
 					return
 				}
+				lastAdvertisement.WithLabelValues(fmt.Sprint(beacon.NukiID)).SetToCurrentTime()
+				lock.advertised()
 				if !beacon.Dirty || time.Since(lock.lastState.CurrentTime).Seconds() < 2 {
 					return
 				}
-				b.aquireDevice()
-				lock.Connect()
-				state, err := lock.RequestKeyturnerState()
-				if err != nil {
+				if _, err := b.refreshState(lock); err != nil {
 					log.WithError(err).Errorln("Failed to update lock state due to error")
 				}
-				lock.Disconnect()
-				b.releaseDevice()
-				log.WithField("state", fmt.Sprintf("%+v", state)).WithField("nukiID", beacon.NukiID).Debugln("Received state")
-				b.service.callbackNotifier <- api.CallbackObject{
-					DeviceType:      0x02,
-					BatteryCritical: state.CriticalBatteryState,
-					Mode:            int32(state.NukiState),
-					NukiId:          int32(beacon.NukiID),
-					State:           int32(state.LockState),
-					StateName:       state.LockState.String(),
-				}
-				data := struct {
-					models.KeyturnerStates
-					NukiId uint32
-				}{
-					state,
-					beacon.NukiID,
-				}
-				b.service.sseNotifier <- SseEvent{
-					Event: "state",
-					Data:  data,
-				}
 			}
 		default:
+			advertisements.WithLabelValues("skipped").Inc()
 			log.Debugln("Skipping advertisment")
 			return
 		}
@@ -236,7 +665,15 @@ func (b *bridge) startAdvertisingMonitor() {
 	b.scanCtx = ctx
 	b.cancelScan = cancel
 	go func() {
-		ble.Scan(b.scanCtx, true, advHandler, filter)
+		b.health.setScanning(true, nil)
+		err := ble.Scan(ctx, true, advHandler, filter)
+		if err != nil && ctx.Err() == nil {
+			log.WithError(err).Errorln("Failed to scan for advertisements")
+			b.health.setScanning(false, err)
+		} else if b.scanCtx == ctx {
+			// A cancelled scan only counts if no new scan was started meanwhile
+			b.health.setScanning(false, nil)
+		}
 	}()
 }
 
@@ -245,31 +682,19 @@ func (b *bridge) startAPIService() {
 
 	router := mux.NewRouter()
 
-	var validateToken = func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token, ok := r.URL.Query()["token"]
-			if !ok || len(token) != 1 || token[0] != b.token {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				log.WithField("source", r.RemoteAddr).Warningln("Unauthorized request")
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-
 	rewrite := func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = fmt.Sprintf("%s%s", "/api/v1", r.URL.Path)
 		router.ServeHTTP(w, r)
 	}
 
-	b.service = NewBridgeService(b)
 	inofficialController := api.NewInofficialApiController(b.service)
 	officialController := api.NewOfficialApiController(b.service)
 	eventsController := api.NewEventsApiController(b.service)
 
 	apiRouter := api.NewRouter(inofficialController, officialController, eventsController)
 	apiRouter.Use(mux.CORSMethodMiddleware(apiRouter))
-	apiRouter.Use(validateToken)
+	apiRouter.Use(b.authorize)
+	apiRouter.Use(b.auditRequests)
 
 	fileServer := http.FileServer(templates.Assets)
 
@@ -279,8 +704,12 @@ func (b *bridge) startAPIService() {
 	router.PathPrefix("/callback").HandlerFunc(rewrite)
 
 	router.PathPrefix("/api/v1/").Handler(apiRouter)
+	router.Handle("/metrics", b.requireScope(ScopeRead, promhttp.Handler()))
+	router.HandleFunc("/guest/{token}", b.guestPageHandler).Methods(http.MethodGet)
+	router.HandleFunc("/guest/{token}/action", b.guestActionHandler).Methods(http.MethodPost)
+	router.Handle("/healthz", b.healthHandler(false))
+	router.Handle("/readyz", b.healthHandler(true))
 	router.PathPrefix("/").Handler(fileServer)
 
-	log.WithField("port", b.port).Infoln("serving web services")
-	log.Fatal(http.ListenAndServe(b.port, router))
+	log.Fatal(b.serve(router))
 }
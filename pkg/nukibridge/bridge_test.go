@@ -0,0 +1,57 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+)
+
+func TestRemoveLock(t *testing.T) {
+	tests := []struct {
+		name         string
+		adminPIN     uint
+		target       string
+		wantStatus   int
+		wantRemoved  bool
+		wantUnpaired bool
+		wantError    string
+	}{
+		{"without admin PIN", 0, "/api/v1/locks/42", http.StatusConflict, false, false, errAdminPINRequired.Error()},
+		{"forced without admin PIN", 0, "/api/v1/locks/42?force=true", http.StatusOK, true, false, errAdminPINRequired.Error()},
+		{"device unavailable", 1234, "/api/v1/locks/42", http.StatusConflict, false, false, errDeviceUnavailable.Error()},
+		{"forced with device unavailable", 1234, "/api/v1/locks/42?force=true", http.StatusOK, true, false, errDeviceUnavailable.Error()},
+		{"unknown lock", 1234, "/api/v1/locks/7?force=true", http.StatusInternalServerError, false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newConfigTestBridge(t, newConfigTestDir(t), Options{})
+			var err error
+			if b.audit, err = newAuditLog(b.dir); err != nil {
+				t.Fatal(err)
+			}
+			b.Locks[42] = &lock{nukiID: 42, address: "AA:BB:CC:DD:EE:FF", adminPIN: tt.adminPIN}
+			router := api.NewRouter(api.NewInofficialApiController(b.service))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, tt.target, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if _, err := b.GetLock(42); (err != nil) != tt.wantRemoved {
+				t.Errorf("lock removed %v, want %v", err != nil, tt.wantRemoved)
+			}
+			if w.Code == http.StatusInternalServerError {
+				return
+			}
+			var result api.LockRemoval
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Removed != tt.wantRemoved || result.Unpaired != tt.wantUnpaired || result.Error != tt.wantError {
+				t.Errorf("result %+v, want removed %v unpaired %v error %q", result, tt.wantRemoved, tt.wantUnpaired, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package nukibridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type CallbackEventKind string
+
+const (
+	CallbackEventStateChanged    CallbackEventKind = "stateChanged"
+	CallbackEventBatteryCritical CallbackEventKind = "batteryCritical"
+	CallbackEventDoorSensor      CallbackEventKind = "doorSensor"
+	CallbackEventActionCompleted CallbackEventKind = "actionCompleted"
+	CallbackEventLogEntry        CallbackEventKind = "logEntry"
+)
+
+const (
+	CallbackFormatJSON     = "json"
+	CallbackFormatTemplate = "template"
+)
+
+// callbackQueueSize is the number of events buffered per callback, further events are dropped
+const callbackQueueSize = 16
+
+// callbackClient keeps a stalled receiver from blocking the delivery of further events
+var callbackClient = &http.Client{Timeout: 10 * time.Second}
+
+var callbackEventKinds = map[CallbackEventKind]bool{
+	CallbackEventStateChanged:    true,
+	CallbackEventBatteryCritical: true,
+	CallbackEventDoorSensor:      true,
+	CallbackEventActionCompleted: true,
+	CallbackEventLogEntry:        true,
+}
+
+// CallbackEvent is passed to every callback. It is the data of payload templates.
+type CallbackEvent struct {
+	Kinds     []CallbackEventKind
+	NukiId    uint32
+	Name      string
+	Timestamp time.Time
+	Object    api.CallbackObject
+	State     models.KeyturnerStates
+	LogEntry  *models.LogEntry
+}
+
+// Is reports whether the event is of the given kind, e.g. {{if .Is "doorSensor"}}
+func (e CallbackEvent) Is(kind CallbackEventKind) bool {
+	for _, k := range e.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type callback struct {
+	id          int
+	url         string
+	nukiIds     []uint32
+	events      []CallbackEventKind
+	states      []enums.LockState
+	format      string
+	contentType string
+	body        string
+	template    *template.Template
+	// queue is drained by the worker of the callback, one receiver can't delay the others
+	queue chan CallbackEvent
+}
+
+func newCallback(cfg api.CallbackConfig) (*callback, error) {
+	if cfg.Url == "" {
+		return nil, errors.New("Callback url is missing")
+	}
+	c := &callback{
+		url:         cfg.Url,
+		format:      cfg.Format,
+		contentType: cfg.ContentType,
+		body:        cfg.Template,
+	}
+	for _, id := range cfg.NukiIds {
+		c.nukiIds = append(c.nukiIds, uint32(id))
+	}
+	for _, event := range cfg.Events {
+		kind := CallbackEventKind(event)
+		if !callbackEventKinds[kind] {
+			return nil, fmt.Errorf("Unknown callback event %s", event)
+		}
+		c.events = append(c.events, kind)
+	}
+	for _, state := range cfg.States {
+		c.states = append(c.states, enums.LockState(state))
+	}
+	switch c.format {
+	case "", CallbackFormatJSON:
+		c.format = CallbackFormatJSON
+		if c.contentType == "" {
+			c.contentType = "application/json"
+		}
+	case CallbackFormatTemplate:
+		tmpl, err := template.New(c.url).Parse(c.body)
+		if err != nil {
+			return nil, err
+		}
+		c.template = tmpl
+		if c.contentType == "" {
+			c.contentType = "text/plain"
+		}
+	default:
+		return nil, fmt.Errorf("Unknown callback format %s", c.format)
+	}
+	return c, nil
+}
+
+func (c *callback) config() api.CallbackConfig {
+	cfg := api.CallbackConfig{
+		Id:          int32(c.id),
+		Url:         c.url,
+		Format:      c.format,
+		ContentType: c.contentType,
+		Template:    c.body,
+	}
+	for _, id := range c.nukiIds {
+		cfg.NukiIds = append(cfg.NukiIds, int32(id))
+	}
+	for _, kind := range c.events {
+		cfg.Events = append(cfg.Events, string(kind))
+	}
+	for _, state := range c.states {
+		cfg.States = append(cfg.States, int32(state))
+	}
+	return cfg
+}
+
+// matches returns true if the event passes all filters. Empty filters match everything.
+func (c *callback) matches(event CallbackEvent) bool {
+	if len(c.nukiIds) > 0 {
+		found := false
+		for _, id := range c.nukiIds {
+			found = found || id == event.NukiId
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(c.events) > 0 {
+		found := false
+		for _, kind := range c.events {
+			found = found || event.Is(kind)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(c.states) > 0 {
+		found := false
+		for _, state := range c.states {
+			found = found || int32(state) == event.Object.State
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *callback) payload(event CallbackEvent) ([]byte, error) {
+	if c.template == nil {
+		return json.Marshal(event.Object)
+	}
+	buf := new(bytes.Buffer)
+	if err := c.template.Execute(buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// start runs the worker delivering the queued events until the callback is stopped
+func (c *callback) start() {
+	c.queue = make(chan CallbackEvent, callbackQueueSize)
+	go func(queue chan CallbackEvent) {
+		for event := range queue {
+			if err := c.send(event); err != nil {
+				callbackDeliveries.WithLabelValues("failure").Inc()
+				log.WithError(err).WithField("callback", c.id).Errorln("Failed to send callback event")
+				continue
+			}
+			callbackDeliveries.WithLabelValues("success").Inc()
+		}
+	}(c.queue)
+}
+
+func (c *callback) stop() {
+	close(c.queue)
+}
+
+// enqueue never blocks, the event is dropped if the queue of the callback is full
+func (c *callback) enqueue(event CallbackEvent) {
+	select {
+	case c.queue <- event:
+	default:
+		callbackDeliveries.WithLabelValues("dropped").Inc()
+		log.WithField("callback", c.id).Warnln("Callback too slow, dropping event")
+	}
+}
+
+func (c *callback) send(event CallbackEvent) error {
+	body, err := c.payload(event)
+	if err != nil {
+		return err
+	}
+	resp, err := callbackClient.Post(c.url, c.contentType, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Callback responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// stateEventKinds derives the callback event kinds of a state refresh
+func stateEventKinds(previous models.KeyturnerStates, current models.KeyturnerStates) []CallbackEventKind {
+	kinds := []CallbackEventKind{CallbackEventStateChanged}
+	if previous.CriticalBatteryState != current.CriticalBatteryState {
+		kinds = append(kinds, CallbackEventBatteryCritical)
+	}
+	if previous.DoorSensorState != current.DoorSensorState {
+		kinds = append(kinds, CallbackEventDoorSensor)
+	}
+	// Actions triggered by the bridge itself are added by the caller
+	if isTransitionalState(previous.LockState) && !isTransitionalState(current.LockState) {
+		kinds = append(kinds, CallbackEventActionCompleted)
+	}
+	return kinds
+}
+
+func isTransitionalState(state enums.LockState) bool {
+	switch state {
+	case enums.LockStateUnlocking, enums.LockStateLocking, enums.LockStateUnlatching:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package nukibridge
+
+import (
+	"testing"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+func TestNewCallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         api.CallbackConfig
+		wantErr     bool
+		contentType string
+	}{
+		{"json by default", api.CallbackConfig{Url: "http://a"}, false, "application/json"},
+		{"template", api.CallbackConfig{Url: "http://a", Format: CallbackFormatTemplate, Template: "{{.NukiId}}"}, false, "text/plain"},
+		{"custom content type", api.CallbackConfig{Url: "http://a", Format: CallbackFormatTemplate, ContentType: "application/xml"}, false, "application/xml"},
+		{"missing url", api.CallbackConfig{}, true, ""},
+		{"unknown event", api.CallbackConfig{Url: "http://a", Events: []string{"exploded"}}, true, ""},
+		{"unknown format", api.CallbackConfig{Url: "http://a", Format: "xml"}, true, ""},
+		{"broken template", api.CallbackConfig{Url: "http://a", Format: CallbackFormatTemplate, Template: "{{.NukiId"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newCallback(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && c.contentType != tt.contentType {
+				t.Errorf("content type %s, want %s", c.contentType, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestCallbackMatches(t *testing.T) {
+	event := CallbackEvent{
+		Kinds:  []CallbackEventKind{CallbackEventStateChanged, CallbackEventDoorSensor},
+		NukiId: 42,
+		Object: api.CallbackObject{NukiId: 42, State: int32(enums.LockStateLocked)},
+	}
+	tests := []struct {
+		name string
+		cfg  api.CallbackConfig
+		want bool
+	}{
+		{"no filters", api.CallbackConfig{}, true},
+		{"lock", api.CallbackConfig{NukiIds: []int32{1, 42}}, true},
+		{"other lock", api.CallbackConfig{NukiIds: []int32{1}}, false},
+		{"event", api.CallbackConfig{Events: []string{"doorSensor"}}, true},
+		{"other event", api.CallbackConfig{Events: []string{"batteryCritical", "logEntry"}}, false},
+		{"state", api.CallbackConfig{States: []int32{int32(enums.LockStateLocked)}}, true},
+		{"other state", api.CallbackConfig{States: []int32{int32(enums.LockStateUnlocked)}}, false},
+		{"all filters", api.CallbackConfig{NukiIds: []int32{42}, Events: []string{"stateChanged"}, States: []int32{int32(enums.LockStateLocked)}}, true},
+		{"one filter fails", api.CallbackConfig{NukiIds: []int32{42}, Events: []string{"logEntry"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Url = "http://a"
+			c, err := newCallback(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.matches(event); got != tt.want {
+				t.Errorf("matches %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackPayload(t *testing.T) {
+	event := CallbackEvent{
+		Kinds:  []CallbackEventKind{CallbackEventDoorSensor},
+		NukiId: 42,
+		Name:   "Front door",
+		Object: api.CallbackObject{NukiId: 42, State: 1, StateName: "locked"},
+	}
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"json", "", "", `{"nukiId":42,"state":1,"stateName":"locked"}`, false},
+		{"template", CallbackFormatTemplate, "{{.Name}} is {{.Object.StateName}}", "Front door is locked", false},
+		{"template kinds", CallbackFormatTemplate, `{{if .Is "doorSensor"}}door{{else}}state{{end}}`, "door", false},
+		{"template error", CallbackFormatTemplate, "{{.Missing}}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newCallback(api.CallbackConfig{Url: "http://a", Format: tt.format, Template: tt.template})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.payload(event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("payload %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateEventKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous models.KeyturnerStates
+		current  models.KeyturnerStates
+		want     []CallbackEventKind
+	}{
+		{"unchanged", models.KeyturnerStates{}, models.KeyturnerStates{}, []CallbackEventKind{CallbackEventStateChanged}},
+		{"battery", models.KeyturnerStates{}, models.KeyturnerStates{CriticalBatteryState: true}, []CallbackEventKind{CallbackEventStateChanged, CallbackEventBatteryCritical}},
+		{"action completed", models.KeyturnerStates{LockState: enums.LockStateLocking}, models.KeyturnerStates{LockState: enums.LockStateLocked}, []CallbackEventKind{CallbackEventStateChanged, CallbackEventActionCompleted}},
+		{"action running", models.KeyturnerStates{LockState: enums.LockStateLocked}, models.KeyturnerStates{LockState: enums.LockStateUnlocking}, []CallbackEventKind{CallbackEventStateChanged}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stateEventKinds(tt.previous, tt.current)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kinds %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kinds %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCallbackQueueDropsOnOverflow(t *testing.T) {
+	c, err := newCallback(api.CallbackConfig{Url: "http://a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a worker nothing is taken from the queue
+	c.queue = make(chan CallbackEvent, callbackQueueSize)
+	for i := 0; i < callbackQueueSize+5; i++ {
+		c.enqueue(CallbackEvent{NukiId: uint32(i)})
+	}
+	if len(c.queue) != callbackQueueSize {
+		t.Fatalf("queue holds %d events, want %d", len(c.queue), callbackQueueSize)
+	}
+	if first := <-c.queue; first.NukiId != 0 {
+		t.Errorf("first queued event is %d, want the oldest", first.NukiId)
+	}
+}
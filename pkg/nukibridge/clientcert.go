@@ -0,0 +1,144 @@
+package nukibridge
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// clientCert maps the subject of client certificates signed by the client ca to an identity
+type clientCert struct {
+	ID string
+	// Subject matches the common name or a dns, email or uri subject alternative name
+	Subject string
+	Scopes  []string
+	NukiIds []uint32
+	Created time.Time
+}
+
+func (c *clientCert) identity() *identity {
+	return &identity{
+		Name:    "cert:" + c.Subject,
+		Scopes:  c.Scopes,
+		NukiIds: c.NukiIds,
+	}
+}
+
+func (c *clientCert) toApi() api.ClientCert {
+	nukiIds := make([]int32, 0, len(c.NukiIds))
+	for _, id := range c.NukiIds {
+		nukiIds = append(nukiIds, int32(id))
+	}
+	return api.ClientCert{
+		Id:      c.ID,
+		Subject: c.Subject,
+		Scopes:  c.Scopes,
+		NukiIds: nukiIds,
+		Created: c.Created.Format(time.RFC3339),
+	}
+}
+
+// certificateSubjects returns the common name and all subject alternative names
+func certificateSubjects(cert *x509.Certificate) []string {
+	subjects := []string{}
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	subjects = append(subjects, cert.DNSNames...)
+	subjects = append(subjects, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return subjects
+}
+
+// loadClientCAs reads the PEM file of the ca signing client certificates
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// certificateIdentity returns the identity of the verified client certificate of the request,
+// nil if there is none or its subject is not mapped
+func (b *bridge) certificateIdentity(r *http.Request) *identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	subjects := certificateSubjects(r.TLS.VerifiedChains[0][0])
+	b.clientCertsMutex.RLock()
+	defer b.clientCertsMutex.RUnlock()
+	for _, c := range b.clientCerts {
+		for _, subject := range subjects {
+			if c.Subject == subject {
+				return c.identity()
+			}
+		}
+	}
+	log.WithField("source", r.RemoteAddr).WithField("subjects", subjects).Warningln("Client certificate without identity")
+	return nil
+}
+
+func (b *bridge) addClientCert(subject string, scopes []string, nukiIds []uint32) (*clientCert, error) {
+	if subject == "" {
+		return nil, errors.New("Subject is required")
+	}
+	if err := validateScopes(scopes); err != nil {
+		return nil, err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return nil, err
+	}
+	c := &clientCert{
+		ID:      id,
+		Subject: subject,
+		Scopes:  scopes,
+		NukiIds: nukiIds,
+		Created: time.Now(),
+	}
+	b.clientCertsMutex.Lock()
+	for _, existing := range b.clientCerts {
+		if existing.Subject == subject {
+			b.clientCertsMutex.Unlock()
+			return nil, fmt.Errorf("Subject %s is already mapped", subject)
+		}
+	}
+	b.clientCerts = append(b.clientCerts, c)
+	b.clientCertsMutex.Unlock()
+	if err := b.saveConfig(); err != nil {
+		return nil, err
+	}
+	log.WithField("subject", subject).WithField("scopes", scopes).Infoln("Client certificate identity created")
+	return c, nil
+}
+
+func (b *bridge) removeClientCert(id string) error {
+	b.clientCertsMutex.Lock()
+	found := false
+	for i, c := range b.clientCerts {
+		if c.ID == id {
+			b.clientCerts = append(b.clientCerts[:i], b.clientCerts[i+1:]...)
+			found = true
+			break
+		}
+	}
+	b.clientCertsMutex.Unlock()
+	if !found {
+		return fmt.Errorf("Client certificate identity %s not found", id)
+	}
+	log.WithField("id", id).Infoln("Client certificate identity removed")
+	return b.saveConfig()
+}
@@ -0,0 +1,57 @@
+package nukibridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCertificateSubjects(t *testing.T) {
+	uri, _ := url.Parse("spiffe://home/alice")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		DNSNames:       []string{"alice.local"},
+		EmailAddresses: []string{"alice@example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	want := []string{"alice", "alice.local", "alice@example.com", "spiffe://home/alice"}
+	if got := certificateSubjects(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("subjects %v, want %v", got, want)
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	b := &bridge{clientCerts: []*clientCert{
+		{ID: "1", Subject: "alice", Scopes: []string{ScopeLock}, NukiIds: []uint32{1}},
+		{ID: "2", Subject: "bob@example.com", Scopes: []string{ScopeAdmin}},
+	}}
+	verified := func(cert *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  string
+	}{
+		{"without tls", nil, ""},
+		{"without client certificate", &tls.ConnectionState{}, ""},
+		{"common name", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}), "cert:alice"},
+		{"email address", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "bob"}, EmailAddresses: []string{"bob@example.com"}}), "cert:bob@example.com"},
+		{"unmapped subject", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/locks", nil)
+			r.TLS = tt.state
+			id := b.certificateIdentity(r)
+			if (id == nil) != (tt.want == "") || (id != nil && id.Name != tt.want) {
+				t.Errorf("identity %+v, want %q", id, tt.want)
+			}
+		})
+	}
+}
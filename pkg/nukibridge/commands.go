@@ -1,5 +1,7 @@
 package nukibridge
 
+import "fmt"
+
 type Command uint16
 
 const (
@@ -22,3 +24,30 @@ const (
 	CmdLogEntry                    Command = 0x0032
 	// ...
 )
+
+var commandNames = map[Command]string{
+	CmdRequestData:                 "RequestData",
+	CmdPublicKey:                   "PublicKey",
+	CmdChallenge:                   "Challenge",
+	CmdAuthorizationAuthenticator:  "AuthorizationAuthenticator",
+	CmdAuthorizationData:           "AuthorizationData",
+	CmdAuthorizationID:             "AuthorizationID",
+	CmdRemoveUserAuthorization:     "RemoveUserAuthorization",
+	CmdRequestAuthorizationEntries: "RequestAuthorizationEntries",
+	CmdRequestConfig:               "RequestConfig",
+	CmdConfig:                      "Config",
+	CmdKeyturnerStates:             "KeyturnerStates",
+	CmdLockAction:                  "LockAction",
+	CmdStatus:                      "Status",
+	CmdErrorReport:                 "ErrorReport",
+	CmdAuthorizationIDConfirmation: "AuthorizationIDConfirmation",
+	CmdRequestLogEntries:           "RequestLogEntries",
+	CmdLogEntry:                    "LogEntry",
+}
+
+func (c Command) String() string {
+	if name, ok := commandNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Command(0x%04X)", uint16(c))
+}
@@ -1,21 +1,42 @@
 package nukibridge
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"time"
 
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/nacl/box"
 )
 
+// configVersion is the schema version of the configuration written by this bridge
+const configVersion = 1
+
+// configMigrations upgrade the raw configuration from the version of their index+1 to the next one,
+// configurations without version or with version 0 are version 1
+var configMigrations = []func(cfg map[string]interface{}) error{}
+
 type Configuration struct {
-	PrivateKey string                       `json:"privateKey"`
-	PublicKey  string                       `json:"publicKey"`
-	Locks      map[string]LockConfiguration `json:"locks"`
+	Version     int                          `json:"version"`
+	PrivateKey  string                       `json:"privateKey"`
+	PublicKey   string                       `json:"publicKey"`
+	Locks       map[string]LockConfiguration `json:"locks"`
+	ApiKeys     []ApiKeyConfiguration        `json:"apiKeys,omitempty"`
+	JWTKey      string                       `json:"jwtKey,omitempty"`
+	Grants      []*GuestGrant                `json:"grants,omitempty"`
+	ClientCerts []ClientCertConfiguration    `json:"clientCerts,omitempty"`
+	Callbacks   []api.CallbackConfig         `json:"callbacks,omitempty"`
+	// Encryption holds the private key, jwt key and lock keys if a passphrase is set
+	Encryption *EncryptionConfiguration `json:"encryption,omitempty"`
 }
 
 type LockConfiguration struct {
@@ -23,6 +44,26 @@ type LockConfiguration struct {
 	Address         string `json:"address"`
 	AuthorizationId string `json:"authorizationId"`
 	AdminPIN        uint   `json:"adminPIN"`
+	// BridgePublicKey and BridgePrivateKey are set if the lock is authorized with other keys than the bridge, e.g. after a failed key rotation
+	BridgePublicKey  string `json:"bridgePublicKey,omitempty"`
+	BridgePrivateKey string `json:"bridgePrivateKey,omitempty"`
+}
+
+type ApiKeyConfiguration struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Scopes  []string  `json:"scopes"`
+	NukiIds []uint32  `json:"nukiIds,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type ClientCertConfiguration struct {
+	ID      string    `json:"id"`
+	Subject string    `json:"subject"`
+	Scopes  []string  `json:"scopes"`
+	NukiIds []uint32  `json:"nukiIds,omitempty"`
+	Created time.Time `json:"created"`
 }
 
 func (b *bridge) init() error {
@@ -37,8 +78,13 @@ func (b *bridge) init() error {
 	if err != nil {
 		return err
 	}
-	b.PrivateKey = *priv
-	b.PublicKey = *pub
+	b.setKeys(*pub, *priv)
+	if b.options.Passphrase != "" {
+		// The keys are never written unencrypted
+		if b.secrets, err = newSecretKey(b.options.Passphrase); err != nil {
+			return err
+		}
+	}
 	if err := b.saveConfig(); err != nil {
 		return err
 	}
@@ -46,32 +92,60 @@ func (b *bridge) init() error {
 }
 
 func (b *bridge) loadConfig() error {
-	f, err := os.Open(path.Join(b.dir, filename))
+	cfg, rewrite, err := b.readConfig()
 	if err != nil {
 		return err
 	}
-	defer func() {
-		f.Sync()
-		f.Close()
-	}()
-	bytes, err := ioutil.ReadAll(f)
+	encrypt, err := b.unlockConfig(&cfg)
 	if err != nil {
 		return err
 	}
-	var cfg Configuration
-	if err := json.Unmarshal(bytes, &cfg); err != nil {
+	if err := b.applyConfig(cfg); err != nil {
 		return err
 	}
+	if !rewrite {
+		// A migrated or restored configuration is kept as backup
+		keys := keysFingerprint(b.currentConfig())
+		b.configMutex.Lock()
+		b.savedKeys = keys
+		b.configMutex.Unlock()
+	}
+	if encrypt {
+		if err := b.saveConfig(); err != nil {
+			return err
+		}
+		log.Infoln("Configuration encrypted, removing unencrypted backups")
+		return removeBackups(b.dir)
+	}
+	if rewrite {
+		// The previous file is kept as backup
+		return b.saveConfig()
+	}
+	return nil
+}
+
+// applyConfig replaces the keys, locks and identities of the bridge by those of the configuration
+func (b *bridge) applyConfig(cfg Configuration) error {
 	privateKey, err := base64.StdEncoding.DecodeString(cfg.PrivateKey)
 	if err != nil {
 		return err
 	}
-	copy(b.PrivateKey[:], privateKey)
 	publicKey, err := base64.StdEncoding.DecodeString(cfg.PublicKey)
 	if err != nil {
 		return err
 	}
-	copy(b.PublicKey[:], publicKey)
+	var pub, priv [32]byte
+	copy(priv[:], privateKey)
+	copy(pub[:], publicKey)
+	b.setKeys(pub, priv)
+	if cfg.JWTKey != "" {
+		jwtKey, err := base64.StdEncoding.DecodeString(cfg.JWTKey)
+		if err != nil {
+			return err
+		}
+		b.jwtKey = jwtKey
+	}
+	locks := make(map[uint]*lock)
 	for id, lockCfg := range cfg.Locks {
 		nukiId, err := strconv.ParseUint(id, 10, 32)
 		if err != nil {
@@ -86,40 +160,339 @@ func (b *bridge) loadConfig() error {
 			return err
 		}
 		lock := NewLock(lockCfg.Address, uint32(authorizationID), publicKey, lockCfg.AdminPIN)
-		b.Locks[uint(nukiId)] = lock
+		lock.bridgePublicKey = pub
+		lock.bridgePrivateKey = priv
+		if lockCfg.BridgePrivateKey != "" {
+			bridgePublicKey, err := base64.StdEncoding.DecodeString(lockCfg.BridgePublicKey)
+			if err != nil {
+				return err
+			}
+			bridgePrivateKey, err := base64.StdEncoding.DecodeString(lockCfg.BridgePrivateKey)
+			if err != nil {
+				return err
+			}
+			copy(lock.bridgePublicKey[:], bridgePublicKey)
+			copy(lock.bridgePrivateKey[:], bridgePrivateKey)
+		}
+		locks[uint(nukiId)] = lock
+	}
+	b.locksMutex.Lock()
+	b.Locks = locks
+	b.locksMutex.Unlock()
+	b.guests.mutex.Lock()
+	b.guests.grants = cfg.Grants
+	b.guests.mutex.Unlock()
+	b.clientCertsMutex.Lock()
+	b.clientCerts = make([]*clientCert, 0, len(cfg.ClientCerts))
+	for _, certCfg := range cfg.ClientCerts {
+		b.clientCerts = append(b.clientCerts, &clientCert{
+			ID:      certCfg.ID,
+			Subject: certCfg.Subject,
+			Scopes:  certCfg.Scopes,
+			NukiIds: certCfg.NukiIds,
+			Created: certCfg.Created,
+		})
+	}
+	b.clientCertsMutex.Unlock()
+	b.apiKeysMutex.Lock()
+	b.apiKeys = make([]*apiKey, 0, len(cfg.ApiKeys))
+	for _, keyCfg := range cfg.ApiKeys {
+		b.apiKeys = append(b.apiKeys, &apiKey{
+			ID:      keyCfg.ID,
+			Name:    keyCfg.Name,
+			Hash:    keyCfg.Hash,
+			Scopes:  keyCfg.Scopes,
+			NukiIds: keyCfg.NukiIds,
+			Created: keyCfg.Created,
+		})
+	}
+	b.apiKeysMutex.Unlock()
+	if _, err := b.service.restoreCallbacks(cfg.Callbacks); err != nil {
+		log.WithError(err).Warnln("Skipped invalid callbacks of configuration")
 	}
 	return nil
 }
 
-func (b *bridge) saveConfig() error {
+func (b *bridge) saveConfig() (err error) {
+	defer func() {
+		b.health.setConfigSaved(err)
+	}()
+	cfg := b.currentConfig()
+	keys := keysFingerprint(cfg)
+	if b.secrets != nil {
+		if err := b.secrets.seal(&cfg); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(cfg, "", " ")
+	if err != nil {
+		return err
+	}
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	backups := b.options.ConfigBackups
+	if keys == b.savedKeys {
+		// Guest uses, api keys and callbacks don't push the keys out of the backups
+		backups = 0
+	}
+	if err := writeConfigFile(b.dir, data, backups); err != nil {
+		return err
+	}
+	b.savedKeys = keys
+	return nil
+}
+
+// keysFingerprint identifies the keys and locks of the configuration, the backups
+// are only rotated if they change
+func keysFingerprint(cfg Configuration) string {
+	data, _ := json.Marshal(struct {
+		Version    int
+		PrivateKey string
+		PublicKey  string
+		JWTKey     string
+		Locks      map[string]LockConfiguration
+	}{cfg.Version, cfg.PrivateKey, cfg.PublicKey, cfg.JWTKey, cfg.Locks})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// keys returns the key pair of the bridge, it is replaced by key rotations and identity imports
+func (b *bridge) keys() (pub [32]byte, priv [32]byte) {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	return b.PublicKey, b.PrivateKey
+}
+
+func (b *bridge) setKeys(pub [32]byte, priv [32]byte) {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+	b.PublicKey = pub
+	b.PrivateKey = priv
+}
+
+// currentConfig is the unencrypted configuration of the running bridge
+func (b *bridge) currentConfig() Configuration {
+	pub, priv := b.keys()
 	cfg := Configuration{
-		PrivateKey: base64.StdEncoding.EncodeToString(b.PrivateKey[:]),
-		PublicKey:  base64.StdEncoding.EncodeToString(b.PublicKey[:]),
+		Version:    configVersion,
+		PrivateKey: base64.StdEncoding.EncodeToString(priv[:]),
+		PublicKey:  base64.StdEncoding.EncodeToString(pub[:]),
 		Locks:      make(map[string]LockConfiguration),
+		JWTKey:     base64.StdEncoding.EncodeToString(b.jwtKey),
+		Callbacks:  b.service.callbackConfigs(),
 	}
-	for key, lock := range b.Locks {
+	for key, lock := range b.GetLocks() {
 		lockCfg := LockConfiguration{
 			Address:         lock.address,
 			AuthorizationId: fmt.Sprint(lock.authorizationID),
 			PublicKey:       base64.StdEncoding.EncodeToString(lock.peersPublicKey[:]),
 			AdminPIN:        lock.adminPIN,
 		}
+		if lock.bridgePublicKey != pub && lock.bridgePublicKey != [32]byte{} {
+			lockCfg.BridgePublicKey = base64.StdEncoding.EncodeToString(lock.bridgePublicKey[:])
+			lockCfg.BridgePrivateKey = base64.StdEncoding.EncodeToString(lock.bridgePrivateKey[:])
+		}
 		cfg.Locks[fmt.Sprint(key)] = lockCfg
 	}
-	f, err := os.OpenFile(path.Join(b.dir, filename), os.O_RDWR|os.O_CREATE, 0700)
+	b.apiKeysMutex.RLock()
+	for _, k := range b.apiKeys {
+		cfg.ApiKeys = append(cfg.ApiKeys, ApiKeyConfiguration{
+			ID:      k.ID,
+			Name:    k.Name,
+			Hash:    k.Hash,
+			Scopes:  k.Scopes,
+			NukiIds: k.NukiIds,
+			Created: k.Created,
+		})
+	}
+	b.apiKeysMutex.RUnlock()
+	b.clientCertsMutex.RLock()
+	for _, c := range b.clientCerts {
+		cfg.ClientCerts = append(cfg.ClientCerts, ClientCertConfiguration{
+			ID:      c.ID,
+			Subject: c.Subject,
+			Scopes:  c.Scopes,
+			NukiIds: c.NukiIds,
+			Created: c.Created,
+		})
+	}
+	b.clientCertsMutex.RUnlock()
+	b.guests.mutex.Lock()
+	for _, grant := range b.guests.grants {
+		// Uses are counted while the configuration is written
+		g := *grant
+		g.History = append([]GuestUse(nil), grant.History...)
+		cfg.Grants = append(cfg.Grants, &g)
+	}
+	b.guests.mutex.Unlock()
+	return cfg
+}
+
+// readConfig reads and migrates the configuration. If it is missing or broken
+// the newest readable backup is used, the keys must not get lost. The
+// configuration has to be written again if it was migrated or restored.
+func (b *bridge) readConfig() (Configuration, bool, error) {
+	file := path.Join(b.dir, filename)
+	cfg, migrated, err := readConfigFile(file)
+	if _, ok := err.(errConfigTooNew); ok {
+		// Falling back to a backup would overwrite the newer configuration
+		return cfg, false, err
+	}
 	if err != nil {
-		return err
+		for i := 1; i <= b.options.ConfigBackups; i++ {
+			backup := backupFilename(file, i)
+			var backupErr error
+			cfg, migrated, backupErr = readConfigFile(backup)
+			if backupErr == nil {
+				log.WithError(err).WithField("backup", backup).Errorln("Failed to read configuration, using backup")
+				err = nil
+				migrated = true
+				break
+			}
+		}
+		if err != nil {
+			return cfg, false, err
+		}
 	}
-	defer func() {
-		f.Sync()
-		f.Close()
-	}()
-	bytes, err := json.MarshalIndent(cfg, "", " ")
+	return cfg, migrated, nil
+}
+
+// configExists is true if the configuration or one of its backups exists
+func (b *bridge) configExists() bool {
+	file := path.Join(b.dir, filename)
+	if _, err := os.Stat(file); err == nil {
+		return true
+	}
+	for i := 1; i <= b.options.ConfigBackups; i++ {
+		if _, err := os.Stat(backupFilename(file, i)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func readConfigFile(file string) (cfg Configuration, migrated bool, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, false, err
+	}
+	data, migrated, err = migrateConfig(data)
+	if err != nil {
+		return cfg, false, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, false, err
+	}
+	return cfg, migrated, nil
+}
+
+// errConfigTooNew is the version of a configuration written by a newer bridge
+type errConfigTooNew int
+
+func (e errConfigTooNew) Error() string {
+	return fmt.Sprintf("Configuration version %d is newer than the supported version %d", int(e), configVersion)
+}
+
+// migrateConfig upgrades the raw configuration to the current schema version
+func migrateConfig(data []byte) ([]byte, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, err
+	}
+	version := 1
+	if v, ok := raw["version"].(float64); ok && v > 0 {
+		version = int(v)
+	}
+	if version > configVersion {
+		return nil, false, errConfigTooNew(version)
+	}
+	if version == configVersion {
+		return data, false, nil
+	}
+	for ; version < configVersion; version++ {
+		if err := configMigrations[version-1](raw); err != nil {
+			return nil, false, fmt.Errorf("Failed to migrate configuration to version %d: %v", version+1, err)
+		}
+		raw["version"] = version + 1
+		log.WithField("version", version+1).Infoln("Migrated configuration")
+	}
+	data, err := json.Marshal(raw)
+	return data, true, err
+}
+
+func backupFilename(file string, n int) string {
+	return fmt.Sprintf("%s.%d", file, n)
+}
+
+// writeConfigFile replaces the configuration atomically. The data is written
+// to a temporary file which is renamed after it was synced, the previous
+// configuration is kept as first backup.
+func writeConfigFile(dir string, data []byte, backups int) error {
+	file := path.Join(dir, filename)
+	tmp, err := ioutil.TempFile(dir, filename+".tmp")
 	if err != nil {
 		return err
 	}
-	if _, err := f.Write(bytes); err != nil {
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	if err := rotateBackups(file, data, backups); err != nil {
+		log.WithError(err).Warnln("Failed to rotate configuration backups")
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateBackups shifts the backups and links the current configuration as
+// first backup, nothing is done if the configuration did not change
+func rotateBackups(file string, data []byte, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	current, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	if bytes.Equal(current, data) {
+		return nil
+	}
+	for i := backups; i > 1; i-- {
+		if err := os.Rename(backupFilename(file, i-1), backupFilename(file, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	first := backupFilename(file, 1)
+	os.Remove(first)
+	if err := os.Link(file, first); err != nil {
+		// Not all file systems support hard links
+		return ioutil.WriteFile(first, current, 0600)
+	}
 	return nil
 }
+
+// syncDir persists the rename of the configuration
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
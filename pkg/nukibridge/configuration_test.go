@@ -0,0 +1,196 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func newConfigTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "nukibridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func newConfigTestBridge(t *testing.T, dir string, options Options) *bridge {
+	b := &bridge{
+		dir:     dir,
+		Locks:   make(map[uint]*lock),
+		options: options,
+	}
+	b.service = NewBridgeService(b)
+	return b
+}
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		migrated bool
+		wantErr  bool
+	}{
+		{"current", `{"version": 1, "publicKey": "a"}`, false, false},
+		{"without version", `{"publicKey": "a"}`, false, false},
+		{"zero version", `{"version": 0, "publicKey": "a"}`, false, false},
+		{"newer", `{"version": 2}`, false, true},
+		{"broken", `{"version": `, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, migrated, err := migrateConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if migrated != tt.migrated {
+				t.Errorf("migrated %v, want %v", migrated, tt.migrated)
+			}
+			if err == nil && string(data) != tt.data {
+				t.Errorf("data %s, want it unchanged", data)
+			}
+		})
+	}
+	if _, _, err := migrateConfig([]byte(`{"version": 2}`)); err != errConfigTooNew(2) {
+		t.Errorf("error %v, want %v", err, errConfigTooNew(2))
+	}
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	dir := newConfigTestDir(t)
+	file := path.Join(dir, filename)
+	writes := []string{`{"n":1}`, `{"n":2}`, `{"n":2}`, `{"n":3}`, `{"n":4}`}
+	for _, data := range writes {
+		if err := writeConfigFile(dir, []byte(data), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file, `{"n":4}`},
+		// Writing the same configuration again keeps the backups
+		{backupFilename(file, 1), `{"n":3}`},
+		{backupFilename(file, 2), `{"n":2}`},
+	}
+	for _, tt := range tests {
+		data, err := ioutil.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s contains %s, want %s", path.Base(tt.file), data, tt.want)
+		}
+	}
+	if _, err := os.Stat(backupFilename(file, 3)); !os.IsNotExist(err) {
+		t.Errorf("more backups than configured: %v", err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, want 0600", info.Mode().Perm())
+	}
+	if tmp, _ := filepath.Glob(path.Join(dir, filename+".tmp*")); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+func TestReadConfigFallsBackToBackup(t *testing.T) {
+	dir := newConfigTestDir(t)
+	file := path.Join(dir, filename)
+	if err := ioutil.WriteFile(backupFilename(file, 1), []byte(`{"version": 1, "publicKey": "backup"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		current string
+		want    string
+		rewrite bool
+	}{
+		{"readable", `{"version": 1, "publicKey": "current"}`, "current", false},
+		{"broken", `{"version": `, "backup", true},
+		{"missing", "", "backup", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(file)
+			if tt.current != "" {
+				if err := ioutil.WriteFile(file, []byte(tt.current), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b := newConfigTestBridge(t, dir, Options{ConfigBackups: 2})
+			cfg, rewrite, err := b.readConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.PublicKey != tt.want || rewrite != tt.rewrite {
+				t.Errorf("read %s rewrite %v, want %s rewrite %v", cfg.PublicKey, rewrite, tt.want, tt.rewrite)
+			}
+		})
+	}
+}
+
+func TestReadConfigTooNewKeepsBackups(t *testing.T) {
+	dir := newConfigTestDir(t)
+	file := path.Join(dir, filename)
+	ioutil.WriteFile(file, []byte(`{"version": 99}`), 0600)
+	ioutil.WriteFile(backupFilename(file, 1), []byte(`{"version": 1}`), 0600)
+	b := newConfigTestBridge(t, dir, Options{ConfigBackups: 2})
+	if _, _, err := b.readConfig(); err != errConfigTooNew(99) {
+		t.Errorf("error %v, want %v", err, errConfigTooNew(99))
+	}
+}
+
+func TestSaveConfigRotatesBackupsOnKeyChanges(t *testing.T) {
+	dir := newConfigTestDir(t)
+	b := newConfigTestBridge(t, dir, Options{ConfigBackups: 3})
+	if err := b.init(); err != nil {
+		t.Fatal(err)
+	}
+	// A restarted bridge
+	b = newConfigTestBridge(t, dir, Options{ConfigBackups: 3})
+	if err := b.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	backups := func() int {
+		files, _ := filepath.Glob(path.Join(dir, filename+".[0-9]"))
+		return len(files)
+	}
+
+	b.guests.grants = append(b.guests.grants, &GuestGrant{ID: "1", Name: "guest", Uses: 1})
+	if err := b.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if n := backups(); n != 0 {
+		t.Fatalf("%d backups after a guest use, want 0", n)
+	}
+
+	b.Locks[1] = NewLock("AA:BB:CC:DD:EE:FF", 1, make([]byte, 32), 1234)
+	if err := b.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if n := backups(); n != 1 {
+		t.Fatalf("%d backups after pairing a lock, want 1", n)
+	}
+	data, err := ioutil.ReadFile(backupFilename(path.Join(dir, filename), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup Configuration
+	if err := json.Unmarshal(data, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Locks) != 0 || len(backup.Grants) != 1 {
+		t.Errorf("backup has %d locks and %d grants, want the configuration before the pairing", len(backup.Locks), len(backup.Grants))
+	}
+}
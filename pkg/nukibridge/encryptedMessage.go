@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-ble/ble"
 	"github.com/howeyc/crc16"
@@ -29,6 +30,12 @@ func (l *lock) receiveEncrypted(ch chan []byte) (messages []encryptedMessage, er
 	if err != nil {
 		return
 	}
+	command := l.sentCommand
+	// Only the first response counts, e.g. further status messages of a lock action are ignored
+	if !l.sentAt.IsZero() {
+		commandDuration.WithLabelValues(l.sentCommand.String()).Observe(time.Since(l.sentAt).Seconds())
+		l.sentAt = time.Time{}
+	}
 	var sharedKey [32]byte
 	var peersPublicKey [32]byte
 	copy(peersPublicKey[:], l.peersPublicKey)
@@ -72,6 +79,7 @@ func (l *lock) receiveEncrypted(ch chan []byte) (messages []encryptedMessage, er
 		log.WithField("lock", l.address).WithField("message", fmt.Sprintf("%+v", msg)).Debugln("Decrypted message")
 		messages = append(messages, msg)
 	}
+	l.commandAnswered(command)
 	return messages, nil
 }
 
@@ -94,6 +102,8 @@ func (l *lock) writeEncryptedMessage(c *ble.Characteristic, cmd uint16, payload
 		log.WithError(err).Errorln("Failed to write encrypted message")
 		return err
 	}
+	l.sentCommand = Command(cmd)
+	l.sentAt = time.Now()
 	return nil
 }
 
@@ -107,6 +117,8 @@ func (l *lock) writeEncryptedCmdRequest(c *ble.Characteristic, cmd uint16) error
 		log.WithError(err).Errorln("Failed to write encrypted cmd request")
 		return err
 	}
+	// The requested command is of interest instead of the request itself
+	l.sentCommand = Command(cmd)
 	return nil
 }
 
@@ -0,0 +1,253 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type Event struct {
+	ID    uint64
+	Time  time.Time
+	Event string
+	Data  interface{}
+}
+
+// subscription is a bounded event queue of a single client. A client which
+// does not keep up with its queue is dropped instead of blocking the hub.
+type subscription struct {
+	events  chan Event
+	dropped chan struct{}
+	once    sync.Once
+}
+
+func (sub *subscription) drop() {
+	sub.once.Do(func() {
+		close(sub.dropped)
+	})
+}
+
+type eventHub struct {
+	mutex       sync.RWMutex
+	subscribers map[*subscription]bool
+
+	// Published events are numbered and kept for replay, limited by count and age
+	lastID     uint64
+	history    []Event
+	historyMax int
+	historyAge time.Duration
+}
+
+func newEventHub(historyMax int, historyAge time.Duration) *eventHub {
+	if historyMax < 0 {
+		historyMax = 0
+	}
+	return &eventHub{
+		subscribers: make(map[*subscription]bool),
+		// Starting with the current time keeps ids increasing across restarts
+		lastID:     uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		history:    make([]Event, 0, historyMax),
+		historyMax: historyMax,
+		historyAge: historyAge,
+	}
+}
+
+func (h *eventHub) subscribe(buffer int) *subscription {
+	sub, _ := h.subscribeSince(0, buffer)
+	return sub
+}
+
+// subscribeSince registers a new subscriber and returns all buffered events
+// published after lastID. Registration and replay happen atomically so
+// that no event is lost or delivered twice. A lastID of 0 replays nothing.
+func (h *eventHub) subscribeSince(lastID uint64, buffer int) (*subscription, []Event) {
+	sub := &subscription{
+		events:  make(chan Event, buffer),
+		dropped: make(chan struct{}),
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.subscribers[sub] = true
+	log.WithField("count", len(h.subscribers)).Infoln("Event subscriber added")
+
+	replay := make([]Event, 0)
+	if lastID == 0 {
+		return sub, replay
+	}
+	h.expire()
+	for _, event := range h.history {
+		if event.ID > lastID {
+			replay = append(replay, event)
+		}
+	}
+	return sub, replay
+}
+
+// expire removes events exceeding the maximum age from the history
+func (h *eventHub) expire() {
+	if h.historyAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-h.historyAge)
+	i := 0
+	for i < len(h.history) && h.history[i].Time.Before(deadline) {
+		i++
+	}
+	h.history = h.history[i:]
+}
+
+func (h *eventHub) unsubscribe(sub *subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+	delete(h.subscribers, sub)
+	sub.drop()
+	log.WithField("count", len(h.subscribers)).Infoln("Event subscriber removed")
+}
+
+// publish never blocks, subscribers with a full queue are disconnected
+func (h *eventHub) publish(event Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastID++
+	event.ID = h.lastID
+	event.Time = time.Now()
+	if h.historyMax > 0 {
+		if len(h.history) >= h.historyMax {
+			h.history = append(h.history[:0], h.history[len(h.history)-h.historyMax+1:]...)
+		}
+		h.history = append(h.history, event)
+		h.expire()
+	}
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			sub.drop()
+			log.WithField("count", len(h.subscribers)).Warnln("Event subscriber too slow, disconnecting")
+		}
+	}
+}
+
+func (h *eventHub) count() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subscribers)
+}
+
+// Names of the events published by the bridge
+const (
+	EventState                 = "state"
+	EventLockActionRequested   = "lockActionRequested"
+	EventLockActionAccepted    = "lockActionAccepted"
+	EventLockActionCompleted   = "lockActionCompleted"
+	EventLockActionFailed      = "lockActionFailed"
+	EventConfigChanged         = "configChanged"
+	EventDoorOpened            = "doorOpened"
+	EventDoorClosed            = "doorClosed"
+	EventBatteryCriticalRaised = "batteryCriticalRaised"
+	EventBatteryCriticalClear  = "batteryCriticalCleared"
+	EventLockConnected         = "lockConnected"
+	EventLockDisconnected      = "lockDisconnected"
+	EventLockUnreachable       = "lockUnreachable"
+	EventPairingWindowOpened   = "pairingWindowOpened"
+	EventPairingWindowClosed   = "pairingWindowClosed"
+	EventLockPaired            = "lockPaired"
+	EventLogEntry              = "logEntry"
+	EventKeyRotation           = "keyRotation"
+	EventPairing               = "pairing"
+)
+
+// LockEvent is the common part of all events. NukiId is omitted for events of the bridge itself.
+type LockEvent struct {
+	NukiId    uint32    `json:"nukiId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (e LockEvent) lockEvent() LockEvent {
+	return e
+}
+
+// lockEventer is implemented by all event payloads embedding LockEvent
+type lockEventer interface {
+	lockEvent() LockEvent
+}
+
+func newLockEvent(nukiId uint32) LockEvent {
+	return LockEvent{
+		NukiId:    nukiId,
+		Timestamp: time.Now(),
+	}
+}
+
+type StateEvent struct {
+	LockEvent
+	models.KeyturnerStates
+}
+
+// MarshalJSON keeps the field NukiId of state events from before the common event fields
+func (e StateEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		models.KeyturnerStates
+		NukiId    uint32
+		Timestamp time.Time `json:"timestamp"`
+	}{e.KeyturnerStates, e.NukiId, e.Timestamp})
+}
+
+type LockActionEvent struct {
+	LockEvent
+	Action               enums.LockAction        `json:"action"`
+	ActionName           string                  `json:"actionName"`
+	Suffix               string                  `json:"suffix,omitempty"`
+	CompletionStatus     *enums.CompletionStatus `json:"completionStatus,omitempty"`
+	CompletionStatusName string                  `json:"completionStatusName,omitempty"`
+	Duration             float64                 `json:"duration,omitempty"`
+	Error                string                  `json:"error,omitempty"`
+}
+
+type ConfigChangedEvent struct {
+	LockEvent
+	ConfigUpdateCount uint8           `json:"configUpdateCount"`
+	Config            *api.LockConfig `json:"config,omitempty"`
+}
+
+type DoorSensorEvent struct {
+	LockEvent
+	DoorSensorState     enums.DoorSensorState `json:"doorSensorState"`
+	DoorSensorStateName string                `json:"doorSensorStateName"`
+}
+
+type BatteryEvent struct {
+	LockEvent
+	BatteryCritical bool `json:"batteryCritical"`
+}
+
+type ConnectionEvent struct {
+	LockEvent
+	Address string `json:"address"`
+	Error   string `json:"error,omitempty"`
+}
+
+type PairingWindowEvent struct {
+	LockEvent
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+type LockPairedEvent struct {
+	LockEvent
+	Address string `json:"address"`
+	Name    string `json:"name"`
+}
+
+type LogEntryEvent struct {
+	LockEvent
+	Entry models.LogEntry `json:"entry"`
+}
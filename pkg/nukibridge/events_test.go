@@ -0,0 +1,136 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+func publishN(h *eventHub, n int) []uint64 {
+	ids := make([]uint64, 0, n)
+	for i := 0; i < n; i++ {
+		h.publish(Event{Event: EventState})
+		ids = append(ids, h.lastID)
+	}
+	return ids
+}
+
+func TestEventHubReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		historyMax int
+		published  int
+		since      int // index of the last seen event, -1 replays nothing
+		want       int
+	}{
+		{"nothing seen", 10, 5, -1, 0},
+		{"all but first", 10, 5, 0, 4},
+		{"up to date", 10, 5, 4, 0},
+		{"evicted by count", 3, 5, 0, 3},
+		{"history disabled", 0, 5, 0, 0},
+		{"negative size", -1, 5, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newEventHub(tt.historyMax, 0)
+			ids := publishN(h, tt.published)
+			var lastID uint64
+			if tt.since >= 0 {
+				lastID = ids[tt.since]
+			}
+			sub, replay := h.subscribeSince(lastID, 1)
+			defer h.unsubscribe(sub)
+			if len(replay) != tt.want {
+				t.Fatalf("replayed %d events, want %d", len(replay), tt.want)
+			}
+			for i, event := range replay {
+				if want := ids[tt.published-tt.want+i]; event.ID != want {
+					t.Errorf("replay[%d] has id %d, want %d", i, event.ID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHubEvictsByAge(t *testing.T) {
+	h := newEventHub(10, time.Minute)
+	ids := publishN(h, 3)
+	// Age the first two events beyond the limit
+	h.history[0].Time = time.Now().Add(-2 * time.Minute)
+	h.history[1].Time = time.Now().Add(-2 * time.Minute)
+
+	sub, replay := h.subscribeSince(ids[0]-1, 1)
+	defer h.unsubscribe(sub)
+	if len(replay) != 1 || replay[0].ID != ids[2] {
+		t.Fatalf("replayed %v, want only event %d", replay, ids[2])
+	}
+}
+
+func TestEventHubDropsSlowSubscriber(t *testing.T) {
+	h := newEventHub(0, 0)
+	slow := h.subscribe(1)
+	fast := h.subscribe(4)
+	publishN(h, 2)
+
+	select {
+	case <-slow.dropped:
+	default:
+		t.Error("slow subscriber was not dropped")
+	}
+	select {
+	case <-fast.dropped:
+		t.Error("fast subscriber was dropped")
+	default:
+	}
+	if h.count() != 1 {
+		t.Errorf("hub has %d subscribers, want 1", h.count())
+	}
+}
+
+func TestEventHubIdsIncrease(t *testing.T) {
+	h := newEventHub(5, 0)
+	ids := publishN(h, 5)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] != ids[i-1]+1 {
+			t.Fatalf("ids %v are not consecutive", ids)
+		}
+	}
+}
+
+func TestEventPayloads(t *testing.T) {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	event := LockEvent{NukiId: 42, Timestamp: at}
+	tests := []struct {
+		name string
+		data interface{}
+		want []string
+	}{
+		// State events keep the field names from before the common event fields
+		{"state", StateEvent{LockEvent: event, KeyturnerStates: models.KeyturnerStates{LockState: enums.LockStateLocked}}, []string{`"NukiId":42`, `"lockState":1`, `"timestamp":"2021-03-01T12:00:00Z"`}},
+		{"lock action", LockActionEvent{LockEvent: event, Action: enums.LockActionUnlock, ActionName: "Unlock"}, []string{`"nukiId":42`, `"action":1`, `"actionName":"Unlock"`}},
+		{"door sensor", DoorSensorEvent{LockEvent: event, DoorSensorState: enums.DoorSensorStateDoorOpened, DoorSensorStateName: "DoorOpened"}, []string{`"nukiId":42`, `"doorSensorState":3`}},
+		{"battery", BatteryEvent{LockEvent: event, BatteryCritical: true}, []string{`"nukiId":42`, `"batteryCritical":true`}},
+		{"connection", ConnectionEvent{LockEvent: event, Address: "AA:BB:CC:DD:EE:FF"}, []string{`"nukiId":42`, `"address":"AA:BB:CC:DD:EE:FF"`}},
+		{"pairing window", PairingWindowEvent{LockEvent: LockEvent{Timestamp: at}}, []string{`"timestamp":"2021-03-01T12:00:00Z"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("%s misses %s", data, want)
+				}
+			}
+			if e, ok := tt.data.(lockEventer); !ok || e.lockEvent().Timestamp != at {
+				t.Errorf("payload does not embed the lock event")
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package nukibridge
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	exportTimeFormat = "2006-01-02 15:04:05"
+	icsTimeFormat    = "20060102T150405Z"
+)
+
+// exportRecord is a log entry with resolved details and names, the time is local to the lock
+type exportRecord struct {
+	Index   uint32 `json:"index"`
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	AuthID  uint32 `json:"authId"`
+	Name    string `json:"name"`
+	Action  string `json:"action,omitempty"`
+	Trigger string `json:"trigger,omitempty"`
+	Source  string `json:"source,omitempty"`
+	CodeID  uint16 `json:"codeId,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Door    string `json:"door,omitempty"`
+	Logging *bool  `json:"logging,omitempty"`
+
+	timestamp time.Time
+}
+
+var exportColumns = []string{"index", "time", "type", "authId", "name", "action", "trigger", "source", "codeId", "status", "door", "logging"}
+
+func newExportRecord(e models.LogEntry, zone *time.Location) exportRecord {
+	r := exportRecord{
+		Index:     e.Index,
+		Time:      e.Timestamp.In(zone).Format(time.RFC3339),
+		Type:      e.Type.String(),
+		AuthID:    e.AuthID,
+		Name:      e.Name,
+		timestamp: e.Timestamp,
+	}
+	switch details := e.Details.(type) {
+	case models.LogEntryTypeLockAction:
+		r.Action = details.LockAction.String()
+		r.Trigger = details.Trigger.String()
+		r.Status = details.CompletionStatus.String()
+	case models.LogEntryTypeKeypadAction:
+		r.Action = details.LockAction.String()
+		r.Source = details.Source.String()
+		r.CodeID = details.CodeID
+		r.Status = details.CompletionStatus.String()
+	case models.LogEntryTypeDoorSensor:
+		r.Door = details.DoorSensor.String()
+	case models.LogEntryTypeLogging:
+		logging := details.Logging
+		r.Logging = &logging
+	}
+	return r
+}
+
+func (r exportRecord) columns(zone *time.Location) []string {
+	logging := ""
+	if r.Logging != nil {
+		logging = strconv.FormatBool(*r.Logging)
+	}
+	codeID := ""
+	if r.CodeID != 0 {
+		codeID = fmt.Sprint(r.CodeID)
+	}
+	return []string{
+		fmt.Sprint(r.Index),
+		r.timestamp.In(zone).Format(exportTimeFormat),
+		r.Type,
+		fmt.Sprint(r.AuthID),
+		r.Name,
+		r.Action,
+		r.Trigger,
+		r.Source,
+		codeID,
+		r.Status,
+		r.Door,
+		logging,
+	}
+}
+
+// summary describes the record in a single line, e.g. "Unlock by Alice"
+func (r exportRecord) summary() string {
+	what := r.Type
+	switch {
+	case r.Action != "":
+		what = r.Action
+	case r.Door != "":
+		what = r.Door
+	case r.Logging != nil && *r.Logging:
+		what = "Logging enabled"
+	case r.Logging != nil:
+		what = "Logging disabled"
+	}
+	if r.Name == "" {
+		return what
+	}
+	return what + " by " + r.Name
+}
+
+// lockZone is the fixed zone of the timezone offset configured in the lock, UTC if unknown
+func (b *bridge) lockZone(l *lock) *time.Location {
+	if l.lastConfig.NukiID == 0 {
+		err := b.aquireDevice()
+		var config models.Config
+		if err == nil {
+			config, err = l.RequestConfig()
+			b.releaseDevice()
+		}
+		if err != nil {
+			log.WithError(err).WithField("nukiId", l.nukiID).Warnln("Failed to read timezone of lock, exporting UTC")
+			return time.UTC
+		}
+		l.lastConfig = config
+	}
+	offset := l.lastConfig.TimezoneOffset
+	minutes, sign := int(offset.Minutes()), "+"
+	if minutes < 0 {
+		minutes, sign = -minutes, "-"
+	}
+	name := fmt.Sprintf("UTC%s%02d:%02d", sign, minutes/60, minutes%60)
+	return time.FixedZone(name, int(offset.Seconds()))
+}
+
+// parseExportTime accepts RFC 3339 times and dates local to the lock. A date
+// is the start of the day, or its end if endOfDay is set.
+func parseExportTime(value string, zone *time.Location, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, zone); err == nil {
+		if endOfDay {
+			return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeCSV(w io.Writer, records []exportRecord, zone *time.Location) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := writer.Write(r.columns(zone)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONL(w io.Writer, records []exportRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icsEscape escapes text values as required by RFC 5545
+func icsEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+// icsLine folds content lines longer than 75 octets
+func icsLine(w io.Writer, line string) error {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Multi-byte characters must not be split
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if _, err := io.WriteString(w, line[:cut]+"\r\n "); err != nil {
+			return err
+		}
+		line = line[cut:]
+		// The space starting a continuation line counts as well
+		limit = 74
+	}
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
+
+func writeICS(w io.Writer, l *lock, records []exportRecord) error {
+	name := l.lastConfig.Name
+	if name == "" {
+		name = fmt.Sprint(l.nukiID)
+	}
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//nukibridge//history//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:" + icsEscape(name),
+	}
+	for _, r := range records {
+		start := r.timestamp.UTC().Format(icsTimeFormat)
+		description := fmt.Sprintf("Log entry %d, %s", r.Index, r.Type)
+		if r.Trigger != "" {
+			description += ", trigger " + r.Trigger
+		}
+		if r.Source != "" {
+			description += ", source " + r.Source
+		}
+		if r.Status != "" {
+			description += ", status " + r.Status
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%d-%d@nukibridge", l.nukiID, r.Index),
+			"DTSTAMP:"+start,
+			"DTSTART:"+start,
+			"DTEND:"+start,
+			"SUMMARY:"+icsEscape(r.summary()),
+			"DESCRIPTION:"+icsEscape(description),
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		if err := icsLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LocksIdHistoryExportGet - Exports the stored history of the lock as csv, json lines or icalendar
+func (s *NukiBridgeService) LocksIdHistoryExportGet(w http.ResponseWriter, r *http.Request) {
+	nukiId, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l, err := s.bridge.GetLock(uint(nukiId))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	zone := s.bridge.lockZone(l)
+	filter := HistoryFilter{Ascending: true}
+	if filter.From, err = parseExportTime(query.Get("from"), zone, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseExportTime(query.Get("to"), zone, true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	var contentType, extension string
+	switch format {
+	case "", "csv":
+		format, contentType, extension = "csv", "text/csv; charset=UTF-8", "csv"
+	case "jsonl":
+		contentType, extension = "application/x-ndjson", "jsonl"
+	case "ics":
+		contentType, extension = "text/calendar; charset=UTF-8", "ics"
+	default:
+		http.Error(w, "Unknown format "+format, http.StatusBadRequest)
+		return
+	}
+	entries, err := s.bridge.history.query(uint32(nukiId), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	records := make([]exportRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, newExportRecord(entry, zone))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"history-%d.%s\"", nukiId, extension))
+	switch format {
+	case "csv":
+		err = writeCSV(w, records, zone)
+	case "jsonl":
+		err = writeJSONL(w, records)
+	case "ics":
+		err = writeICS(w, l, records)
+	}
+	if err != nil {
+		log.WithError(err).WithField("nukiId", nukiId).Warnln("Failed to export history")
+	}
+}
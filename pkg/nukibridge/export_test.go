@@ -0,0 +1,193 @@
+package nukibridge
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+func TestICSEscape(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Unlock by Alice", "Unlock by Alice"},
+		{"Smith, John", `Smith\, John`},
+		{"a;b", `a\;b`},
+		{`C:\door`, `C:\\door`},
+		{"two\nlines", `two\nlines`},
+		{`\,`, `\\\,`},
+	}
+	for _, tt := range tests {
+		if got := icsEscape(tt.value); got != tt.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestICSLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Unlock by Alice"},
+		{"exactly 75", "SUMMARY:" + strings.Repeat("a", 67)},
+		{"76", "SUMMARY:" + strings.Repeat("a", 68)},
+		{"several folds", "DESCRIPTION:" + strings.Repeat("abcdefghij", 30)},
+		{"multi-byte", "SUMMARY:" + strings.Repeat("ä", 100)},
+		{"multi-byte at fold", "SUMMARY:" + strings.Repeat("a", 66) + strings.Repeat("€", 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := icsLine(buf, tt.line); err != nil {
+				t.Fatal(err)
+			}
+			out := buf.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("%q does not end with CRLF", out)
+			}
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			unfolded := lines[0]
+			for i, line := range lines {
+				if len(line) > 75 {
+					t.Errorf("line %d has %d octets", i, len(line))
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a character: %q", i, line)
+				}
+				if i > 0 {
+					if !strings.HasPrefix(line, " ") {
+						t.Errorf("continuation line %d does not start with a space", i)
+					}
+					unfolded += line[1:]
+				}
+			}
+			if unfolded != tt.line {
+				t.Errorf("unfolded %q, want %q", unfolded, tt.line)
+			}
+		})
+	}
+}
+
+func TestExportRecord(t *testing.T) {
+	zone := time.FixedZone("UTC+01:00", 3600)
+	timestamp := time.Date(2020, 11, 21, 10, 0, 0, 0, time.UTC)
+	enabled := true
+	tests := []struct {
+		name    string
+		entry   models.LogEntry
+		summary string
+		columns []string
+	}{
+		{"lock action", models.LogEntry{Index: 1, Timestamp: timestamp, AuthID: 3, Name: "Alice", Type: enums.LogTypeLockAction,
+			Details: models.LogEntryTypeLockAction{LockAction: enums.LockActionUnlock, Trigger: enums.TriggerSystem}},
+			"Unlock by Alice", []string{"1", "2020-11-21 11:00:00", "LockAction", "3", "Alice", "Unlock", "System", "", "", "Success", "", ""}},
+		{"keypad", models.LogEntry{Index: 2, Timestamp: timestamp, Name: "Bob, Jr.", Type: enums.LogTypeKeypadAction,
+			Details: models.LogEntryTypeKeypadAction{LockAction: enums.LockActionLock, CodeID: 12}},
+			"Lock by Bob, Jr.", nil},
+		{"logging", models.LogEntry{Index: 3, Timestamp: timestamp, Type: enums.LogTypeLogging,
+			Details: models.LogEntryTypeLogging{Logging: enabled}},
+			"Logging enabled", nil},
+		{"without details", models.LogEntry{Index: 4, Timestamp: timestamp, Type: enums.LogTypeCalibration},
+			"Calibration", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newExportRecord(tt.entry, zone)
+			if r.Time != "2020-11-21T11:00:00+01:00" {
+				t.Errorf("time %s, want local time of the lock", r.Time)
+			}
+			if got := r.summary(); got != tt.summary {
+				t.Errorf("summary %q, want %q", got, tt.summary)
+			}
+			columns := r.columns(zone)
+			if len(columns) != len(exportColumns) {
+				t.Fatalf("%d columns, want %d", len(columns), len(exportColumns))
+			}
+			if tt.columns != nil && strings.Join(columns, "|") != strings.Join(tt.columns, "|") {
+				t.Errorf("columns %q, want %q", columns, tt.columns)
+			}
+		})
+	}
+}
+
+func TestWriteCSVQuotes(t *testing.T) {
+	zone := time.UTC
+	records := []exportRecord{
+		newExportRecord(models.LogEntry{Index: 1, Name: `Bob, "the builder"`, Type: enums.LogTypeLockAction}, zone),
+		newExportRecord(models.LogEntry{Index: 2, Name: "two\nlines", Type: enums.LogTypeLockAction}, zone),
+	}
+	buf := new(bytes.Buffer)
+	if err := writeCSV(buf, records, zone); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows, want header and 2 records", len(rows))
+	}
+	if rows[1][4] != `Bob, "the builder"` || rows[2][4] != "two\nlines" {
+		t.Errorf("names %q and %q were not preserved", rows[1][4], rows[2][4])
+	}
+}
+
+func TestParseExportTime(t *testing.T) {
+	zone := time.FixedZone("UTC+02:00", 2*3600)
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{"", false, time.Time{}, false},
+		{"2020-11-21", false, time.Date(2020, 11, 21, 0, 0, 0, 0, zone), false},
+		{"2020-11-21", true, time.Date(2020, 11, 22, 0, 0, 0, 0, zone).Add(-time.Nanosecond), false},
+		{"2020-11-21T10:00:00Z", true, time.Date(2020, 11, 21, 10, 0, 0, 0, time.UTC), false},
+		{"21.11.2020", false, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExportTime(tt.value, zone, tt.endOfDay)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseExportTime(%q) error %v, want error %v", tt.value, err, tt.wantErr)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseExportTime(%q, %v) = %v, want %v", tt.value, tt.endOfDay, got, tt.want)
+		}
+	}
+}
+
+func TestWriteICS(t *testing.T) {
+	l := &lock{nukiID: 42}
+	l.lastConfig.Name = "Front door; main"
+	records := []exportRecord{
+		newExportRecord(models.LogEntry{Index: 7, Timestamp: time.Date(2020, 11, 21, 10, 0, 0, 0, time.UTC), Name: "Alice", Type: enums.LogTypeLockAction,
+			Details: models.LogEntryTypeLockAction{LockAction: enums.LockActionUnlock}}, time.UTC),
+	}
+	buf := new(bytes.Buffer)
+	if err := writeICS(buf, l, records); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		`X-WR-CALNAME:Front door\; main` + "\r\n",
+		"UID:42-7@nukibridge\r\n",
+		"DTSTART:20201121T100000Z\r\n",
+		"SUMMARY:Unlock by Alice\r\n",
+		`DESCRIPTION:Log entry 7\, LockAction\, trigger System\, status Success` + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar misses %q:\n%s", want, out)
+		}
+	}
+}
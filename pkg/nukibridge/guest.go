@@ -0,0 +1,471 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/assets/templates"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	guestPrefix     = "Guest: "
+	guestUseHistory = 100
+	guestTimeLayout = "15:04"
+)
+
+var (
+	errGrantNotFound = errors.New("Grant not found")
+	errGrantInvalid  = errors.New("Grant not valid")
+)
+
+// GuestGrant allows guests to run lock actions by an opaque link. Only the
+// hash of the link token is stored.
+type GuestGrant struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Hash    string   `json:"hash"`
+	NukiIds []uint32 `json:"nukiIds"`
+	Actions []string `json:"actions"`
+	// Start and End limit the validity, unlimited if zero
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+	// MaxUses limits the number of lock actions, unlimited if 0
+	MaxUses int `json:"maxUses,omitempty"`
+	Uses    int `json:"uses"`
+	// Weekdays (0 is sunday) and the time window From - To in local time of the bridge limit the validity further
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Created  time.Time      `json:"created"`
+	History  []GuestUse     `json:"history,omitempty"`
+}
+
+// GuestUse records a single use of a grant
+type GuestUse struct {
+	Time    time.Time `json:"time"`
+	NukiId  uint32    `json:"nukiId"`
+	Action  string    `json:"action"`
+	Source  string    `json:"source"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type guests struct {
+	mutex  sync.Mutex
+	grants []*GuestGrant
+}
+
+func (g *GuestGrant) allowsLock(nukiId uint32) bool {
+	for _, id := range g.NukiIds {
+		if id == nukiId {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GuestGrant) allowsAction(action enums.LockAction) bool {
+	for _, name := range g.Actions {
+		if a, err := parseLockAction(name); err == nil && a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// validAt checks the validity window, the weekdays, the time of day and the remaining uses
+func (g *GuestGrant) validAt(t time.Time) error {
+	if !g.Start.IsZero() && t.Before(g.Start) {
+		return fmt.Errorf("Grant valid from %s", g.Start.Format(time.RFC3339))
+	}
+	if !g.End.IsZero() && t.After(g.End) {
+		return errors.New("Grant expired")
+	}
+	if g.MaxUses > 0 && g.Uses >= g.MaxUses {
+		return errors.New("Grant used up")
+	}
+	local := t.Local()
+	if len(g.Weekdays) > 0 {
+		allowed := false
+		for _, day := range g.Weekdays {
+			if day == local.Weekday() {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return errors.New("Grant not valid on this weekday")
+		}
+	}
+	if g.From != "" && g.To != "" {
+		now := local.Format(guestTimeLayout)
+		inside := now >= g.From && now < g.To
+		// Windows like 22:00 - 06:00 span midnight
+		if g.From > g.To {
+			inside = now >= g.From || now < g.To
+		}
+		if !inside {
+			return fmt.Errorf("Grant only valid from %s to %s", g.From, g.To)
+		}
+	}
+	return nil
+}
+
+func (g *GuestGrant) record(use GuestUse) {
+	g.History = append(g.History, use)
+	if len(g.History) > guestUseHistory {
+		g.History = g.History[len(g.History)-guestUseHistory:]
+	}
+}
+
+// suffix is shown in the log of the lock, cut to the 20 bytes the lock supports
+// without splitting a character
+func (g *GuestGrant) suffix() string {
+	return truncateUTF8(guestPrefix+g.Name, nameSuffixLength)
+}
+
+func (g *GuestGrant) toApi(link string) api.Grant {
+	grant := api.Grant{
+		Id:       g.ID,
+		Name:     g.Name,
+		NukiIds:  make([]int32, 0, len(g.NukiIds)),
+		Actions:  g.Actions,
+		MaxUses:  int32(g.MaxUses),
+		Uses:     int32(g.Uses),
+		Weekdays: make([]int32, 0, len(g.Weekdays)),
+		From:     g.From,
+		To:       g.To,
+		Created:  g.Created.Format(time.RFC3339),
+		Link:     link,
+		History:  make([]api.GrantUse, 0, len(g.History)),
+	}
+	for _, id := range g.NukiIds {
+		grant.NukiIds = append(grant.NukiIds, int32(id))
+	}
+	for _, day := range g.Weekdays {
+		grant.Weekdays = append(grant.Weekdays, int32(day))
+	}
+	if !g.Start.IsZero() {
+		grant.Start = g.Start.Format(time.RFC3339)
+	}
+	if !g.End.IsZero() {
+		grant.End = g.End.Format(time.RFC3339)
+	}
+	for _, use := range g.History {
+		grant.History = append(grant.History, api.GrantUse{
+			Time:    use.Time.Format(time.RFC3339),
+			NukiId:  int32(use.NukiId),
+			Action:  use.Action,
+			Source:  use.Source,
+			Success: use.Success,
+			Error:   use.Error,
+		})
+	}
+	return grant
+}
+
+func (b *bridge) guestLink(token string) string {
+	return strings.TrimSuffix(b.options.PublicURL, "/") + "/guest/" + token
+}
+
+func (b *bridge) addGrant(req api.Grant) (api.Grant, error) {
+	if req.Name == "" {
+		return api.Grant{}, errors.New("Name is required")
+	}
+	if len(req.NukiIds) == 0 || len(req.Actions) == 0 {
+		return api.Grant{}, errors.New("At least one lock and action is required")
+	}
+	g := &GuestGrant{
+		Name:    req.Name,
+		Actions: req.Actions,
+		MaxUses: int(req.MaxUses),
+		From:    req.From,
+		To:      req.To,
+		Created: time.Now(),
+	}
+	for _, id := range req.NukiIds {
+		if _, err := b.GetLock(uint(id)); err != nil {
+			return api.Grant{}, err
+		}
+		g.NukiIds = append(g.NukiIds, uint32(id))
+	}
+	for _, action := range req.Actions {
+		if _, err := parseLockAction(action); err != nil {
+			return api.Grant{}, err
+		}
+	}
+	for _, day := range req.Weekdays {
+		if day < 0 || day > 6 {
+			return api.Grant{}, fmt.Errorf("Invalid weekday %d", day)
+		}
+		g.Weekdays = append(g.Weekdays, time.Weekday(day))
+	}
+	if (g.From == "") != (g.To == "") {
+		return api.Grant{}, errors.New("Both from and to are required for a time window")
+	}
+	for _, t := range []string{g.From, g.To} {
+		if _, err := time.Parse(guestTimeLayout, t); t != "" && err != nil {
+			return api.Grant{}, err
+		}
+	}
+	if req.Start != "" {
+		t, err := time.Parse(time.RFC3339, req.Start)
+		if err != nil {
+			return api.Grant{}, err
+		}
+		g.Start = t
+	}
+	if req.End != "" {
+		t, err := time.Parse(time.RFC3339, req.End)
+		if err != nil {
+			return api.Grant{}, err
+		}
+		g.End = t
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return api.Grant{}, err
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		return api.Grant{}, err
+	}
+	g.ID = id
+	g.Hash = hashToken(token)
+
+	b.guests.mutex.Lock()
+	b.guests.grants = append(b.guests.grants, g)
+	result := g.toApi(b.guestLink(token))
+	b.guests.mutex.Unlock()
+	if err := b.saveConfig(); err != nil {
+		return api.Grant{}, err
+	}
+	log.WithField("name", g.Name).WithField("id", g.ID).Infoln("Guest grant created")
+	return result, nil
+}
+
+func (b *bridge) removeGrant(id string) error {
+	b.guests.mutex.Lock()
+	found := false
+	for i, g := range b.guests.grants {
+		if g.ID == id {
+			b.guests.grants = append(b.guests.grants[:i], b.guests.grants[i+1:]...)
+			found = true
+			break
+		}
+	}
+	b.guests.mutex.Unlock()
+	if !found {
+		return errGrantNotFound
+	}
+	log.WithField("id", id).Infoln("Guest grant removed")
+	return b.saveConfig()
+}
+
+// grantByToken must be called with the guests mutex held
+func (b *bridge) grantByToken(token string) (*GuestGrant, error) {
+	hash := hashToken(token)
+	for _, g := range b.guests.grants {
+		if g.Hash == hash {
+			return g, nil
+		}
+	}
+	return nil, errGrantNotFound
+}
+
+// guestAction runs the lock action of a guest through the normal lock action path.
+// A use is reserved before the action and given back if the action failed.
+func (b *bridge) guestAction(token string, nukiId uint32, action enums.LockAction, source string) error {
+	b.guests.mutex.Lock()
+	g, err := b.grantByToken(token)
+	if err != nil {
+		b.guests.mutex.Unlock()
+		return err
+	}
+	if err := g.validAt(time.Now()); err != nil {
+		b.guests.mutex.Unlock()
+		return fmt.Errorf("%w: %v", errGrantInvalid, err)
+	}
+	if !g.allowsLock(nukiId) || !g.allowsAction(action) {
+		b.guests.mutex.Unlock()
+		return errGrantInvalid
+	}
+	if !b.limiter.allowLockAction("guest:"+g.ID, nukiId) {
+		b.guests.mutex.Unlock()
+		return errRateLimited
+	}
+	g.Uses++
+	name, suffix := g.Name, g.suffix()
+	b.guests.mutex.Unlock()
+
+	log.WithField("grant", name).WithField("nukiId", nukiId).WithField("action", action.String()).WithField("source", source).Infoln("Guest lock action")
+	_, err = b.lockAction(uint(nukiId), action, suffix)
+	b.auditLockAction("guest:"+name, source, nukiId, action.String(), err)
+
+	use := GuestUse{
+		Time:    time.Now(),
+		NukiId:  nukiId,
+		Action:  action.String(),
+		Source:  source,
+		Success: err == nil,
+	}
+	b.guests.mutex.Lock()
+	if err != nil {
+		use.Error = err.Error()
+		g.Uses--
+	}
+	g.record(use)
+	b.guests.mutex.Unlock()
+	if err := b.saveConfig(); err != nil {
+		log.WithError(err).Errorln("Failed to save guest use")
+	}
+	return err
+}
+
+type guestPage struct {
+	Name    string
+	Token   string
+	Error   string
+	Locks   []guestPageLock
+	Actions []guestPageAction
+	Uses    int
+	MaxUses int
+	End     string
+}
+
+type guestPageLock struct {
+	NukiId uint32
+	Name   string
+}
+
+type guestPageAction struct {
+	Action enums.LockAction
+	Name   string
+}
+
+// guestPageHandler serves the minimal page of the link
+func (b *bridge) guestPageHandler(w http.ResponseWriter, r *http.Request) {
+	if b.rejectBanned(w, r) {
+		return
+	}
+	token := mux.Vars(r)["token"]
+	b.guests.mutex.Lock()
+	g, err := b.grantByToken(token)
+	if err != nil {
+		b.guests.mutex.Unlock()
+		b.limiter.authFailed(sourceAddress(r))
+		http.NotFound(w, r)
+		return
+	}
+	page := guestPage{
+		Name:    g.Name,
+		Token:   token,
+		Uses:    g.Uses,
+		MaxUses: g.MaxUses,
+	}
+	if !g.End.IsZero() {
+		page.End = g.End.Local().Format("2006-01-02 15:04")
+	}
+	if err := g.validAt(time.Now()); err != nil {
+		page.Error = err.Error()
+	}
+	for _, id := range g.NukiIds {
+		lock := guestPageLock{NukiId: id, Name: fmt.Sprintf("Lock %d", id)}
+		if l, err := b.GetLock(uint(id)); err == nil && l.lastConfig.Name != "" {
+			lock.Name = l.lastConfig.Name
+		}
+		page.Locks = append(page.Locks, lock)
+	}
+	for _, name := range g.Actions {
+		if action, err := parseLockAction(name); err == nil {
+			page.Actions = append(page.Actions, guestPageAction{Action: action, Name: action.String()})
+		}
+	}
+	b.guests.mutex.Unlock()
+
+	tmpl, err := loadGuestTemplate()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to load guest page")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := tmpl.Execute(w, page); err != nil {
+		log.WithError(err).Warnln("Failed to render guest page")
+	}
+}
+
+func loadGuestTemplate() (*template.Template, error) {
+	f, err := templates.Assets.Open("/guest.html")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return template.New("guest").Parse(string(data))
+}
+
+// GuestActionRequest is the body of the guest action, the action is a name or number
+type GuestActionRequest struct {
+	NukiId uint32 `json:"nukiId"`
+	Action string `json:"action"`
+}
+
+// guestActionHandler is the rest call of the link
+func (b *bridge) guestActionHandler(w http.ResponseWriter, r *http.Request) {
+	if b.rejectBanned(w, r) {
+		return
+	}
+	token := mux.Vars(r)["token"]
+	req := GuestActionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.NukiId == 0 {
+		if id, err := strconv.ParseUint(r.URL.Query().Get("nukiId"), 10, 32); err == nil {
+			req.NukiId = uint32(id)
+		}
+	}
+	action, err := parseLockAction(req.Action)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	err = b.guestAction(token, req.NukiId, action, r.RemoteAddr)
+	switch {
+	case errors.Is(err, errGrantNotFound):
+		b.limiter.authFailed(sourceAddress(r))
+		status = http.StatusNotFound
+	case errors.Is(err, errRateLimited):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, errGrantInvalid):
+		status = http.StatusForbidden
+	case err != nil:
+		// The lock could not be reached or refused the action
+		status = http.StatusServiceUnavailable
+	}
+	response := api.SimpleResponse{Success: err == nil}
+	if err != nil {
+		response.Message = err.Error()
+		log.WithError(err).WithField("source", r.RemoteAddr).Warnln("Guest lock action failed")
+	}
+	api.EncodeJSONResponse(response, &status, w)
+}
@@ -0,0 +1,100 @@
+package nukibridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+)
+
+func TestGuestGrantValidAt(t *testing.T) {
+	now := time.Date(2020, 11, 21, 10, 30, 0, 0, time.Local)
+	today := now.Weekday()
+	tomorrow := (today + 1) % 7
+	tests := []struct {
+		name  string
+		grant GuestGrant
+		valid bool
+	}{
+		{"unlimited", GuestGrant{}, true},
+		{"started", GuestGrant{Start: now.Add(-time.Hour)}, true},
+		{"not started", GuestGrant{Start: now.Add(time.Hour)}, false},
+		{"not expired", GuestGrant{End: now.Add(time.Hour)}, true},
+		{"expired", GuestGrant{End: now.Add(-time.Hour)}, false},
+		{"uses left", GuestGrant{MaxUses: 2, Uses: 1}, true},
+		{"used up", GuestGrant{MaxUses: 2, Uses: 2}, false},
+		{"unlimited uses", GuestGrant{Uses: 100}, true},
+		{"weekday", GuestGrant{Weekdays: []time.Weekday{tomorrow, today}}, true},
+		{"other weekday", GuestGrant{Weekdays: []time.Weekday{tomorrow}}, false},
+		{"inside window", GuestGrant{From: "08:00", To: "12:00"}, true},
+		{"window starts inclusive", GuestGrant{From: "10:30", To: "12:00"}, true},
+		{"window ends exclusive", GuestGrant{From: "08:00", To: "10:30"}, false},
+		{"before window", GuestGrant{From: "11:00", To: "12:00"}, false},
+		{"window over midnight", GuestGrant{From: "22:00", To: "11:00"}, true},
+		{"outside window over midnight", GuestGrant{From: "22:00", To: "06:00"}, false},
+		{"all limits", GuestGrant{Start: now.Add(-time.Hour), End: now.Add(time.Hour), MaxUses: 1, Weekdays: []time.Weekday{today}, From: "10:00", To: "11:00"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.grant.validAt(now)
+			if (err == nil) != tt.valid {
+				t.Errorf("validAt %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGuestGrantAllows(t *testing.T) {
+	g := GuestGrant{NukiIds: []uint32{1}, Actions: []string{"unlock", "Lock", "invalid"}}
+	tests := []struct {
+		nukiId uint32
+		action enums.LockAction
+		want   bool
+	}{
+		{1, enums.LockActionUnlock, true},
+		{1, enums.LockActionLock, true},
+		{1, enums.LockActionUnlatch, false},
+		{2, enums.LockActionUnlock, false},
+	}
+	for _, tt := range tests {
+		if got := g.allowsLock(tt.nukiId) && g.allowsAction(tt.action); got != tt.want {
+			t.Errorf("grant allows %v on %d: %v, want %v", tt.action, tt.nukiId, got, tt.want)
+		}
+	}
+}
+
+func TestGuestGrantSuffix(t *testing.T) {
+	tests := []struct {
+		name  string
+		grant string
+		want  string
+	}{
+		{"short", "Alice", "Guest: Alice"},
+		{"exact", "Maximilian123", "Guest: Maximilian123"},
+		{"long", "Maximilian Mustermann", "Guest: Maximilian Mu"},
+		// "ö" would be split by the 20th byte
+		{"multi-byte at the limit", "Maximilian Mö", "Guest: Maximilian M"},
+		{"multi-byte", "Jürgen", "Guest: Jürgen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := GuestGrant{Name: tt.grant}
+			if got := g.suffix(); got != tt.want {
+				t.Errorf("suffix %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuestGrantHistoryIsBounded(t *testing.T) {
+	g := GuestGrant{}
+	for i := 0; i < guestUseHistory+10; i++ {
+		g.record(GuestUse{NukiId: uint32(i)})
+	}
+	if len(g.History) != guestUseHistory {
+		t.Fatalf("history has %d uses, want %d", len(g.History), guestUseHistory)
+	}
+	if first := g.History[0].NukiId; first != 10 {
+		t.Errorf("oldest use %d, want 10", first)
+	}
+}
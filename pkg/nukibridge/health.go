@@ -0,0 +1,176 @@
+package nukibridge
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthStatus is the response of the health and readiness endpoints
+type HealthStatus struct {
+	Status       string       `json:"status"`
+	Problems     []string     `json:"problems,omitempty"`
+	DeviceOpened bool         `json:"deviceOpened"`
+	DeviceError  string       `json:"deviceError,omitempty"`
+	Scanning     bool         `json:"scanning"`
+	DeviceBusy   bool         `json:"deviceBusy"`
+	ScanError    string       `json:"scanError,omitempty"`
+	ConfigSaved  *time.Time   `json:"configSaved,omitempty"`
+	ConfigError  string       `json:"configError,omitempty"`
+	Locks        []LockHealth `json:"locks"`
+}
+
+type LockHealth struct {
+	NukiId uint32 `json:"nukiId"`
+	Name   string `json:"name,omitempty"`
+	// LastAdvertisement and SinceLastAdvertisement in seconds are omitted if none was received yet
+	LastAdvertisement      *time.Time `json:"lastAdvertisement,omitempty"`
+	SinceLastAdvertisement *float64   `json:"sinceLastAdvertisement,omitempty"`
+	LastCommand            string     `json:"lastCommand,omitempty"`
+	LastCommandTime        *time.Time `json:"lastCommandTime,omitempty"`
+}
+
+// health tracks the state of the bluetooth device and the configuration file
+type health struct {
+	mutex        sync.RWMutex
+	deviceOpened bool
+	deviceError  error
+	scanning     bool
+	scanError    error
+	configSaved  time.Time
+	configError  error
+}
+
+func (h *health) setDevice(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.deviceOpened = err == nil
+	h.deviceError = err
+}
+
+func (h *health) setScanning(scanning bool, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.scanning = scanning
+	h.scanError = err
+}
+
+func (h *health) setConfigSaved(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.configError = err
+	if err == nil {
+		h.configSaved = time.Now()
+	}
+}
+
+// healthStatus reports the bridge as unavailable if the device could not be
+// opened, scanning failed or the configuration could not be saved. Readiness
+// additionally requires a running scan and, if a timeout is configured, a
+// recent advertisement of every lock.
+func (b *bridge) healthStatus(ready bool) HealthStatus {
+	b.health.mutex.RLock()
+	status := HealthStatus{
+		Status:       HealthOK,
+		Problems:     make([]string, 0),
+		DeviceOpened: b.health.deviceOpened,
+		Scanning:     b.health.scanning,
+		DeviceBusy:   len(b.deviceLock) > 0,
+		Locks:        make([]LockHealth, 0),
+	}
+	if b.health.deviceError != nil {
+		status.DeviceError = b.health.deviceError.Error()
+	}
+	if b.health.scanError != nil {
+		status.ScanError = b.health.scanError.Error()
+	}
+	if !b.health.configSaved.IsZero() {
+		saved := b.health.configSaved
+		status.ConfigSaved = &saved
+	}
+	if b.health.configError != nil {
+		status.ConfigError = b.health.configError.Error()
+	}
+	b.health.mutex.RUnlock()
+
+	if !status.DeviceOpened {
+		status.Problems = append(status.Problems, "Bluetooth device not opened")
+	}
+	if status.ScanError != "" {
+		status.Problems = append(status.Problems, "Scanning failed")
+	}
+	if status.ConfigError != "" {
+		status.Problems = append(status.Problems, "Saving the configuration failed")
+	}
+	if ready && status.DeviceOpened && !status.Scanning && !status.DeviceBusy {
+		status.Problems = append(status.Problems, "Not scanning")
+	}
+
+	for id, l := range b.GetLocks() {
+		lh := LockHealth{
+			NukiId: uint32(id),
+			Name:   l.lastConfig.Name,
+		}
+		advertisement, command, commandTime := l.activity()
+		if !advertisement.IsZero() {
+			since := time.Since(advertisement).Seconds()
+			lh.LastAdvertisement = &advertisement
+			lh.SinceLastAdvertisement = &since
+		}
+		if !commandTime.IsZero() {
+			lh.LastCommand = command.String()
+			lh.LastCommandTime = &commandTime
+		}
+		if ready && b.options.HealthLockTimeout > 0 && time.Since(advertisement) > b.options.HealthLockTimeout {
+			status.Problems = append(status.Problems, "No recent advertisement of lock "+l.address)
+		}
+		status.Locks = append(status.Locks, lh)
+	}
+	sort.Slice(status.Locks, func(i, j int) bool {
+		return status.Locks[i].NukiId < status.Locks[j].NukiId
+	})
+
+	if len(status.Problems) > 0 {
+		status.Status = HealthUnavailable
+	}
+	return status
+}
+
+func (b *bridge) healthHandler(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if b.options.HealthToken != "" {
+			if b.rejectBanned(w, r) {
+				return
+			}
+			token := r.URL.Query().Get("token")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(b.options.HealthToken)) != 1 &&
+				subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) != 1 {
+				b.limiter.authFailed(sourceAddress(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				log.WithField("source", r.RemoteAddr).Warningln("Unauthorized health request")
+				return
+			}
+		}
+		status := b.healthStatus(ready)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		if status.Status != HealthOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.WithError(err).Warnln("Failed to encode health status")
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newHealthTestBridge returns a bridge with an opened device that is scanning
+func newHealthTestBridge(options Options) *bridge {
+	b := &bridge{
+		Locks:      make(map[uint]*lock),
+		deviceLock: make(chan bool, 1),
+		limiter:    newRateLimiter(RateLimitOptions{}),
+		options:    options,
+		token:      "api-token",
+	}
+	b.health.setDevice(nil)
+	b.health.setScanning(true, nil)
+	return b
+}
+
+func TestHealthStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      Options
+		setup        func(b *bridge)
+		wantHealth   int
+		wantReady    int
+		wantProblems []string
+	}{
+		{"healthy", Options{}, func(b *bridge) {}, http.StatusOK, http.StatusOK, nil},
+		{"device not opened", Options{}, func(b *bridge) {
+			b.health.setDevice(errors.New("no adapter"))
+			b.health.setScanning(false, nil)
+		}, http.StatusServiceUnavailable, http.StatusServiceUnavailable, []string{"Bluetooth device not opened"}},
+		{"scanning failed", Options{}, func(b *bridge) {
+			b.health.setScanning(false, errors.New("busy"))
+		}, http.StatusServiceUnavailable, http.StatusServiceUnavailable, []string{"Scanning failed", "Not scanning"}},
+		{"not scanning", Options{}, func(b *bridge) {
+			b.health.setScanning(false, nil)
+		}, http.StatusOK, http.StatusServiceUnavailable, []string{"Not scanning"}},
+		// Scanning stops while a lock is connected
+		{"device busy", Options{}, func(b *bridge) {
+			b.health.setScanning(false, nil)
+			b.deviceLock <- true
+		}, http.StatusOK, http.StatusOK, nil},
+		{"configuration not saved", Options{}, func(b *bridge) {
+			b.health.setConfigSaved(errors.New("read-only file system"))
+		}, http.StatusServiceUnavailable, http.StatusServiceUnavailable, []string{"Saving the configuration failed"}},
+		{"lock advertised", Options{HealthLockTimeout: time.Minute}, func(b *bridge) {
+			l := &lock{address: "AA:BB:CC:DD:EE:FF"}
+			l.advertised()
+			b.Locks[42] = l
+		}, http.StatusOK, http.StatusOK, nil},
+		{"lock not advertised", Options{HealthLockTimeout: time.Minute}, func(b *bridge) {
+			b.Locks[42] = &lock{address: "AA:BB:CC:DD:EE:FF"}
+		}, http.StatusOK, http.StatusServiceUnavailable, []string{"No recent advertisement of lock AA:BB:CC:DD:EE:FF"}},
+		{"lock without timeout", Options{}, func(b *bridge) {
+			b.Locks[42] = &lock{address: "AA:BB:CC:DD:EE:FF"}
+		}, http.StatusOK, http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newHealthTestBridge(tt.options)
+			tt.setup(b)
+			for _, endpoint := range []struct {
+				ready bool
+				want  int
+			}{{false, tt.wantHealth}, {true, tt.wantReady}} {
+				w := httptest.NewRecorder()
+				b.healthHandler(endpoint.ready)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+				if w.Code != endpoint.want {
+					t.Errorf("ready %v: status %d, want %d", endpoint.ready, w.Code, endpoint.want)
+				}
+			}
+			status := b.healthStatus(true)
+			if len(status.Problems) == 0 {
+				status.Problems = nil
+			}
+			if !reflect.DeepEqual(status.Problems, tt.wantProblems) {
+				t.Errorf("problems %q, want %q", status.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestHealthLockActivity(t *testing.T) {
+	b := newHealthTestBridge(Options{})
+	idle := &lock{}
+	active := &lock{}
+	active.advertised()
+	active.commandAnswered(CmdKeyturnerStates)
+	b.Locks[2] = active
+	b.Locks[1] = idle
+
+	w := httptest.NewRecorder()
+	b.healthHandler(false)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var status HealthStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Locks) != 2 || status.Locks[0].NukiId != 1 || status.Locks[1].NukiId != 2 {
+		t.Fatalf("locks %+v, want 1 and 2", status.Locks)
+	}
+	if idle := status.Locks[0]; idle.LastAdvertisement != nil || idle.LastCommandTime != nil || idle.LastCommand != "" {
+		t.Errorf("idle lock %+v, want no activity", idle)
+	}
+	if active := status.Locks[1]; active.LastAdvertisement == nil || active.SinceLastAdvertisement == nil || active.LastCommand != CmdKeyturnerStates.String() {
+		t.Errorf("active lock %+v, want its advertisement and command", active)
+	}
+}
+
+func TestHealthToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"without token", "", http.StatusUnauthorized},
+		{"wrong token", "wrong", http.StatusUnauthorized},
+		{"health token", "health-token", http.StatusOK},
+		{"api token", "api-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newHealthTestBridge(Options{HealthToken: "health-token"})
+			w := httptest.NewRecorder()
+			b.healthHandler(false)(w, httptest.NewRequest(http.MethodGet, "/healthz?token="+tt.token, nil))
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package nukibridge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	historyFilename = "history.db"
+	// historyPageSize is the number of log entries requested from the lock at once
+	historyPageSize = 32
+)
+
+// HistoryFilter selects log entries of the history store, zero values match all entries
+type HistoryFilter struct {
+	From  time.Time
+	To    time.Time
+	Types []enums.LogType
+	// AuthID matches the authorization of the entry if set
+	AuthID *uint32
+	// Name matches a part of the authorization name, case insensitive
+	Name string
+	// Action matches the lock action of lock action and keypad entries if set
+	Action *enums.LockAction
+	// Ascending sorts the oldest entry first, the newest entry comes first otherwise
+	Ascending bool
+	Offset    int
+	Count     int
+}
+
+func (f HistoryFilter) matches(e models.LogEntry) bool {
+	if !f.From.IsZero() && e.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Timestamp.After(f.To) {
+		return false
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.AuthID != nil && e.AuthID != *f.AuthID {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if f.Action != nil {
+		action, ok := entryAction(e)
+		if !ok || action != *f.Action {
+			return false
+		}
+	}
+	return true
+}
+
+// entryAction returns the lock action of lock action and keypad entries
+func entryAction(e models.LogEntry) (enums.LockAction, bool) {
+	switch details := e.Details.(type) {
+	case models.LogEntryTypeLockAction:
+		return details.LockAction, true
+	case models.LogEntryTypeKeypadAction:
+		return details.LockAction, true
+	}
+	return 0, false
+}
+
+// decodeStoredEntry restores the detail struct of the log type
+func decodeStoredEntry(data []byte) (models.LogEntry, error) {
+	var stored struct {
+		models.LogEntry
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return models.LogEntry{}, err
+	}
+	entry := stored.LogEntry
+	if len(stored.Details) == 0 || string(stored.Details) == "null" {
+		return entry, nil
+	}
+	switch entry.Type {
+	case enums.LogTypeLogging, enums.LogTypeDoorSensorLogging:
+		details := models.LogEntryTypeLogging{}
+		err := json.Unmarshal(stored.Details, &details)
+		entry.Details = details
+		return entry, err
+	case enums.LogTypeLockAction, enums.LogTypeCalibration, enums.LogTypeInitializationRun:
+		details := models.LogEntryTypeLockAction{}
+		err := json.Unmarshal(stored.Details, &details)
+		entry.Details = details
+		return entry, err
+	case enums.LogTypeKeypadAction:
+		details := models.LogEntryTypeKeypadAction{}
+		err := json.Unmarshal(stored.Details, &details)
+		entry.Details = details
+		return entry, err
+	case enums.LogTypeDoorSensor:
+		details := models.LogEntryTypeDoorSensor{}
+		err := json.Unmarshal(stored.Details, &details)
+		entry.Details = details
+		return entry, err
+	}
+	return entry, nil
+}
+
+// parseHistoryFilter reads the query parameters of history requests, 20 entries are returned by default
+func parseHistoryFilter(offset string, count string, from string, to string, types string, authId string, name string, action string, order string) (HistoryFilter, error) {
+	filter := HistoryFilter{
+		Name:  name,
+		Count: 20,
+	}
+	if offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil {
+			return filter, err
+		}
+		filter.Offset = o
+	}
+	if count != "" {
+		c, err := strconv.Atoi(count)
+		if err != nil {
+			return filter, err
+		}
+		filter.Count = c
+	}
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, err
+		}
+		filter.From = t
+	}
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, err
+		}
+		filter.To = t
+	}
+	for _, value := range strings.Split(types, ",") {
+		if value == "" {
+			continue
+		}
+		t, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return filter, err
+		}
+		filter.Types = append(filter.Types, enums.LogType(t))
+	}
+	if authId != "" {
+		a, err := strconv.ParseUint(authId, 10, 32)
+		if err != nil {
+			return filter, err
+		}
+		id := uint32(a)
+		filter.AuthID = &id
+	}
+	if action != "" {
+		a, err := parseLockAction(action)
+		if err != nil {
+			return filter, err
+		}
+		filter.Action = &a
+	}
+	switch order {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("Unknown order %s", order)
+	}
+	return filter, nil
+}
+
+// historyStore keeps the log entries of all locks in a bucket per lock, keyed by their index
+type historyStore struct {
+	db *bolt.DB
+}
+
+func openHistoryStore(dir string) (*historyStore, error) {
+	db, err := bolt.Open(path.Join(dir, historyFilename), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &historyStore{db: db}, nil
+}
+
+func historyBucket(nukiId uint32) []byte {
+	return []byte(fmt.Sprint(nukiId))
+}
+
+func historyKey(index uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, index)
+	return key
+}
+
+// watermark is the index of the newest stored entry of the lock, 0 if there is none
+func (h *historyStore) watermark(nukiId uint32) (uint32, error) {
+	var index uint32
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket(nukiId))
+		if bucket == nil {
+			return nil
+		}
+		if key, _ := bucket.Cursor().Last(); key != nil {
+			index = binary.BigEndian.Uint32(key)
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (h *historyStore) store(nukiId uint32, entries []models.LogEntry) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(historyBucket(nukiId))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(historyKey(entry.Index), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// query returns the matching entries of the page given by offset and count
+func (h *historyStore) query(nukiId uint32, filter HistoryFilter) ([]models.LogEntry, error) {
+	entries := make([]models.LogEntry, 0)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket(nukiId))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		first, next := cursor.Last, cursor.Prev
+		if filter.Ascending {
+			first, next = cursor.First, cursor.Next
+		}
+		skipped := 0
+		for key, value := first(); key != nil; key, value = next() {
+			entry, err := decodeStoredEntry(value)
+			if err != nil {
+				return err
+			}
+			if !filter.matches(entry) {
+				continue
+			}
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+			entries = append(entries, entry)
+			if filter.Count > 0 && len(entries) >= filter.Count {
+				break
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// requestLogPage reserves the device for a single page only, so lock actions
+// are not held up by long synchronizations
+func (b *bridge) requestLogPage(l *lock, start uint32) ([]models.LogEntry, error) {
+	if err := b.aquireDevice(); err != nil {
+		return nil, err
+	}
+	defer b.releaseDevice()
+	return l.RequestLogEntries(start, historyPageSize)
+}
+
+// syncHistory pulls all entries newer than the watermark from the lock, the newest first.
+// Without watermark at most HistoryBackfill entries are read, rounded up to whole pages.
+func (b *bridge) syncHistory(l *lock) error {
+	watermark, err := b.history.watermark(l.nukiID)
+	if err != nil {
+		return err
+	}
+	var entries []models.LogEntry
+	start := uint32(0)
+	for {
+		page, err := b.requestLogPage(l, start)
+		if err != nil {
+			return err
+		}
+		lowest := uint32(0)
+		for _, entry := range page {
+			if entry.Index > watermark {
+				entries = append(entries, entry)
+			}
+			if lowest == 0 || entry.Index < lowest {
+				lowest = entry.Index
+			}
+		}
+		if len(page) < historyPageSize || lowest <= watermark+1 {
+			break
+		}
+		if watermark == 0 && b.options.HistoryBackfill > 0 && len(entries) >= b.options.HistoryBackfill {
+			break
+		}
+		start = lowest - 1
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := b.history.store(l.nukiID, entries); err != nil {
+		return err
+	}
+	log.WithField("nukiId", l.nukiID).WithField("entries", len(entries)).Infoln("History synchronized")
+	b.notifyLogEntries(l, entries)
+	return nil
+}
+
+// startHistorySync synchronizes the history of all locks in the background
+func (b *bridge) startHistorySync() {
+	for _, l := range b.GetLocks() {
+		watermark, err := b.history.watermark(l.nukiID)
+		if err != nil {
+			log.WithError(err).WithField("nukiId", l.nukiID).Errorln("Failed to read history watermark")
+			continue
+		}
+		// Entries up to the watermark were already published
+		l.lastLogIndex = watermark
+	}
+	if b.options.HistorySyncInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(b.options.HistorySyncInterval)
+		defer ticker.Stop()
+		for {
+			for _, l := range b.GetLocks() {
+				if err := b.syncHistory(l); err != nil {
+					log.WithError(err).WithField("nukiId", l.nukiID).Warnln("Failed to synchronize history")
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
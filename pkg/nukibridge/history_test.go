@@ -0,0 +1,180 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+var historyTestStart = time.Date(2020, 11, 21, 10, 0, 0, 0, time.UTC)
+
+// newHistoryTestStore stores 10 entries of lock 1, odd indices are lock actions of
+// Alice alternating between unlock and lock, even indices door sensor entries of Bob
+func newHistoryTestStore(t *testing.T) *historyStore {
+	dir, err := ioutil.TempDir("", "nukibridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := openHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.db.Close()
+		os.RemoveAll(dir)
+	})
+	entries := make([]models.LogEntry, 0, 10)
+	for i := uint32(1); i <= 10; i++ {
+		entry := models.LogEntry{
+			Index:     i,
+			Timestamp: historyTestStart.Add(time.Duration(i) * time.Minute),
+		}
+		if i%2 == 1 {
+			action := enums.LockActionUnlock
+			if i%4 == 3 {
+				action = enums.LockActionLock
+			}
+			entry.AuthID = 1
+			entry.Name = "Alice"
+			entry.Type = enums.LogTypeLockAction
+			entry.Details = models.LogEntryTypeLockAction{LockAction: action}
+		} else {
+			entry.AuthID = 2
+			entry.Name = "Bob"
+			entry.Type = enums.LogTypeDoorSensor
+			entry.Details = models.LogEntryTypeDoorSensor{DoorSensor: 1}
+		}
+		entries = append(entries, entry)
+	}
+	if err := h.store(1, entries); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHistoryQuery(t *testing.T) {
+	authID := uint32(2)
+	lock := enums.LockActionLock
+	tests := []struct {
+		name   string
+		nukiId uint32
+		filter HistoryFilter
+		want   []uint32
+	}{
+		{"newest first", 1, HistoryFilter{}, []uint32{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{"ascending", 1, HistoryFilter{Ascending: true}, []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"first page", 1, HistoryFilter{Count: 3}, []uint32{10, 9, 8}},
+		{"second page", 1, HistoryFilter{Offset: 3, Count: 3}, []uint32{7, 6, 5}},
+		{"last page", 1, HistoryFilter{Offset: 9, Count: 3}, []uint32{1}},
+		{"beyond last page", 1, HistoryFilter{Offset: 10, Count: 3}, []uint32{}},
+		{"time window", 1, HistoryFilter{From: historyTestStart.Add(3 * time.Minute), To: historyTestStart.Add(5 * time.Minute)}, []uint32{5, 4, 3}},
+		{"type", 1, HistoryFilter{Types: []enums.LogType{enums.LogTypeDoorSensor}, Count: 2}, []uint32{10, 8}},
+		{"types", 1, HistoryFilter{Types: []enums.LogType{enums.LogTypeDoorSensor, enums.LogTypeLockAction}, Count: 2}, []uint32{10, 9}},
+		{"authorization", 1, HistoryFilter{AuthID: &authID, Ascending: true, Count: 2}, []uint32{2, 4}},
+		{"name part", 1, HistoryFilter{Name: "lic", Count: 2}, []uint32{9, 7}},
+		{"action", 1, HistoryFilter{Action: &lock}, []uint32{7, 3}},
+		{"filtered page", 1, HistoryFilter{Name: "alice", Offset: 1, Count: 2}, []uint32{7, 5}},
+		{"other lock", 2, HistoryFilter{}, []uint32{}},
+	}
+	h := newHistoryTestStore(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := h.query(tt.nukiId, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint32, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.Index)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryWatermark(t *testing.T) {
+	h := newHistoryTestStore(t)
+	tests := []struct {
+		nukiId uint32
+		want   uint32
+	}{
+		{1, 10},
+		{2, 0},
+	}
+	for _, tt := range tests {
+		got, err := h.watermark(tt.nukiId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("watermark of %d is %d, want %d", tt.nukiId, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeStoredEntry(t *testing.T) {
+	tests := []models.LogEntry{
+		{Index: 1, Type: enums.LogTypeLogging, Details: models.LogEntryTypeLogging{Logging: true}},
+		{Index: 2, Type: enums.LogTypeLockAction, Details: models.LogEntryTypeLockAction{LockAction: enums.LockActionUnlatch, Flags: 1}},
+		{Index: 3, Type: enums.LogTypeKeypadAction, Details: models.LogEntryTypeKeypadAction{LockAction: enums.LockActionUnlock, CodeID: 7}},
+		{Index: 4, Type: enums.LogTypeDoorSensor, Details: models.LogEntryTypeDoorSensor{DoorSensor: 2}},
+		{Index: 5, Type: enums.LogTypeLockAction},
+	}
+	for _, entry := range tests {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeStoredEntry(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, entry) {
+			t.Errorf("decoded %+v, want %+v", got, entry)
+		}
+	}
+}
+
+func TestParseHistoryFilter(t *testing.T) {
+	authID := uint32(5)
+	unlock := enums.LockActionUnlock
+	tests := []struct {
+		name    string
+		params  []string // offset, count, from, to, types, authId, name, action, order
+		want    HistoryFilter
+		wantErr bool
+	}{
+		{"defaults", []string{"", "", "", "", "", "", "", "", ""}, HistoryFilter{Count: 20}, false},
+		{"page", []string{"40", "10", "", "", "", "", "", "", ""}, HistoryFilter{Offset: 40, Count: 10}, false},
+		{"time window", []string{"", "", "2020-11-21T10:00:00Z", "2020-11-22T10:00:00Z", "", "", "", "", ""},
+			HistoryFilter{From: historyTestStart, To: historyTestStart.Add(24 * time.Hour), Count: 20}, false},
+		{"types", []string{"", "", "", "", "2,6", "", "", "", ""}, HistoryFilter{Types: []enums.LogType{enums.LogTypeLockAction, enums.LogTypeDoorSensor}, Count: 20}, false},
+		{"authorization and name", []string{"", "", "", "", "", "5", "Alice", "", ""}, HistoryFilter{AuthID: &authID, Name: "Alice", Count: 20}, false},
+		{"action by name", []string{"", "", "", "", "", "", "", "unlock", "asc"}, HistoryFilter{Action: &unlock, Ascending: true, Count: 20}, false},
+		{"invalid count", []string{"", "ten", "", "", "", "", "", "", ""}, HistoryFilter{}, true},
+		{"invalid time", []string{"", "", "yesterday", "", "", "", "", "", ""}, HistoryFilter{}, true},
+		{"invalid type", []string{"", "", "", "", "2,x", "", "", "", ""}, HistoryFilter{}, true},
+		{"invalid action", []string{"", "", "", "", "", "", "", "open", ""}, HistoryFilter{}, true},
+		{"invalid order", []string{"", "", "", "", "", "", "", "", "random"}, HistoryFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.params
+			got, err := parseHistoryFilter(p[0], p[1], p[2], p[3], p[4], p[5], p[6], p[7], p[8])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package nukibridge
+
+import (
+	"fmt"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+)
+
+// Jinja template mapping the lock state of the state topic to the home assistant lock states
+const haLockStateTemplate = "{{ {1: 'LOCKED', 2: 'UNLOCKING', 3: 'UNLOCKED', 4: 'LOCKING', 5: 'UNLOCKED', 6: 'UNLOCKED', 7: 'UNLOCKING', 254: 'JAMMED'}.get(value_json.lockState, 'unknown') }}"
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+	HwVersion    string   `json:"hw_version,omitempty"`
+}
+
+// haEntity is the discovery payload of all entities, unused properties are omitted
+type haEntity struct {
+	Name              string    `json:"name"`
+	UniqueID          string    `json:"unique_id"`
+	ObjectID          string    `json:"object_id"`
+	Device            *haDevice `json:"device"`
+	AvailabilityTopic string    `json:"availability_topic"`
+	StateTopic        string    `json:"state_topic"`
+	ValueTemplate     string    `json:"value_template"`
+	DeviceClass       string    `json:"device_class,omitempty"`
+	EntityCategory    string    `json:"entity_category,omitempty"`
+
+	// lock
+	CommandTopic   string `json:"command_topic,omitempty"`
+	PayloadLock    string `json:"payload_lock,omitempty"`
+	PayloadUnlock  string `json:"payload_unlock,omitempty"`
+	PayloadOpen    string `json:"payload_open,omitempty"`
+	StateLocked    string `json:"state_locked,omitempty"`
+	StateUnlocked  string `json:"state_unlocked,omitempty"`
+	StateLocking   string `json:"state_locking,omitempty"`
+	StateUnlocking string `json:"state_unlocking,omitempty"`
+	StateJammed    string `json:"state_jammed,omitempty"`
+
+	// binary sensor
+	PayloadOn  string `json:"payload_on,omitempty"`
+	PayloadOff string `json:"payload_off,omitempty"`
+}
+
+// publishDiscovery announces the entities of the lock to home assistant
+func (m *mqttPublisher) publishDiscovery(nukiId uint32, l *lock) {
+	if !m.options.Discovery {
+		return
+	}
+	name := l.lastConfig.Name
+	if name == "" {
+		name = fmt.Sprintf("Nuki %d", nukiId)
+	}
+	id := fmt.Sprintf("nuki_%d", nukiId)
+	device := &haDevice{
+		Identifiers:  []string{id},
+		Name:         name,
+		Manufacturer: "Nuki",
+		Model:        "Smart Lock",
+	}
+	if l.lastConfig.NukiID == nukiId {
+		device.SwVersion = l.lastConfig.FirmwareVersion
+		device.HwVersion = l.lastConfig.HardwareRevision
+	}
+	entity := func(suffix string, entityName string, stateTopic string) haEntity {
+		return haEntity{
+			Name:              entityName,
+			UniqueID:          id + suffix,
+			ObjectID:          id + suffix,
+			Device:            device,
+			AvailabilityTopic: m.topic("status"),
+			StateTopic:        m.lockTopic(nukiId, stateTopic),
+		}
+	}
+
+	lock := entity("", name, "state")
+	lock.ValueTemplate = haLockStateTemplate
+	lock.CommandTopic = m.lockTopic(nukiId, "command")
+	lock.PayloadLock = "lock"
+	lock.PayloadUnlock = "unlock"
+	lock.PayloadOpen = "unlatch"
+	lock.StateLocked = "LOCKED"
+	lock.StateUnlocked = "UNLOCKED"
+	lock.StateLocking = "LOCKING"
+	lock.StateUnlocking = "UNLOCKING"
+	lock.StateJammed = "JAMMED"
+	m.publish(m.discoveryTopic("lock", id), true, lock)
+
+	battery := entity("_battery_critical", name+" Battery Critical", "battery")
+	battery.DeviceClass = "battery"
+	battery.ValueTemplate = "{{ 'ON' if value_json.batteryCritical else 'OFF' }}"
+	battery.PayloadOn = "ON"
+	battery.PayloadOff = "OFF"
+	m.publish(m.discoveryTopic("binary_sensor", id+"_battery_critical"), true, battery)
+
+	door := entity("_door", name+" Door", "state")
+	door.DeviceClass = "door"
+	door.ValueTemplate = fmt.Sprintf("{{ 'ON' if value_json.doorSensorState == %d else 'OFF' }}", enums.DoorSensorStateDoorOpened)
+	door.PayloadOn = "ON"
+	door.PayloadOff = "OFF"
+	m.publish(m.discoveryTopic("binary_sensor", id+"_door"), true, door)
+
+	firmware := entity("_firmware", name+" Firmware", "config")
+	firmware.EntityCategory = "diagnostic"
+	firmware.ValueTemplate = "{{ value_json.firmwareVersion }}"
+	m.publish(m.discoveryTopic("sensor", id+"_firmware"), true, firmware)
+
+	hardware := entity("_hardware", name+" Hardware Revision", "config")
+	hardware.EntityCategory = "diagnostic"
+	hardware.ValueTemplate = "{{ value_json.hardwareRevision }}"
+	m.publish(m.discoveryTopic("sensor", id+"_hardware"), true, hardware)
+}
+
+func (m *mqttPublisher) discoveryTopic(component string, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/config", m.options.DiscoveryPrefix, component, objectID)
+}
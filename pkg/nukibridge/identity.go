@@ -0,0 +1,244 @@
+package nukibridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	identityArchiveFormat  = "nukibridge-identity"
+	identityArchiveVersion = 1
+	// identityPassphraseLength is the minimum length of archive passphrases, archives leave the host
+	identityPassphraseLength = 8
+)
+
+var errIdentityImported = errors.New("Identity of the archive is already imported, force the import to replace its locks and callbacks")
+
+// identityPayload is the encrypted content of an identity archive. The
+// configuration is kept raw to migrate archives of older versions.
+type identityPayload struct {
+	Configuration json.RawMessage      `json:"configuration"`
+	Callbacks     []api.CallbackConfig `json:"callbacks,omitempty"`
+}
+
+// sealIdentity encrypts the unencrypted configuration and the callbacks with the passphrase
+func sealIdentity(cfg Configuration, callbacks []api.CallbackConfig, passphrase string) (api.IdentityArchive, error) {
+	if len(passphrase) < identityPassphraseLength {
+		return api.IdentityArchive{}, fmt.Errorf("Passphrase needs at least %d characters", identityPassphraseLength)
+	}
+	cfg.Encryption = nil
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return api.IdentityArchive{}, err
+	}
+	data, err := json.Marshal(identityPayload{Configuration: raw, Callbacks: callbacks})
+	if err != nil {
+		return api.IdentityArchive{}, err
+	}
+	k, err := newSecretKey(passphrase)
+	if err != nil {
+		return api.IdentityArchive{}, err
+	}
+	enc, err := k.sealData(data)
+	if err != nil {
+		return api.IdentityArchive{}, err
+	}
+	archive := api.IdentityArchive{
+		Format:    identityArchiveFormat,
+		Version:   identityArchiveVersion,
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		PublicKey: cfg.PublicKey,
+		Kdf:       enc.KDF,
+		Salt:      enc.Salt,
+		N:         int32(enc.N),
+		R:         int32(enc.R),
+		P:         int32(enc.P),
+		Data:      enc.Secrets,
+	}
+	for id := range cfg.Locks {
+		if nukiId, err := strconv.ParseUint(id, 10, 32); err == nil {
+			archive.NukiIds = append(archive.NukiIds, int32(nukiId))
+		}
+	}
+	return archive, nil
+}
+
+// openIdentity decrypts the archive and migrates its configuration
+func openIdentity(archive api.IdentityArchive, passphrase string) (Configuration, []api.CallbackConfig, error) {
+	var cfg Configuration
+	if archive.Format != identityArchiveFormat {
+		return cfg, nil, errors.New("Not an identity archive")
+	}
+	if archive.Version > identityArchiveVersion {
+		return cfg, nil, fmt.Errorf("Identity archive version %d is newer than the supported version %d", archive.Version, identityArchiveVersion)
+	}
+	_, data, err := openData(&EncryptionConfiguration{
+		KDF:     archive.Kdf,
+		Salt:    archive.Salt,
+		N:       int(archive.N),
+		R:       int(archive.R),
+		P:       int(archive.P),
+		Secrets: archive.Data,
+	}, passphrase)
+	if err != nil {
+		return cfg, nil, err
+	}
+	var payload identityPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return cfg, nil, err
+	}
+	raw, _, err := migrateConfig(payload.Configuration)
+	if err != nil {
+		return cfg, nil, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.PrivateKey == "" || cfg.PublicKey == "" {
+		return cfg, nil, errors.New("Identity archive contains no keys")
+	}
+	cfg.Version = configVersion
+	if len(payload.Callbacks) == 0 {
+		// Callbacks are part of the configuration since they are persisted
+		payload.Callbacks = cfg.Callbacks
+	}
+	return cfg, payload.Callbacks, nil
+}
+
+// exportIdentity seals the keys, locks, identities and callbacks of the running bridge
+func (b *bridge) exportIdentity(passphrase string) (api.IdentityArchive, error) {
+	return sealIdentity(b.currentConfig(), b.service.callbackConfigs(), passphrase)
+}
+
+// importIdentity replaces the identity of the bridge by the one of the archive and verifies
+// that every lock still accepts the authorization. Paired locks are only replaced by the
+// identity of another bridge if forced. Importing the current identity again is refused unless forced,
+// it would drop locks paired since the archive was exported.
+func (b *bridge) importIdentity(archive api.IdentityArchive, passphrase string, force bool) (api.IdentityImportResult, error) {
+	result := api.IdentityImportResult{Locks: make([]api.LockVerification, 0)}
+	cfg, callbacks, err := openIdentity(archive, passphrase)
+	if err != nil {
+		return result, err
+	}
+	pub, _ := b.keys()
+	current := base64.StdEncoding.EncodeToString(pub[:])
+	if cfg.PublicKey == current && !force {
+		return result, errIdentityImported
+	}
+	if len(b.GetLocks()) > 0 && cfg.PublicKey != current && !force {
+		return result, errors.New("Locks are paired with this bridge, force the import to replace them")
+	}
+	available := b.aquireDevice() == nil
+	if available {
+		defer b.releaseDevice()
+	}
+	for _, l := range b.GetLocks() {
+		l.Disconnect()
+	}
+	if err := b.applyConfig(cfg); err != nil {
+		return result, err
+	}
+	for id, l := range b.GetLocks() {
+		l.nukiID = uint32(id)
+		l.publish = b.publish
+		if watermark, err := b.history.watermark(l.nukiID); err == nil {
+			l.lastLogIndex = watermark
+		}
+		result.Locks = append(result.Locks, b.verifyLock(l, available))
+	}
+	restored, err := b.service.restoreCallbacks(callbacks)
+	result.Callbacks = int32(restored)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to restore callbacks of identity archive")
+	}
+	if err := b.saveConfig(); err != nil {
+		return result, err
+	}
+	log.WithField("locks", len(result.Locks)).WithField("callbacks", restored).Infoln("Identity imported")
+	return result, nil
+}
+
+// deviceAvailable is true if the bluetooth device was opened and scanning was started
+func (b *bridge) deviceAvailable() bool {
+	b.health.mutex.RLock()
+	defer b.health.mutex.RUnlock()
+	return b.health.deviceOpened && b.cancelScan != nil
+}
+
+// verifyLock requests the config of the lock, which needs a valid authorization.
+// The device has to be aquired.
+func (b *bridge) verifyLock(l *lock, available bool) api.LockVerification {
+	verification := api.LockVerification{NukiId: int32(l.nukiID)}
+	if !available {
+		verification.Error = errDeviceUnavailable.Error()
+		return verification
+	}
+	config, err := l.RequestConfig()
+	l.Disconnect()
+	switch {
+	case err != nil:
+		verification.Error = err.Error()
+	case config.NukiID != l.nukiID:
+		verification.Error = fmt.Sprintf("Lock at %s is %d", l.address, config.NukiID)
+	default:
+		l.lastConfig = config
+		verification.Name = config.Name
+		verification.Verified = true
+	}
+	logger := log.WithField("nukiId", l.nukiID).WithField("verified", verification.Verified)
+	if !verification.Verified {
+		logger = logger.WithField("error", verification.Error)
+	}
+	logger.Infoln("Verified authorization of lock")
+	return verification
+}
+
+// ImportIdentity imports the identity archive into the running bridge. It is skipped if the
+// identity was imported before, e.g. by an earlier start with the same archive.
+func (b *bridge) ImportIdentity(data []byte, passphrase string, force bool) (api.IdentityImportResult, error) {
+	var archive api.IdentityArchive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return api.IdentityImportResult{}, err
+	}
+	result, err := b.importIdentity(archive, passphrase, force)
+	if err == errIdentityImported {
+		log.Infoln("Identity of the archive is already imported, skipping the import")
+		return result, nil
+	}
+	e := AuditEntry{Identity: "cli", Operation: "identityImport", Outcome: AuditSuccess}
+	if err != nil {
+		e.Outcome = AuditFailure
+		e.Error = err.Error()
+	}
+	b.audit.record(e)
+	return result, err
+}
+
+// ExportIdentity reads the configuration in the path and seals it as identity archive,
+// the bridge should not run meanwhile
+func ExportIdentity(dir string, configPassphrase string, passphrase string) ([]byte, error) {
+	cfg, _, err := readConfigFile(path.Join(dir, filename))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption != nil {
+		if _, err := openSecrets(&cfg, configPassphrase); err != nil {
+			return nil, err
+		}
+	}
+	archive, err := sealIdentity(cfg, nil, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// Not audited, a running bridge appends to the same audit log and the hash chain would fork
+	log.Infoln("Identity exported from the command line")
+	return json.MarshalIndent(archive, "", " ")
+}
@@ -0,0 +1,163 @@
+package nukibridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+)
+
+const identityTestPassphrase = "correct horse battery"
+
+func TestSealOpenIdentity(t *testing.T) {
+	cfg := newSecretsTestConfig()
+	callbacks := []api.CallbackConfig{{Id: 1, Url: "http://example.com/callback", NukiIds: []int32{42}}}
+	tests := []struct {
+		name      string
+		callbacks []api.CallbackConfig
+		persisted []api.CallbackConfig
+	}{
+		{"callbacks of the archive", callbacks, nil},
+		// Archives of bridges persisting the callbacks in the configuration
+		{"callbacks of the configuration", nil, callbacks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed := cfg
+			sealed.Callbacks = tt.persisted
+			archive, err := sealIdentity(sealed, tt.callbacks, identityTestPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if archive.PublicKey != cfg.PublicKey || !reflect.DeepEqual(archive.NukiIds, []int32{42}) {
+				t.Errorf("archive describes %s %v, want %s [42]", archive.PublicKey, archive.NukiIds, cfg.PublicKey)
+			}
+			opened, openedCallbacks, err := openIdentity(archive, identityTestPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened.PrivateKey != cfg.PrivateKey || !reflect.DeepEqual(opened.Locks, cfg.Locks) {
+				t.Errorf("opened %+v, want %+v", opened, cfg)
+			}
+			if !reflect.DeepEqual(openedCallbacks, callbacks) {
+				t.Errorf("callbacks %+v, want %+v", openedCallbacks, callbacks)
+			}
+		})
+	}
+}
+
+func TestOpenIdentityRejects(t *testing.T) {
+	archive, err := sealIdentity(newSecretsTestConfig(), nil, identityTestPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noKeys, err := sealIdentity(Configuration{PublicKey: "public"}, nil, identityTestPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		archive    func(archive api.IdentityArchive) api.IdentityArchive
+		passphrase string
+	}{
+		{"wrong passphrase", func(a api.IdentityArchive) api.IdentityArchive { return a }, "wrong horse battery"},
+		{"empty passphrase", func(a api.IdentityArchive) api.IdentityArchive { return a }, ""},
+		{"tampered data", func(a api.IdentityArchive) api.IdentityArchive {
+			a.Data = a.Data[:len(a.Data)-4] + "AAAA"
+			return a
+		}, identityTestPassphrase},
+		{"tampered salt", func(a api.IdentityArchive) api.IdentityArchive {
+			a.Salt = "AAAAAAAAAAAAAAAAAAAAAA=="
+			return a
+		}, identityTestPassphrase},
+		{"other format", func(a api.IdentityArchive) api.IdentityArchive {
+			a.Format = "backup"
+			return a
+		}, identityTestPassphrase},
+		{"newer version", func(a api.IdentityArchive) api.IdentityArchive {
+			a.Version = identityArchiveVersion + 1
+			return a
+		}, identityTestPassphrase},
+		{"without keys", func(api.IdentityArchive) api.IdentityArchive { return noKeys }, identityTestPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := openIdentity(tt.archive(archive), tt.passphrase); err == nil {
+				t.Error("archive opened")
+			}
+		})
+	}
+}
+
+func TestSealIdentityShortPassphrase(t *testing.T) {
+	if _, err := sealIdentity(newSecretsTestConfig(), nil, "short"); err == nil {
+		t.Error("archive sealed with a short passphrase")
+	}
+}
+
+func TestImportIdentityRefusesReplacingLocks(t *testing.T) {
+	var own [32]byte
+	copy(own[:], "own public key of the bridge....")
+	cfg := newSecretsTestConfig()
+	cfg.PublicKey = base64.StdEncoding.EncodeToString(own[:])
+	archive, err := sealIdentity(cfg, nil, identityTestPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newSecretsTestConfig()
+	otherArchive, err := sealIdentity(other, nil, identityTestPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		archive api.IdentityArchive
+		locks   int
+		want    error
+	}{
+		{"own identity again", archive, 0, errIdentityImported},
+		{"own identity with locks", archive, 1, errIdentityImported},
+		{"other identity with locks", otherArchive, 1, errors.New("Locks are paired with this bridge, force the import to replace them")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bridge{PublicKey: own, Locks: make(map[uint]*lock)}
+			for i := 0; i < tt.locks; i++ {
+				b.Locks[uint(i+1)] = &lock{}
+			}
+			_, err := b.importIdentity(tt.archive, identityTestPassphrase, false)
+			if err == nil || err.Error() != tt.want.Error() {
+				t.Fatalf("error %v, want %v", err, tt.want)
+			}
+			if len(b.Locks) != tt.locks {
+				t.Errorf("%d locks after the refused import, want %d", len(b.Locks), tt.locks)
+			}
+		})
+	}
+}
+
+func TestImportIdentitySkipsImportedArchive(t *testing.T) {
+	var own [32]byte
+	copy(own[:], "own public key of the bridge....")
+	cfg := newSecretsTestConfig()
+	cfg.PublicKey = base64.StdEncoding.EncodeToString(own[:])
+	archive, err := sealIdentity(cfg, nil, identityTestPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bridge{PublicKey: own, Locks: map[uint]*lock{7: {}}}
+	result, err := b.ImportIdentity(data, identityTestPassphrase, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Locks) != 0 || len(b.Locks) != 1 || b.Locks[7] == nil {
+		t.Errorf("import of the imported archive not skipped: %+v", result)
+	}
+}
@@ -0,0 +1,190 @@
+package nukibridge
+
+import (
+	"fmt"
+	"time"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	influxBuffer    = 256
+	influxBatchSize = 100
+)
+
+// InfluxOptions configures the export of lock telemetry to influxdb
+type InfluxOptions struct {
+	// URL of the influxdb, e.g. http://localhost:8086. The export is disabled if empty.
+	URL             string
+	Database        string
+	Username        string
+	Password        string
+	RetentionPolicy string
+	// FlushInterval is the maximum time points are buffered before they are written
+	FlushInterval time.Duration
+}
+
+type influxExporter struct {
+	bridge  *bridge
+	options InfluxOptions
+	client  client.Client
+
+	points     []*client.Point
+	lockStates map[uint32]enums.LockState
+}
+
+func newInfluxExporter(b *bridge, options InfluxOptions) (*influxExporter, error) {
+	if options.Database == "" {
+		options.Database = "nukibridge"
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 10 * time.Second
+	}
+	c, err := client.NewHTTPClient(client.HTTPConfig{
+		Addr:     options.URL,
+		Username: options.Username,
+		Password: options.Password,
+		Timeout:  10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &influxExporter{
+		bridge:     b,
+		options:    options,
+		client:     c,
+		lockStates: make(map[uint32]enums.LockState),
+	}, nil
+}
+
+func (e *influxExporter) start() {
+	log.WithField("url", e.options.URL).WithField("database", e.options.Database).Infoln("Exporting lock telemetry to influxdb")
+	go e.run()
+}
+
+func (e *influxExporter) run() {
+	flush := time.NewTicker(e.options.FlushInterval)
+	defer flush.Stop()
+	for {
+		sub := e.bridge.service.events.subscribe(influxBuffer)
+		e.consume(sub, flush.C)
+		log.Warnln("Influxdb exporter fell behind, resubscribing")
+	}
+}
+
+func (e *influxExporter) consume(sub *subscription, flush <-chan time.Time) {
+	for {
+		select {
+		case <-sub.dropped:
+			return
+		case <-flush:
+			e.flush()
+		case event := <-sub.events:
+			e.record(event)
+			if len(e.points) >= influxBatchSize {
+				e.flush()
+			}
+		}
+	}
+}
+
+// record converts the event into points, events without telemetry are ignored
+func (e *influxExporter) record(event Event) {
+	switch data := event.Data.(type) {
+	case StateEvent:
+		e.add("battery", data.NukiId, nil, map[string]interface{}{
+			"critical": data.CriticalBatteryState,
+		}, data.Timestamp)
+		previous, known := e.lockStates[data.NukiId]
+		e.lockStates[data.NukiId] = data.LockState
+		if known && previous == data.LockState {
+			return
+		}
+		e.add("lock_state", data.NukiId, map[string]string{
+			"state": data.LockState.String(),
+		}, map[string]interface{}{
+			"state":    int(data.LockState),
+			"previous": int(previous),
+			"trigger":  int(data.Trigger),
+		}, data.Timestamp)
+	case DoorSensorEvent:
+		e.add("door_sensor", data.NukiId, map[string]string{
+			"state": data.DoorSensorStateName,
+		}, map[string]interface{}{
+			"state": int(data.DoorSensorState),
+			"open":  data.DoorSensorState == enums.DoorSensorStateDoorOpened,
+		}, data.Timestamp)
+	case LockActionEvent:
+		if event.Event != EventLockActionCompleted && event.Event != EventLockActionFailed {
+			return
+		}
+		tags := map[string]string{
+			"action": data.ActionName,
+			"status": data.CompletionStatusName,
+		}
+		fields := map[string]interface{}{
+			"duration": data.Duration,
+			"success":  event.Event == EventLockActionCompleted,
+		}
+		if data.CompletionStatus != nil {
+			fields["completion_status"] = int(*data.CompletionStatus)
+		}
+		if data.Error != "" {
+			fields["error"] = data.Error
+		}
+		e.add("lock_action", data.NukiId, tags, fields, data.Timestamp)
+	case ConnectionEvent:
+		if event.Event != EventLockConnected && event.Event != EventLockUnreachable {
+			return
+		}
+		fields := map[string]interface{}{
+			"success": event.Event == EventLockConnected,
+		}
+		if data.Error != "" {
+			fields["error"] = data.Error
+		}
+		e.add("ble_connection", data.NukiId, nil, fields, data.Timestamp)
+	}
+}
+
+func (e *influxExporter) add(measurement string, nukiId uint32, tags map[string]string, fields map[string]interface{}, t time.Time) {
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	if nukiId != 0 {
+		tags["nukiId"] = fmt.Sprint(nukiId)
+		if l, err := e.bridge.GetLock(uint(nukiId)); err == nil && l.lastConfig.Name != "" {
+			tags["name"] = l.lastConfig.Name
+		}
+	}
+	point, err := client.NewPoint(measurement, tags, fields, t)
+	if err != nil {
+		log.WithError(err).WithField("measurement", measurement).Warnln("Failed to create influxdb point")
+		return
+	}
+	e.points = append(e.points, point)
+}
+
+func (e *influxExporter) flush() {
+	if len(e.points) == 0 {
+		return
+	}
+	batch, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:        e.options.Database,
+		RetentionPolicy: e.options.RetentionPolicy,
+		// Events of the same lock and second must not overwrite each other
+		Precision: "ms",
+	})
+	if err != nil {
+		log.WithError(err).Errorln("Failed to create influxdb batch")
+		return
+	}
+	batch.AddPoints(e.points)
+	if err := e.client.Write(batch); err != nil {
+		// Points are dropped, the bridge must not run out of memory while influxdb is down
+		log.WithError(err).WithField("points", len(e.points)).Errorln("Failed to write points to influxdb")
+	}
+	e.points = e.points[:0]
+}
@@ -0,0 +1,107 @@
+package nukibridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+type influxTestPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+}
+
+func newInfluxTestExporter(t *testing.T, url string) *influxExporter {
+	b := &bridge{Locks: map[uint]*lock{42: {lastConfig: models.Config{NukiID: 42, Name: "Front door"}}}}
+	e, err := newInfluxExporter(b, InfluxOptions{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func influxTestState(nukiId uint32, state enums.LockState, critical bool) Event {
+	return Event{Event: EventState, Data: StateEvent{
+		LockEvent:       newLockEvent(nukiId),
+		KeyturnerStates: models.KeyturnerStates{LockState: state, Trigger: enums.TriggerManual, CriticalBatteryState: critical},
+	}}
+}
+
+func TestInfluxRecord(t *testing.T) {
+	motorBlocked := enums.CompletionStatusMotorBlocked
+	lockTags := func(tags map[string]string) map[string]string {
+		tags["nukiId"] = "42"
+		tags["name"] = "Front door"
+		return tags
+	}
+	tests := []struct {
+		name   string
+		events []Event
+		want   []influxTestPoint
+	}{
+		{"state", []Event{influxTestState(42, enums.LockStateLocked, false)}, []influxTestPoint{
+			{"battery", lockTags(map[string]string{}), map[string]interface{}{"critical": false}},
+			{"lock_state", lockTags(map[string]string{"state": enums.LockStateLocked.String()}), map[string]interface{}{"state": int64(1), "previous": int64(0), "trigger": int64(1)}},
+		}},
+		{"unchanged state", []Event{influxTestState(42, enums.LockStateLocked, false), influxTestState(42, enums.LockStateLocked, true)}, []influxTestPoint{
+			{"battery", lockTags(map[string]string{}), map[string]interface{}{"critical": false}},
+			{"lock_state", lockTags(map[string]string{"state": enums.LockStateLocked.String()}), map[string]interface{}{"state": int64(1), "previous": int64(0), "trigger": int64(1)}},
+			{"battery", lockTags(map[string]string{}), map[string]interface{}{"critical": true}},
+		}},
+		{"unknown lock", []Event{influxTestState(7, enums.LockStateUnlocked, false)}, []influxTestPoint{
+			{"battery", map[string]string{"nukiId": "7"}, map[string]interface{}{"critical": false}},
+			{"lock_state", map[string]string{"nukiId": "7", "state": enums.LockStateUnlocked.String()}, map[string]interface{}{"state": int64(3), "previous": int64(0), "trigger": int64(1)}},
+		}},
+		{"door sensor", []Event{{Event: EventDoorOpened, Data: DoorSensorEvent{LockEvent: newLockEvent(42), DoorSensorState: enums.DoorSensorStateDoorOpened, DoorSensorStateName: "DoorOpened"}}}, []influxTestPoint{
+			{"door_sensor", lockTags(map[string]string{"state": "DoorOpened"}), map[string]interface{}{"state": int64(3), "open": true}},
+		}},
+		{"requested action", []Event{{Event: EventLockActionRequested, Data: LockActionEvent{LockEvent: newLockEvent(42), ActionName: "Unlock"}}}, nil},
+		{"completed action", []Event{{Event: EventLockActionCompleted, Data: LockActionEvent{LockEvent: newLockEvent(42), ActionName: "Unlock", Duration: 1.5}}}, []influxTestPoint{
+			{"lock_action", lockTags(map[string]string{"action": "Unlock"}), map[string]interface{}{"duration": 1.5, "success": true}},
+		}},
+		{"failed action", []Event{{Event: EventLockActionFailed, Data: LockActionEvent{LockEvent: newLockEvent(42), ActionName: "Lock", CompletionStatus: &motorBlocked, CompletionStatusName: "MotorBlocked", Duration: 2, Error: "Motor blocked"}}}, []influxTestPoint{
+			{"lock_action", lockTags(map[string]string{"action": "Lock", "status": "MotorBlocked"}), map[string]interface{}{"duration": 2.0, "success": false, "completion_status": int64(1), "error": "Motor blocked"}},
+		}},
+		{"connected", []Event{{Event: EventLockConnected, Data: ConnectionEvent{LockEvent: newLockEvent(42)}}}, []influxTestPoint{
+			{"ble_connection", lockTags(map[string]string{}), map[string]interface{}{"success": true}},
+		}},
+		{"unreachable", []Event{{Event: EventLockUnreachable, Data: ConnectionEvent{LockEvent: newLockEvent(42), Error: "timeout"}}}, []influxTestPoint{
+			{"ble_connection", lockTags(map[string]string{}), map[string]interface{}{"success": false, "error": "timeout"}},
+		}},
+		{"disconnected", []Event{{Event: EventLockDisconnected, Data: ConnectionEvent{LockEvent: newLockEvent(42)}}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newInfluxTestExporter(t, "http://localhost:8086")
+			for _, event := range tt.events {
+				e.record(event)
+			}
+			if len(e.points) != len(tt.want) {
+				t.Fatalf("%d points, want %d: %v", len(e.points), len(tt.want), e.points)
+			}
+			for i, want := range tt.want {
+				point := e.points[i]
+				fields, err := point.Fields()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if point.Name() != want.measurement || !reflect.DeepEqual(point.Tags(), want.tags) || !reflect.DeepEqual(fields, want.fields) {
+					t.Errorf("point %d is %s %v %v, want %s %v %v", i, point.Name(), point.Tags(), fields, want.measurement, want.tags, want.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestInfluxFlushDropsPointsOnError(t *testing.T) {
+	// Nothing listens on the port
+	e := newInfluxTestExporter(t, "http://127.0.0.1:1")
+	e.record(influxTestState(42, enums.LockStateLocked, false))
+	e.flush()
+	if len(e.points) != 0 {
+		t.Errorf("%d points kept after a failed write", len(e.points))
+	}
+}
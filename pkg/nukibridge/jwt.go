@@ -0,0 +1,143 @@
+package nukibridge
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	jwtIssuer          = "nukibridge"
+	jwtDefaultValidity = 24 * time.Hour
+)
+
+// tokenClaims limit a json web token to scopes, locks and lock actions.
+// The validity window is given by the registered claims nbf and exp.
+type tokenClaims struct {
+	Scopes  []string `json:"scopes"`
+	Locks   []uint32 `json:"locks,omitempty"`
+	Actions []string `json:"actions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func newJWTKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// isJWT distinguishes json web tokens from plain tokens and api keys
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// issueToken signs a token for the request, the validity defaults to a day
+func (b *bridge) issueToken(req api.TokenRequest) (api.Token, error) {
+	if err := validateScopes(req.Scopes); err != nil {
+		return api.Token{}, err
+	}
+	for _, action := range req.Actions {
+		if _, err := parseLockAction(action); err != nil {
+			return api.Token{}, err
+		}
+	}
+	now := time.Now()
+	notBefore := now
+	if req.NotBefore != "" {
+		t, err := time.Parse(time.RFC3339, req.NotBefore)
+		if err != nil {
+			return api.Token{}, err
+		}
+		notBefore = t
+	}
+	expiresAt := notBefore.Add(jwtDefaultValidity)
+	if req.ValidFor != "" {
+		d, err := time.ParseDuration(req.ValidFor)
+		if err != nil {
+			return api.Token{}, err
+		}
+		expiresAt = notBefore.Add(d)
+	}
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return api.Token{}, err
+		}
+		expiresAt = t
+	}
+	if !expiresAt.After(notBefore) {
+		return api.Token{}, errors.New("Token expires before it is valid")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return api.Token{}, err
+	}
+	locks := make([]uint32, 0, len(req.NukiIds))
+	for _, nukiId := range req.NukiIds {
+		locks = append(locks, uint32(nukiId))
+	}
+	claims := tokenClaims{
+		Scopes:  req.Scopes,
+		Locks:   locks,
+		Actions: req.Actions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   req.Name,
+			ID:        id,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(notBefore),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(b.jwtKey)
+	if err != nil {
+		return api.Token{}, err
+	}
+	log.WithField("subject", req.Name).WithField("id", id).WithField("expiresAt", expiresAt).Infoln("Token issued")
+	return api.Token{
+		Id:        id,
+		Token:     signed,
+		NotBefore: notBefore.Format(time.RFC3339),
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// verifyToken checks signature and validity window of the token
+func (b *bridge) verifyToken(token string) (*identity, error) {
+	claims := &tokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if _, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return b.jwtKey, nil
+	}); err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("Token without expiry")
+	}
+	if claims.Issuer != jwtIssuer {
+		return nil, fmt.Errorf("Unknown token issuer %s", claims.Issuer)
+	}
+	actions := make([]enums.LockAction, 0, len(claims.Actions))
+	for _, name := range claims.Actions {
+		action, err := parseLockAction(name)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return &identity{
+		Name:    "jwt:" + claims.Subject + ":" + claims.ID,
+		Scopes:  claims.Scopes,
+		NukiIds: claims.Locks,
+		Actions: actions,
+	}, nil
+}
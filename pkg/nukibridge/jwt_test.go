@@ -0,0 +1,153 @@
+package nukibridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+)
+
+func newJWTTestBridge(t *testing.T) *bridge {
+	key, err := newJWTKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &bridge{jwtKey: key}
+}
+
+func TestIssueToken(t *testing.T) {
+	b := newJWTTestBridge(t)
+	token, err := b.issueToken(api.TokenRequest{
+		Name:    "alice",
+		Scopes:  []string{ScopeUnlock},
+		NukiIds: []int32{1, 2},
+		Actions: []string{"unlock", "lock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := b.verifyToken(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Name != "jwt:alice:"+token.Id {
+		t.Errorf("name %s, want jwt:alice:%s", id.Name, token.Id)
+	}
+	if len(id.Scopes) != 1 || id.Scopes[0] != ScopeUnlock {
+		t.Errorf("scopes %v, want [%s]", id.Scopes, ScopeUnlock)
+	}
+	if len(id.NukiIds) != 2 || id.NukiIds[0] != 1 || id.NukiIds[1] != 2 {
+		t.Errorf("locks %v, want [1 2]", id.NukiIds)
+	}
+	if len(id.Actions) != 2 || id.Actions[0] != enums.LockActionUnlock || id.Actions[1] != enums.LockActionLock {
+		t.Errorf("actions %v, want [Unlock Lock]", id.Actions)
+	}
+	notBefore, _ := time.Parse(time.RFC3339, token.NotBefore)
+	expiresAt, _ := time.Parse(time.RFC3339, token.ExpiresAt)
+	if validity := expiresAt.Sub(notBefore); validity != jwtDefaultValidity {
+		t.Errorf("validity %v, want %v", validity, jwtDefaultValidity)
+	}
+}
+
+func TestIssueTokenValidity(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		name     string
+		req      api.TokenRequest
+		validity time.Duration
+		wantErr  bool
+	}{
+		{"valid for", api.TokenRequest{ValidFor: "2h"}, 2 * time.Hour, false},
+		{"expires at", api.TokenRequest{NotBefore: now.Format(time.RFC3339), ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)}, time.Hour, false},
+		{"expires at wins", api.TokenRequest{NotBefore: now.Format(time.RFC3339), ValidFor: "2h", ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)}, time.Hour, false},
+		{"expires before valid", api.TokenRequest{NotBefore: now.Format(time.RFC3339), ExpiresAt: now.Add(-time.Hour).Format(time.RFC3339)}, 0, true},
+		{"negative duration", api.TokenRequest{ValidFor: "-1h"}, 0, true},
+		{"invalid duration", api.TokenRequest{ValidFor: "a day"}, 0, true},
+		{"invalid time", api.TokenRequest{NotBefore: "tomorrow"}, 0, true},
+		{"unknown scope", api.TokenRequest{Scopes: []string{"open"}}, 0, true},
+		{"unknown action", api.TokenRequest{Actions: []string{"open"}}, 0, true},
+	}
+	b := newJWTTestBridge(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.req.Scopes == nil {
+				tt.req.Scopes = []string{ScopeRead}
+			}
+			token, err := b.issueToken(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			notBefore, _ := time.Parse(time.RFC3339, token.NotBefore)
+			expiresAt, _ := time.Parse(time.RFC3339, token.ExpiresAt)
+			if validity := expiresAt.Sub(notBefore); validity != tt.validity {
+				t.Errorf("validity %v, want %v", validity, tt.validity)
+			}
+		})
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	b := newJWTTestBridge(t)
+	now := time.Now()
+	sign := func(claims tokenClaims, method jwt.SigningMethod, key interface{}) string {
+		signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := func() tokenClaims {
+		return tokenClaims{
+			Scopes: []string{ScopeRead},
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    jwtIssuer,
+				NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}
+	}
+	expired := valid()
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	notYetValid := valid()
+	notYetValid.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+	notYetValid.ExpiresAt = jwt.NewNumericDate(now.Add(2 * time.Hour))
+	withoutExpiry := valid()
+	withoutExpiry.ExpiresAt = nil
+	otherIssuer := valid()
+	otherIssuer.Issuer = "other"
+	unknownAction := valid()
+	unknownAction.Actions = []string{"open"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", sign(valid(), jwt.SigningMethodHS256, b.jwtKey), false},
+		{"expired", sign(expired, jwt.SigningMethodHS256, b.jwtKey), true},
+		{"not yet valid", sign(notYetValid, jwt.SigningMethodHS256, b.jwtKey), true},
+		{"without expiry", sign(withoutExpiry, jwt.SigningMethodHS256, b.jwtKey), true},
+		{"other issuer", sign(otherIssuer, jwt.SigningMethodHS256, b.jwtKey), true},
+		{"unknown action", sign(unknownAction, jwt.SigningMethodHS256, b.jwtKey), true},
+		{"other key", sign(valid(), jwt.SigningMethodHS256, []byte("other key")), true},
+		{"other algorithm", sign(valid(), jwt.SigningMethodHS512, b.jwtKey), true},
+		{"unsigned", sign(valid(), jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), true},
+		{"garbage", "a.b.c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !isJWT(tt.token) {
+				t.Fatalf("%s is not recognized as json web token", tt.token)
+			}
+			_, err := b.verifyToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
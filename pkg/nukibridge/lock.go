@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"crypto/hmac"
 	"crypto/rand"
@@ -29,6 +31,17 @@ var (
 	KeyturnerServiceUSDIOCharacteristicUUID          = ble.MustParse("a92ee202-5501-11e4-916c-0800200c9a66")
 	NukiRequestDataCmd                        uint16 = 0x0001
 	NukiPublicKeyReqCmd                       uint16 = 0x0003
+
+	errTimeout = errors.New("Timeout")
+)
+
+const (
+	StatusComplete byte = 0x00
+	StatusAccepted byte = 0x01
+
+	lockActionTimeout = 30 * time.Second
+	// nameSuffixLength is the number of bytes of the name suffix the lock logs for a lock action
+	nameSuffixLength = 20
 )
 
 type Lock interface {
@@ -39,10 +52,24 @@ type Lock interface {
 }
 
 type lock struct {
+	nukiID          uint32
 	address         string
 	authorizationID uint32
 	adminPIN        uint
 
+	publish      func(event Event)
+	lastLogIndex uint32
+
+	// Command sent last and when, for measuring the round-trip time
+	sentCommand Command
+	sentAt      time.Time
+
+	// Reported by the health endpoints, written by the advertisement monitor and the bluetooth path
+	healthMutex       sync.Mutex
+	lastAdvertisement time.Time
+	lastCommand       Command
+	lastCommandTime   time.Time
+
 	lastConfig models.Config
 	lastState  models.KeyturnerStates
 
@@ -62,6 +89,28 @@ type lock struct {
 	chKeyturnerUSDIO       chan []byte
 }
 
+// advertised records the time of an advertisement of the lock
+func (l *lock) advertised() {
+	l.healthMutex.Lock()
+	defer l.healthMutex.Unlock()
+	l.lastAdvertisement = time.Now()
+}
+
+// commandAnswered records the command the lock answered last
+func (l *lock) commandAnswered(command Command) {
+	l.healthMutex.Lock()
+	defer l.healthMutex.Unlock()
+	l.lastCommand = command
+	l.lastCommandTime = time.Now()
+}
+
+// activity returns the time of the last advertisement and the command answered last with its time
+func (l *lock) activity() (time.Time, Command, time.Time) {
+	l.healthMutex.Lock()
+	defer l.healthMutex.Unlock()
+	return l.lastAdvertisement, l.lastCommand, l.lastCommandTime
+}
+
 func NewLock(address string, authorizationID uint32, publicKey []byte, adminPIN uint) *lock {
 	return &lock{
 		address:         address,
@@ -78,24 +127,50 @@ func (l *lock) Connect() error {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	l.cancelConnection = cancel
+	start := time.Now()
 	client, err := ble.Connect(ctx, filter)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to connect")
+		observeConnect(l.nukiID, start, err)
+		l.notify(EventLockUnreachable, ConnectionEvent{
+			LockEvent: newLockEvent(l.nukiID),
+			Address:   l.address,
+			Error:     err.Error(),
+		})
 		return err
 	}
 	l.connected = true
 	l.client = client
 	client.ExchangeMTU(150)
+	l.notify(EventLockConnected, ConnectionEvent{
+		LockEvent: newLockEvent(l.nukiID),
+		Address:   l.address,
+	})
 
 	go func() {
 		<-client.Disconnected()
 		log.WithField("lock", l.address).Infoln("Lock disconnected")
 		l.connected = false
+		l.notify(EventLockDisconnected, ConnectionEvent{
+			LockEvent: newLockEvent(l.nukiID),
+			Address:   l.address,
+		})
 	}()
 	l.discover()
+	observeConnect(l.nukiID, start, nil)
 	return nil
 }
 
+func (l *lock) notify(name string, data interface{}) {
+	if l.publish == nil {
+		return
+	}
+	l.publish(Event{
+		Event: name,
+		Data:  data,
+	})
+}
+
 func (l *lock) Disconnect() {
 	if l.cancelConnection != nil {
 		l.cancelConnection()
@@ -177,9 +252,19 @@ func (l *lock) discover() {
 }
 
 func (l *lock) Authenticate(publickey [32]byte, privateKey [32]byte) error {
+	return l.Pair(publickey, privateKey, defaultAuthorizationName, nil)
+}
+
+// Pair authorizes the keys with the lock under the name, step is called before each
+// step of the pairing if set. Failures are returned with the step they occurred in.
+func (l *lock) Pair(publickey [32]byte, privateKey [32]byte, name string, step func(string)) error {
+	if step == nil {
+		step = func(string) {}
+	}
 	if !l.connected {
+		step(pairingStepConnecting)
 		if err := l.Connect(); err != nil {
-			return err
+			return &pairingError{pairingStepConnecting, err}
 		}
 	}
 	l.bridgePrivateKey = privateKey
@@ -187,35 +272,43 @@ func (l *lock) Authenticate(publickey [32]byte, privateKey [32]byte) error {
 
 	log.WithField("lock", l.address).Infoln("Authenticating")
 
+	step(pairingStepPublicKeyExchange)
 	key, err := l.RequestPublicKey()
+	if err == nil && len(key) < 32 {
+		err = errors.New("Received short public key")
+	}
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate bridge")
-		return err
+		return &pairingError{pairingStepPublicKeyExchange, err}
 	}
-	copy(l.peersPublicKey[:], key[:32])
+	// New locks have no public key yet, copying into it would drop the key
+	l.peersPublicKey = append([]byte(nil), key[:32]...)
 
 	challenge, err := l.SendPublicKey()
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate bridge")
-		return err
+		return &pairingError{pairingStepPublicKeyExchange, err}
 	}
 
+	step(pairingStepChallenge)
 	challenge, err = l.SendAuthorizationAuthenticator(challenge)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate bridge")
-		return err
+		return &pairingError{pairingStepChallenge, err}
 	}
 
-	resp, err := l.SendAuthorizationData(0x01, 50, "GoBridge", challenge)
+	step(pairingStepAuthorizationData)
+	resp, err := l.SendAuthorizationData(0x01, 50, name, challenge)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate bridge")
-		return err
+		return &pairingError{pairingStepAuthorizationData, err}
 	}
 
+	step(pairingStepIDConfirmation)
 	err = l.SendAuthorizationIDConfirmation(resp.AuthorizationID, resp.Nonce)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate bridge")
-		return err
+		return &pairingError{pairingStepIDConfirmation, err}
 	}
 	return nil
 }
@@ -245,7 +338,7 @@ func (l *lock) receive(ch chan []byte) ([]byte, error) {
 			timer.Reset(500 * time.Millisecond)
 		case <-timer.C:
 			if len(received) <= 0 {
-				return nil, errors.New("Timeout")
+				return nil, errTimeout
 			}
 			return received, nil
 		}
@@ -264,6 +357,9 @@ func (l *lock) decodeUnencrypted(received []byte) (*PDATA, error) {
 		buf := bytes.NewBuffer(d.Payload)
 		binary.Read(buf, binary.LittleEndian, &code)
 		binary.Read(buf, binary.LittleEndian, &cmd)
+		if reason, ok := pairingErrors[code]; ok {
+			return nil, fmt.Errorf("Lock reported error %x for command %x: %s", code, cmd, reason)
+		}
 		return nil, fmt.Errorf("Lock reported error %x for command %x", code, cmd)
 	}
 	return d, nil
@@ -383,7 +479,10 @@ func (l *lock) SendAuthorizationData(idType byte, bridgeID uint32, name string,
 		log.WithError(err).Errorln("Failed to send authorization request")
 		return nil, err
 	}
-	_, err := bodyBuf.Write([]byte(name)[:32])
+	// The name is padded with zeros to 32 bytes
+	var encodedName [32]byte
+	copy(encodedName[:], name)
+	_, err := bodyBuf.Write(encodedName[:])
 	if err != nil {
 		log.WithError(err).Errorln("Failed to send authorization request")
 		return nil, err
@@ -575,25 +674,93 @@ func (l *lock) RequestLogEntries(offset uint32, count uint16) (entries []models.
 	return entries, nil
 }
 
-func (l *lock) LockAction(action enums.LockAction, description string) (b []byte, err error) {
+// RemoveUserAuthorization removes the authorization from the lock, which needs the admin PIN
+func (l *lock) RemoveUserAuthorization(authorizationID uint32) error {
+	if !l.connected {
+		if err := l.Connect(); err != nil {
+			return err
+		}
+	}
+	log.WithField("lock", l.address).WithField("authorizationId", authorizationID).Infoln("Remove user authorization")
+
+	if err := l.writeEncryptedCmdRequest(l.keyturnerUSDIO, uint16(CmdChallenge)); err != nil {
+		return err
+	}
+	messages, err := l.receiveEncrypted(l.chKeyturnerUSDIO)
+	if err != nil {
+		return err
+	}
+	if messages[0].CommandID != CmdChallenge {
+		err := errors.New("Received wrong command")
+		log.WithError(err).WithField("expected", CmdChallenge).WithField("actual", messages[0].CommandID).Errorln("Failed to remove user authorization")
+		return err
+	}
+	req := models.RemoveUserAuthorization{
+		AuthorizationID: authorizationID,
+		PIN:             uint16(l.adminPIN),
+	}
+	copy(req.Nonce[:], messages[0].Payload)
+	encoded, err := models.EncodeRemoveUserAuthorization(req)
+	if err != nil {
+		return err
+	}
+	if err := l.writeEncryptedMessage(l.keyturnerUSDIO, uint16(CmdRemoveUserAuthorization), encoded); err != nil {
+		return err
+	}
+	messages, err = l.receiveEncrypted(l.chKeyturnerUSDIO)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		switch message.CommandID {
+		case CmdErrorReport:
+			err := fmt.Errorf("Lock reported error %x", message.Payload)
+			log.WithError(err).Errorln("Failed to remove user authorization")
+			return err
+		case CmdStatus:
+			if len(message.Payload) > 0 && message.Payload[0] == StatusComplete {
+				log.WithField("lock", l.address).WithField("authorizationId", authorizationID).Infoln("User authorization removed")
+				return nil
+			}
+		}
+	}
+	err = errors.New("Received no completion")
+	log.WithError(err).WithField("lock", l.address).Errorln("Failed to remove user authorization")
+	return err
+}
+
+// truncateUTF8 cuts the string to at most max bytes without splitting a character
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// LockAction triggers the action and waits until the lock reports its completion.
+// accepted is called as soon as the lock accepted the action.
+func (l *lock) LockAction(action enums.LockAction, description string, accepted func()) (state models.KeyturnerStates, err error) {
 	if !l.connected {
 		if err := l.Connect(); err != nil {
-			return b, err
+			return state, err
 		}
 	}
 	log.WithField("lock", l.address).WithField("action", action.String()).Infoln("Lock Action triggered")
 
 	if err := l.writeEncryptedCmdRequest(l.keyturnerUSDIO, uint16(CmdChallenge)); err != nil {
-		return b, err
+		return state, err
 	}
 	messages, err := l.receiveEncrypted(l.chKeyturnerUSDIO)
 	if err != nil {
-		return b, err
+		return state, err
 	}
 	if messages[0].CommandID != CmdChallenge {
 		err := errors.New("Received wrong command")
 		log.WithError(err).WithField("expected", CmdChallenge).WithField("actual", messages[0].CommandID).Errorln("Failed lock action")
-		return nil, err
+		return state, err
 	}
 
 	req := models.RequestLockAction{
@@ -601,21 +768,59 @@ func (l *lock) LockAction(action enums.LockAction, description string) (b []byte
 		AppID:      50,
 		Flags:      0,
 	}
-	copy(req.NameSuffix[:], description)
+	copy(req.NameSuffix[:], truncateUTF8(description, nameSuffixLength))
 	copy(req.Nonce[:], messages[0].Payload)
 	encoded, err := models.EncodeRequestLockAction(req)
 	if err != nil {
-		return nil, err
+		return state, err
 	}
 	if err := l.writeEncryptedMessage(l.keyturnerUSDIO, uint16(CmdLockAction), encoded); err != nil {
-		return nil, err
-	}
-	messages, err = l.receiveEncrypted(l.chKeyturnerUSDIO)
-	if err != nil {
-		return nil, err
+		return state, err
 	}
 
-	return nil, nil
+	// The lock reports ACCEPTED, followed by state updates while the motor is
+	// running and COMPLETE at last.
+	deadline := time.Now().Add(lockActionTimeout)
+	for time.Now().Before(deadline) {
+		messages, err = l.receiveEncrypted(l.chKeyturnerUSDIO)
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return state, err
+		}
+		for _, message := range messages {
+			switch message.CommandID {
+			case CmdErrorReport:
+				err := fmt.Errorf("Lock reported error %x", message.Payload)
+				log.WithError(err).Errorln("Failed lock action")
+				return state, err
+			case CmdKeyturnerStates:
+				state, err = models.DecodeKeyturnerStates(message.Payload)
+				if err != nil {
+					return state, err
+				}
+				l.lastState = state
+			case CmdStatus:
+				if len(message.Payload) == 0 {
+					continue
+				}
+				switch message.Payload[0] {
+				case StatusAccepted:
+					log.WithField("lock", l.address).WithField("action", action.String()).Infoln("Lock Action accepted")
+					if accepted != nil {
+						accepted()
+					}
+				case StatusComplete:
+					log.WithField("lock", l.address).WithField("action", action.String()).Infoln("Lock Action completed")
+					return l.lastState, nil
+				}
+			}
+		}
+	}
+	err = errors.New("Lock action timed out")
+	log.WithError(err).WithField("lock", l.address).Errorln("Failed lock action")
+	return state, err
 }
 
 func (l *lock) RequestConfig() (config models.Config, err error) {
@@ -0,0 +1,143 @@
+package nukibridge
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics of the bridge, exposed by the /metrics endpoint. The api request
+// metrics are collected by the api.Logger middleware.
+var (
+	bleConnectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nukibridge",
+		Name:      "ble_connect_duration_seconds",
+		Help:      "Duration of successful bluetooth connections to a lock including service discovery.",
+		Buckets:   []float64{0.5, 1, 2, 3, 5, 8, 13, 21},
+	}, []string{"nuki_id"})
+	bleConnectFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "ble_connect_failures_total",
+		Help:      "Number of failed bluetooth connections to a lock.",
+	}, []string{"nuki_id"})
+	lockReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "lock_reachable",
+		Help:      "1 if the last connection to the lock succeeded, 0 if it failed.",
+	}, []string{"nuki_id"})
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nukibridge",
+		Name:      "command_duration_seconds",
+		Help:      "Round-trip time between sending a command to a lock and receiving its response.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+	}, []string{"command"})
+	deviceLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nukibridge",
+		Name:      "device_lock_wait_seconds",
+		Help:      "Time spent waiting for exclusive access to the bluetooth device.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60},
+	})
+	advertisements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "advertisements_total",
+		Help:      "Number of received advertisements, skipped while a previous one was still handled.",
+	}, []string{"result"})
+	lastAdvertisement = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "lock_last_advertisement_timestamp_seconds",
+		Help:      "Unix time of the last beacon advertisement of the lock.",
+	}, []string{"nuki_id"})
+	authFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "auth_failures_total",
+		Help:      "Number of failed authentications, including unknown guest links.",
+	})
+	authBans = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "auth_bans_total",
+		Help:      "Number of source addresses banned after repeated authentication failures.",
+	})
+	lockActionsLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "lock_actions_limited_total",
+		Help:      "Number of lock actions rejected by the rate limit.",
+	})
+	sseClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "sse_clients",
+		Help:      "Number of connected server-sent event clients.",
+	})
+	callbackDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nukibridge",
+		Name:      "callback_deliveries_total",
+		Help:      "Number of callback deliveries by result.",
+	}, []string{"result"})
+	lockInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "lock_info",
+		Help:      "Name of the lock, always 1.",
+	}, []string{"nuki_id", "name"})
+	lockState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "lock_state",
+		Help:      "Lock state as reported by the lock, e.g. 1 locked, 3 unlocked, 254 motor blocked.",
+	}, []string{"nuki_id"})
+	doorSensorState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "door_sensor_state",
+		Help:      "Door sensor state as reported by the lock, e.g. 2 door closed, 3 door opened.",
+	}, []string{"nuki_id"})
+	batteryCritical = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nukibridge",
+		Name:      "battery_critical",
+		Help:      "1 if the battery of the lock is critical.",
+	}, []string{"nuki_id"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		bleConnectDuration,
+		bleConnectFailures,
+		lockReachable,
+		commandDuration,
+		deviceLockWait,
+		advertisements,
+		lastAdvertisement,
+		authFailuresTotal,
+		authBans,
+		lockActionsLimited,
+		sseClients,
+		callbackDeliveries,
+		lockInfo,
+		lockState,
+		doorSensorState,
+		batteryCritical,
+	)
+}
+
+func observeConnect(nukiId uint32, start time.Time, err error) {
+	id := fmt.Sprint(nukiId)
+	if err != nil {
+		bleConnectFailures.WithLabelValues(id).Inc()
+		lockReachable.WithLabelValues(id).Set(0)
+		return
+	}
+	bleConnectDuration.WithLabelValues(id).Observe(time.Since(start).Seconds())
+	lockReachable.WithLabelValues(id).Set(1)
+}
+
+func observeState(l *lock, state models.KeyturnerStates) {
+	id := fmt.Sprint(l.nukiID)
+	if l.lastConfig.Name != "" {
+		lockInfo.WithLabelValues(id, l.lastConfig.Name).Set(1)
+	}
+	lockState.WithLabelValues(id).Set(float64(state.LockState))
+	doorSensorState.WithLabelValues(id).Set(float64(state.DoorSensorState))
+	critical := 0.0
+	if state.CriticalBatteryState {
+		critical = 1
+	}
+	batteryCritical.WithLabelValues(id).Set(critical)
+}
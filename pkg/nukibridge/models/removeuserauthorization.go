@@ -0,0 +1,31 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type RemoveUserAuthorization struct {
+	AuthorizationID uint32
+	Nonce           [32]byte
+	PIN             uint16
+}
+
+func EncodeRemoveUserAuthorization(r RemoveUserAuthorization) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	if err := binary.Write(payload, binary.LittleEndian, r.AuthorizationID); err != nil {
+		log.WithError(err).Errorln("Failed to encode remove user authorization")
+		return nil, err
+	}
+	if _, err := payload.Write(r.Nonce[:]); err != nil {
+		log.WithError(err).Errorln("Failed to encode remove user authorization")
+		return nil, err
+	}
+	if err := binary.Write(payload, binary.LittleEndian, r.PIN); err != nil {
+		log.WithError(err).Errorln("Failed to encode remove user authorization")
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
@@ -0,0 +1,306 @@
+package nukibridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mqttBuffer       = 64
+	mqttQos     byte = 1
+	mqttOnline       = "online"
+	mqttOffline      = "offline"
+	mqttTimeout      = 10 * time.Second
+)
+
+// MQTTOptions configures the connection to the mqtt broker
+type MQTTOptions struct {
+	// Broker url, e.g. tcp://localhost:1883 or ssl://broker:8883. MQTT is disabled if empty.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// BaseTopic is the prefix of all topics
+	BaseTopic string
+	// CAFile, CertFile and KeyFile are PEM files for TLS connections
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+	// Discovery announces all locks to home assistant below the DiscoveryPrefix
+	Discovery       bool
+	DiscoveryPrefix string
+}
+
+// MQTTCommand is the json payload of the command topic. A plain action name
+// or number is accepted as well.
+type MQTTCommand struct {
+	Action string `json:"action"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+type mqttPublisher struct {
+	bridge  *bridge
+	options MQTTOptions
+	client  mqtt.Client
+}
+
+func newMQTTPublisher(b *bridge, options MQTTOptions) (*mqttPublisher, error) {
+	if options.BaseTopic == "" {
+		options.BaseTopic = "nukibridge"
+	}
+	options.BaseTopic = strings.TrimSuffix(options.BaseTopic, "/")
+	if options.DiscoveryPrefix == "" {
+		options.DiscoveryPrefix = "homeassistant"
+	}
+	if options.ClientID == "" {
+		options.ClientID = "nukibridge"
+	}
+	m := &mqttPublisher{
+		bridge:  b,
+		options: options,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(options.Broker).
+		SetClientID(options.ClientID).
+		SetUsername(options.Username).
+		SetPassword(options.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(m.topic("status"), mqttOffline, mqttQos, true).
+		SetOnConnectHandler(m.onConnect).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.WithError(err).Warnln("MQTT connection lost")
+		})
+	if options.CAFile != "" || options.CertFile != "" || options.Insecure {
+		tlsConfig, err := options.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	m.client = mqtt.NewClient(opts)
+	return m, nil
+}
+
+func (o MQTTOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.Insecure,
+	}
+	if o.CAFile != "" {
+		ca, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("No certificates found in mqtt ca file")
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (m *mqttPublisher) start() {
+	log.WithField("broker", m.options.Broker).Infoln("Connecting to mqtt broker")
+	// With connect retry the token only completes once connected
+	m.client.Connect()
+	go m.run()
+}
+
+func (m *mqttPublisher) topic(parts ...string) string {
+	return strings.Join(append([]string{m.options.BaseTopic}, parts...), "/")
+}
+
+func (m *mqttPublisher) lockTopic(nukiId uint32, parts ...string) string {
+	return m.topic(append([]string{fmt.Sprint(nukiId)}, parts...)...)
+}
+
+func (m *mqttPublisher) publish(topic string, retained bool, payload interface{}) {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	default:
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			log.WithError(err).WithField("topic", topic).Warnln("Failed to encode mqtt message")
+			return
+		}
+		data = encoded
+	}
+	token := m.client.Publish(topic, mqttQos, retained, data)
+	go func() {
+		if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+			log.WithError(token.Error()).WithField("topic", topic).Warnln("Failed to publish mqtt message")
+		}
+	}()
+}
+
+// onConnect is called on every (re)connect to restore availability, retained topics and subscriptions
+func (m *mqttPublisher) onConnect(c mqtt.Client) {
+	log.WithField("broker", m.options.Broker).Infoln("Connected to mqtt broker")
+	m.publish(m.topic("status"), true, mqttOnline)
+	for id, l := range m.bridge.GetLocks() {
+		m.publishLock(uint32(id), l)
+	}
+	token := c.Subscribe(m.topic("+", "command"), mqttQos, m.onCommand)
+	go func() {
+		if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+			log.WithError(token.Error()).Errorln("Failed to subscribe mqtt command topic")
+		}
+	}()
+}
+
+func (m *mqttPublisher) publishLock(nukiId uint32, l *lock) {
+	if !l.lastState.CurrentTime.IsZero() {
+		m.publishState(nukiId, l.lastState)
+	}
+	if l.lastConfig.NukiID == nukiId {
+		m.publish(m.lockTopic(nukiId, "config"), true, toLockConfig(fmt.Sprint(nukiId), l.lastConfig))
+	}
+	m.publishDiscovery(nukiId, l)
+}
+
+func (m *mqttPublisher) publishState(nukiId uint32, state models.KeyturnerStates) {
+	m.publish(m.lockTopic(nukiId, "state"), true, state)
+	m.publish(m.lockTopic(nukiId, "battery"), true, BatteryEvent{
+		LockEvent:       newLockEvent(nukiId),
+		BatteryCritical: state.CriticalBatteryState,
+	})
+}
+
+// run forwards all bridge events to the broker
+func (m *mqttPublisher) run() {
+	for {
+		sub := m.bridge.service.events.subscribe(mqttBuffer)
+		m.forward(sub)
+		log.Warnln("MQTT publisher fell behind, resubscribing")
+		if m.client.IsConnected() {
+			m.onConnect(m.client)
+		}
+	}
+}
+
+func (m *mqttPublisher) forward(sub *subscription) {
+	for {
+		select {
+		case <-sub.dropped:
+			return
+		case event := <-sub.events:
+			var nukiId uint32
+			if e, ok := event.Data.(lockEventer); ok {
+				nukiId = e.lockEvent().NukiId
+			}
+			switch data := event.Data.(type) {
+			case StateEvent:
+				m.publishState(nukiId, data.KeyturnerStates)
+			case ConfigChangedEvent:
+				if data.Config != nil {
+					m.publish(m.lockTopic(nukiId, "config"), true, data.Config)
+				}
+				if l, err := m.bridge.GetLock(uint(nukiId)); err == nil {
+					m.publishDiscovery(nukiId, l)
+				}
+			case LockPairedEvent:
+				if l, err := m.bridge.GetLock(uint(nukiId)); err == nil {
+					m.publishLock(nukiId, l)
+				}
+			}
+			if nukiId == 0 {
+				m.publish(m.topic("event", event.Event), false, event.Data)
+			} else {
+				m.publish(m.lockTopic(nukiId, "event", event.Event), false, event.Data)
+			}
+		}
+	}
+}
+
+func (m *mqttPublisher) onCommand(c mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), m.options.BaseTopic+"/"), "/")
+	nukiId, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		log.WithError(err).WithField("topic", msg.Topic()).Warnln("Invalid mqtt command topic")
+		return
+	}
+	cmd := MQTTCommand{}
+	payload := strings.TrimSpace(string(msg.Payload()))
+	if strings.HasPrefix(payload, "{") {
+		if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+			log.WithError(err).WithField("topic", msg.Topic()).Warnln("Invalid mqtt command")
+			return
+		}
+	} else {
+		cmd.Action = payload
+	}
+	// Lock actions take a while, the client must not be blocked meanwhile
+	go func() {
+		if strings.ToLower(cmd.Action) == "refresh" {
+			l, err := m.bridge.GetLock(uint(nukiId))
+			if err == nil {
+				_, err = m.bridge.refreshState(l)
+			}
+			if err != nil {
+				log.WithError(err).WithField("nukiId", nukiId).Errorln("Failed to refresh state by mqtt command")
+			}
+			return
+		}
+		action, err := parseLockAction(cmd.Action)
+		if err != nil {
+			log.WithError(err).WithField("topic", msg.Topic()).Warnln("Invalid mqtt command")
+			return
+		}
+		if !m.bridge.limiter.allowLockAction("mqtt", uint32(nukiId)) {
+			log.WithError(errRateLimited).WithField("nukiId", nukiId).Warnln("Mqtt command dropped")
+			return
+		}
+		_, err = m.bridge.lockAction(uint(nukiId), action, cmd.Suffix)
+		m.bridge.auditLockAction("mqtt", "", uint32(nukiId), action.String(), err)
+		if err != nil {
+			log.WithError(err).WithField("nukiId", nukiId).Errorln("Failed to execute mqtt command")
+		}
+	}()
+}
+
+// parseLockAction accepts the name of the action, e.g. unlock, or its number
+func parseLockAction(value string) (enums.LockAction, error) {
+	if number, err := strconv.ParseUint(value, 10, 8); err == nil {
+		return enums.LockAction(number), nil
+	}
+	for _, action := range []enums.LockAction{
+		enums.LockActionUnlock,
+		enums.LockActionLock,
+		enums.LockActionUnlatch,
+		enums.LockActionLocknGo,
+		enums.LockActionLocknGoUnlatch,
+		enums.LockActionFullLock,
+		enums.LockActionFobAction1,
+		enums.LockActionFobAction2,
+		enums.LockActionFobAction3,
+	} {
+		if strings.EqualFold(action.String(), value) {
+			return action, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown lock action %s", value)
+}
@@ -0,0 +1,163 @@
+// +build integration
+
+package nukibridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+// The tests need a broker, e.g. started by
+//
+//   docker-compose -f docker-compose.test.yml up -d
+//   go test -tags "dev integration" ./pkg/nukibridge/ -run MQTT
+//
+// NUKI_TEST_MQTT_BROKER overrides the broker url.
+
+const testNukiId = 42
+
+func testBroker() string {
+	if broker := os.Getenv("NUKI_TEST_MQTT_BROKER"); broker != "" {
+		return broker
+	}
+	return "tcp://localhost:1883"
+}
+
+// newMQTTTestBridge returns a bridge without bluetooth device connected to the broker
+func newMQTTTestBridge(t *testing.T, baseTopic string) *bridge {
+	dir, err := ioutil.TempDir("", "nukibridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bridge{
+		dir:        dir,
+		Locks:      make(map[uint]*lock),
+		deviceLock: make(chan bool, 1),
+		limiter:    newRateLimiter(RateLimitOptions{}),
+	}
+	b.service = NewBridgeService(b)
+	if b.audit, err = newAuditLog(dir); err != nil {
+		t.Fatal(err)
+	}
+	l := &lock{nukiID: testNukiId, publish: b.publish}
+	l.lastState.CurrentTime = time.Now()
+	b.Locks[testNukiId] = l
+
+	m, err := newMQTTPublisher(b, MQTTOptions{Broker: testBroker(), ClientID: "nukibridge-test-" + baseTopic, BaseTopic: baseTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mqtt = m
+	m.start()
+	t.Cleanup(func() {
+		m.client.Disconnect(100)
+		os.RemoveAll(dir)
+	})
+	return b
+}
+
+// subscribeTest connects a second client and collects the messages of the topic
+func subscribeTest(t *testing.T, topic string) <-chan mqtt.Message {
+	messages := make(chan mqtt.Message, 16)
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(testBroker()).SetClientID(fmt.Sprintf("nukibridge-test-%d", time.Now().UnixNano())))
+	if token := c.Connect(); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Skipf("No mqtt broker at %s: %v", testBroker(), token.Error())
+	}
+	token := c.Subscribe(topic, mqttQos, func(c mqtt.Client, msg mqtt.Message) {
+		messages <- msg
+	})
+	if !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("Failed to subscribe %s: %v", topic, token.Error())
+	}
+	t.Cleanup(func() {
+		c.Disconnect(100)
+	})
+	return messages
+}
+
+func publishTest(t *testing.T, topic string, payload string) {
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(testBroker()).SetClientID(fmt.Sprintf("nukibridge-test-%d", time.Now().UnixNano())))
+	if token := c.Connect(); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("Failed to connect: %v", token.Error())
+	}
+	defer c.Disconnect(100)
+	if token := c.Publish(topic, mqttQos, false, payload); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("Failed to publish %s: %v", topic, token.Error())
+	}
+}
+
+func receiveTest(t *testing.T, messages <-chan mqtt.Message) mqtt.Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(mqttTimeout):
+		t.Fatal("No mqtt message received")
+		return nil
+	}
+}
+
+func TestMQTTPublishesStatusAndRetainedState(t *testing.T) {
+	status := subscribeTest(t, "test-retained/status")
+	state := subscribeTest(t, fmt.Sprintf("test-retained/%d/state", testNukiId))
+	newMQTTTestBridge(t, "test-retained")
+
+	if msg := receiveTest(t, status); string(msg.Payload()) != mqttOnline {
+		t.Errorf("status %s, want %s", msg.Payload(), mqttOnline)
+	}
+	msg := receiveTest(t, state)
+	var s models.KeyturnerStates
+	if err := json.Unmarshal(msg.Payload(), &s); err != nil {
+		t.Fatalf("Invalid state %s: %v", msg.Payload(), err)
+	}
+}
+
+func TestMQTTForwardsEvents(t *testing.T) {
+	events := subscribeTest(t, fmt.Sprintf("test-events/%d/event/%s", testNukiId, EventDoorOpened))
+	b := newMQTTTestBridge(t, "test-events")
+	// The publisher subscribes to the hub once it runs
+	time.Sleep(500 * time.Millisecond)
+
+	b.publish(Event{Event: EventDoorOpened, Data: newLockEvent(testNukiId)})
+	msg := receiveTest(t, events)
+	var e LockEvent
+	if err := json.Unmarshal(msg.Payload(), &e); err != nil || e.NukiId != testNukiId {
+		t.Errorf("event %s, want lock %d", msg.Payload(), testNukiId)
+	}
+}
+
+func TestMQTTCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"plain action", "unlock"},
+		{"action number", "2"},
+		{"json", `{"action": "lock", "suffix": "mqtt"}`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseTopic := fmt.Sprintf("test-commands-%d", i)
+			// Without bluetooth device the lock action fails, the failure is reported as event
+			failed := subscribeTest(t, fmt.Sprintf("%s/%d/event/%s", baseTopic, testNukiId, EventLockActionFailed))
+			newMQTTTestBridge(t, baseTopic)
+			time.Sleep(500 * time.Millisecond)
+
+			publishTest(t, fmt.Sprintf("%s/%d/command", baseTopic, testNukiId), tt.payload)
+			msg := receiveTest(t, failed)
+			var e LockActionEvent
+			if err := json.Unmarshal(msg.Payload(), &e); err != nil {
+				t.Fatalf("Invalid event %s: %v", msg.Payload(), err)
+			}
+			if e.Error != errDeviceUnavailable.Error() {
+				t.Errorf("error %s, want %s", e.Error, errDeviceUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
+)
+
+// mqttTestClient records the published messages instead of connecting to a broker
+type mqttTestClient struct {
+	mqtt.Client
+	messages map[string][]byte
+}
+
+func (c *mqttTestClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.messages[topic] = payload.([]byte)
+	return mqttTestToken{}
+}
+
+type mqttTestToken struct{}
+
+func (mqttTestToken) Wait() bool                     { return true }
+func (mqttTestToken) WaitTimeout(time.Duration) bool { return true }
+func (mqttTestToken) Error() error                   { return nil }
+func (mqttTestToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func TestParseLockAction(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    enums.LockAction
+		wantErr bool
+	}{
+		{"unlock", enums.LockActionUnlock, false},
+		{"Lock", enums.LockActionLock, false},
+		{"UNLATCH", enums.LockActionUnlatch, false},
+		{"locknGo", enums.LockActionLocknGo, false},
+		{"lockngounlatch", enums.LockActionLocknGoUnlatch, false},
+		{"fullLock", enums.LockActionFullLock, false},
+		{"fobAction3", enums.LockActionFobAction3, false},
+		{"2", enums.LockActionLock, false},
+		{"129", enums.LockActionFobAction1, false},
+		{"256", 0, true},
+		{"open", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseLockAction(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("action %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTTopics(t *testing.T) {
+	tests := []struct {
+		baseTopic string
+		want      string
+	}{
+		{"", "nukibridge/42/state"},
+		{"home/nuki", "home/nuki/42/state"},
+		{"home/nuki/", "home/nuki/42/state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.baseTopic, func(t *testing.T) {
+			m, err := newMQTTPublisher(nil, MQTTOptions{Broker: "tcp://localhost:1883", BaseTopic: tt.baseTopic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.lockTopic(42, "state"); got != tt.want {
+				t.Errorf("topic %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTDiscovery(t *testing.T) {
+	named := &lock{lastConfig: models.Config{NukiID: 42, Name: "Front door", FirmwareVersion: "2.12.4", HardwareRevision: "5.2"}}
+	tests := []struct {
+		name      string
+		lock      *lock
+		topic     string
+		wantName  string
+		wantState string
+		check     func(e haEntity) bool
+	}{
+		{"lock", named, "homeassistant/lock/nuki_42/config", "Front door", "nuki/42/state", func(e haEntity) bool {
+			return e.CommandTopic == "nuki/42/command" && e.PayloadOpen == "unlatch" && e.ValueTemplate == haLockStateTemplate &&
+				e.Device.SwVersion == "2.12.4" && e.Device.HwVersion == "5.2"
+		}},
+		{"lock without config", &lock{}, "homeassistant/lock/nuki_42/config", "Nuki 42", "nuki/42/state", func(e haEntity) bool {
+			return e.Device.Name == "Nuki 42" && e.Device.SwVersion == ""
+		}},
+		{"battery", named, "homeassistant/binary_sensor/nuki_42_battery_critical/config", "Front door Battery Critical", "nuki/42/battery", func(e haEntity) bool {
+			return e.DeviceClass == "battery" && e.UniqueID == "nuki_42_battery_critical" && e.CommandTopic == ""
+		}},
+		{"door", named, "homeassistant/binary_sensor/nuki_42_door/config", "Front door Door", "nuki/42/state", func(e haEntity) bool {
+			return e.DeviceClass == "door" && e.ValueTemplate == "{{ 'ON' if value_json.doorSensorState == 3 else 'OFF' }}"
+		}},
+		{"firmware", named, "homeassistant/sensor/nuki_42_firmware/config", "Front door Firmware", "nuki/42/config", func(e haEntity) bool {
+			return e.EntityCategory == "diagnostic" && e.ValueTemplate == "{{ value_json.firmwareVersion }}"
+		}},
+		{"hardware", named, "homeassistant/sensor/nuki_42_hardware/config", "Front door Hardware Revision", "nuki/42/config", func(e haEntity) bool {
+			return e.EntityCategory == "diagnostic" && e.ValueTemplate == "{{ value_json.hardwareRevision }}"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &mqttTestClient{messages: make(map[string][]byte)}
+			m := &mqttPublisher{client: c, options: MQTTOptions{BaseTopic: "nuki", Discovery: true, DiscoveryPrefix: "homeassistant"}}
+			m.publishDiscovery(42, tt.lock)
+			if len(c.messages) != 5 {
+				t.Errorf("%d entities announced, want 5", len(c.messages))
+			}
+			var e haEntity
+			if err := json.Unmarshal(c.messages[tt.topic], &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Name != tt.wantName || e.StateTopic != tt.wantState || e.AvailabilityTopic != "nuki/status" || e.Device == nil {
+				t.Fatalf("entity %+v, want %s with state topic %s", e, tt.wantName, tt.wantState)
+			}
+			if !tt.check(e) {
+				t.Errorf("entity %+v device %+v", e, *e.Device)
+			}
+		})
+	}
+}
+
+func TestMQTTDiscoveryDisabled(t *testing.T) {
+	c := &mqttTestClient{messages: make(map[string][]byte)}
+	m := &mqttPublisher{client: c, options: MQTTOptions{BaseTopic: "nuki"}}
+	m.publishDiscovery(42, &lock{})
+	if len(c.messages) != 0 {
+		t.Errorf("%d entities announced without discovery", len(c.messages))
+	}
+}
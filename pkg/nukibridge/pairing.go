@@ -0,0 +1,303 @@
+package nukibridge
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pairingRunning   = "running"
+	pairingCompleted = "completed"
+	pairingFailed    = "failed"
+
+	// Steps of a pairing job
+	pairingStepWaitingForPairing = "waitingForPairing"
+	pairingStepConnecting        = "connecting"
+	pairingStepPublicKeyExchange = "publicKeyExchange"
+	pairingStepChallenge         = "challenge"
+	pairingStepAuthorizationData = "authorizationData"
+	pairingStepIDConfirmation    = "idConfirmation"
+	pairingStepReadConfig        = "readConfig"
+	pairingStepVerifyPIN         = "verifyPIN"
+	pairingStepCompleted         = "completed"
+
+	defaultPairingTimeout = time.Minute
+	// defaultAuthorizationName is shown by the Nuki app for the authorization of the bridge
+	defaultAuthorizationName = "GoBridge"
+	// pairingJobsKept is the number of pairing jobs reported by the api
+	pairingJobsKept = 20
+)
+
+// pairingErrors describes the error codes reported by locks during the pairing
+var pairingErrors = map[uint8]string{
+	0x10: "lock is not in pairing mode, press its button for 5 seconds",
+	0x11: "lock rejected the authenticator",
+	0x12: "lock rejected a parameter",
+	0x13: "lock reached the maximum number of authorizations",
+}
+
+// pairingError is returned for the step of the pairing that failed
+type pairingError struct {
+	step string
+	err  error
+}
+
+func (e *pairingError) Error() string {
+	return fmt.Sprintf("Step %s failed: %v", e.step, e.err)
+}
+
+// pairingJobs tracks the pairing jobs, one runs at a time
+type pairingJobs struct {
+	mutex   sync.Mutex
+	jobs    []api.PairingJob
+	running bool
+	// waiting is set while the running job waits for a pairing window of the
+	// lock at address, of any unpaired lock if address is empty
+	waiting bool
+	address string
+	opened  chan string
+}
+
+// pairingWindowOpened signals the running job if it waits for the lock, it is false otherwise
+func (p *pairingJobs) pairingWindowOpened(address string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.waiting || (p.address != "" && p.address != address) {
+		return false
+	}
+	select {
+	case p.opened <- address:
+	default:
+	}
+	return true
+}
+
+func (p *pairingJobs) stopWaiting() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.waiting = false
+}
+
+// update changes the job with the id and returns a copy of it
+func (p *pairingJobs) update(id string, f func(job *api.PairingJob)) api.PairingJob {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i := range p.jobs {
+		if p.jobs[i].Id == id {
+			f(&p.jobs[i])
+			return p.jobs[i]
+		}
+	}
+	return api.PairingJob{}
+}
+
+func (p *pairingJobs) setStep(id string, step string) api.PairingJob {
+	return p.update(id, func(job *api.PairingJob) {
+		job.Step = step
+	})
+}
+
+func (p *pairingJobs) get(id string) (api.PairingJob, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, job := range p.jobs {
+		if job.Id == id {
+			return job, nil
+		}
+	}
+	return api.PairingJob{}, errors.New("Not found")
+}
+
+// PairingEvent reports the progress of a pairing job
+type PairingEvent struct {
+	LockEvent
+	Job     string `json:"job"`
+	State   string `json:"state"`
+	Step    string `json:"step"`
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// startPairing starts a job pairing the lock at the address, or the first unpaired lock
+// opening its pairing window, in the background
+func (b *bridge) startPairing(address string, timeout time.Duration, name string, pin uint) (api.PairingJob, error) {
+	if !b.deviceAvailable() {
+		return api.PairingJob{}, errDeviceUnavailable
+	}
+	address = strings.ToUpper(address)
+	if address != "" && b.pairedAddress(address) {
+		return api.PairingJob{}, fmt.Errorf("Lock at %s is already paired", address)
+	}
+	if name == "" {
+		name = defaultAuthorizationName
+	}
+	if len(name) > 32 {
+		return api.PairingJob{}, errors.New("Name of the authorization exceeds 32 bytes")
+	}
+	if pin > math.MaxUint16 {
+		return api.PairingJob{}, errors.New("Admin PIN exceeds 65535")
+	}
+	if timeout <= 0 {
+		timeout = defaultPairingTimeout
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return api.PairingJob{}, err
+	}
+	job := api.PairingJob{
+		Id:      id,
+		State:   pairingRunning,
+		Step:    pairingStepWaitingForPairing,
+		Address: address,
+		Name:    name,
+		Started: time.Now().UTC().Format(time.RFC3339),
+	}
+	p := &b.pairing
+	p.mutex.Lock()
+	if p.running {
+		p.mutex.Unlock()
+		return api.PairingJob{}, errors.New("Pairing is already running")
+	}
+	p.running = true
+	p.waiting = true
+	p.address = address
+	p.opened = make(chan string, 1)
+	p.jobs = append(p.jobs, job)
+	if len(p.jobs) > pairingJobsKept {
+		p.jobs = p.jobs[len(p.jobs)-pairingJobsKept:]
+	}
+	p.mutex.Unlock()
+	log.WithField("job", id).WithField("lock", address).Infoln("Starting pairing")
+	go b.runPairing(id, name, pin, timeout)
+	return job, nil
+}
+
+// runPairing pairs the lock and records the outcome of the job
+func (b *bridge) runPairing(id string, name string, pin uint, timeout time.Duration) {
+	l, err := b.pair(id, name, pin, timeout)
+	b.pairing.stopWaiting()
+	pairing := AuditEntry{
+		Identity:  "bridge",
+		Operation: "lockPaired",
+		Outcome:   AuditSuccess,
+		Params:    map[string]string{"job": id},
+	}
+	finished := time.Now().UTC().Format(time.RFC3339)
+	var job api.PairingJob
+	if err != nil {
+		log.WithError(err).WithField("job", id).Errorln("Failed to pair lock")
+		job = b.pairing.update(id, func(job *api.PairingJob) {
+			job.State = pairingFailed
+			job.Error = err.Error()
+			job.Finished = finished
+		})
+		pairing.Outcome = AuditFailure
+		pairing.Error = err.Error()
+	} else {
+		b.addPairedLock(l)
+		job = b.pairing.update(id, func(job *api.PairingJob) {
+			job.State = pairingCompleted
+			job.Step = pairingStepCompleted
+			job.NukiId = int32(l.nukiID)
+			job.LockName = l.lastConfig.Name
+			job.Finished = finished
+		})
+		pairing.NukiId = l.nukiID
+		pairing.Params["name"] = l.lastConfig.Name
+		log.WithField("job", id).WithField("nukiId", l.nukiID).Infoln("Lock paired")
+	}
+	pairing.Source = job.Address
+	b.audit.record(pairing)
+	b.publishPairingStep(job)
+	b.pairing.mutex.Lock()
+	b.pairing.running = false
+	b.pairing.mutex.Unlock()
+}
+
+// pair waits for the pairing window, authorizes the bridge and reads the config of the lock
+func (b *bridge) pair(id string, name string, pin uint, timeout time.Duration) (*lock, error) {
+	b.publishPairingStep(b.pairing.setStep(id, pairingStepWaitingForPairing))
+	log.WithField("job", id).WithField("timeout", timeout).Infoln("Press the button of the lock for 5 seconds to open its pairing window")
+	var address string
+	select {
+	case address = <-b.pairing.opened:
+	case <-time.After(timeout):
+		return nil, &pairingError{pairingStepWaitingForPairing, fmt.Errorf("Pairing window was not opened within %v", timeout)}
+	}
+	b.pairing.stopWaiting()
+	b.pairing.update(id, func(job *api.PairingJob) {
+		job.Address = address
+	})
+
+	if err := b.aquireDevice(); err != nil {
+		return nil, &pairingError{pairingStepConnecting, err}
+	}
+	defer b.releaseDevice()
+	l := &lock{
+		address:  address,
+		adminPIN: pin,
+		publish:  b.publish,
+	}
+	defer l.Disconnect()
+	step := func(step string) {
+		b.publishPairingStep(b.pairing.setStep(id, step))
+	}
+	pub, priv := b.keys()
+	if err := l.Pair(pub, priv, name, step); err != nil {
+		return nil, err
+	}
+	step(pairingStepReadConfig)
+	config, err := l.RequestConfig()
+	if err != nil {
+		return nil, &pairingError{pairingStepReadConfig, err}
+	}
+	l.nukiID = config.NukiID
+	l.lastConfig = config
+	if pin != 0 {
+		// Reading the log needs the admin PIN, a wrong one would only show up in later history
+		// synchronizations, key rotations and removals
+		step(pairingStepVerifyPIN)
+		if _, err := l.RequestLogEntries(0, 1); err != nil {
+			// The lock authorized the bridge already, so it is kept without the PIN
+			l.adminPIN = 0
+			b.pairing.update(id, func(job *api.PairingJob) {
+				job.Warning = fmt.Sprintf("Admin PIN was not accepted (%v), set it by PUT /locks/%d", err, l.nukiID)
+			})
+			log.WithError(err).WithField("job", id).WithField("nukiId", l.nukiID).Warnln("Admin PIN of the paired lock was not accepted")
+		}
+	}
+	return l, nil
+}
+
+// pairedAddress is true if a paired lock has the address
+func (b *bridge) pairedAddress(address string) bool {
+	for _, l := range b.GetLocks() {
+		if l.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bridge) publishPairingStep(job api.PairingJob) {
+	b.publish(Event{
+		Event: EventPairing,
+		Data: PairingEvent{
+			LockEvent: newLockEvent(uint32(job.NukiId)),
+			Job:       job.Id,
+			State:     job.State,
+			Step:      job.Step,
+			Address:   job.Address,
+			Error:     job.Error,
+			Warning:   job.Warning,
+		},
+	})
+}
@@ -0,0 +1,139 @@
+package nukibridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPairingTestBridge returns a bridge with an opened device that is scanning
+func newPairingTestBridge(t *testing.T) *bridge {
+	b := newConfigTestBridge(t, newConfigTestDir(t), Options{})
+	b.deviceLock = make(chan bool, 1)
+	b.cancelScan = func() {}
+	b.health.setDevice(nil)
+	var err error
+	if b.audit, err = newAuditLog(b.dir); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPairingWindowOpened(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiting bool
+		address string
+		opened  string
+		want    bool
+	}{
+		{"not waiting", false, "", "AA:BB:CC:DD:EE:FF", false},
+		{"any lock", true, "", "AA:BB:CC:DD:EE:FF", true},
+		{"the lock", true, "AA:BB:CC:DD:EE:FF", "AA:BB:CC:DD:EE:FF", true},
+		{"other lock", true, "AA:BB:CC:DD:EE:FF", "11:22:33:44:55:66", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pairingJobs{waiting: tt.waiting, address: tt.address, opened: make(chan string, 1)}
+			if got := p.pairingWindowOpened(tt.opened); got != tt.want {
+				t.Fatalf("pairing window routed to the job: %v, want %v", got, tt.want)
+			}
+			// A second advertisement must not block while the job is busy
+			p.pairingWindowOpened(tt.opened)
+			select {
+			case address := <-p.opened:
+				if !tt.want || address != tt.opened {
+					t.Errorf("job signaled with %s", address)
+				}
+			default:
+				if tt.want {
+					t.Error("job not signaled")
+				}
+			}
+		})
+	}
+}
+
+func TestStartPairingRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(b *bridge)
+		address string
+		auth    string
+		pin     uint
+		want    string
+	}{
+		{"device unavailable", func(b *bridge) { b.cancelScan = nil }, "", "", 0, errDeviceUnavailable.Error()},
+		{"paired lock", func(b *bridge) {
+			b.Locks[1] = &lock{address: "AA:BB:CC:DD:EE:FF"}
+		}, "aa:bb:cc:dd:ee:ff", "", 0, "Lock at AA:BB:CC:DD:EE:FF is already paired"},
+		{"long name", func(b *bridge) {}, "", strings.Repeat("a", 33), 0, "Name of the authorization exceeds 32 bytes"},
+		{"large pin", func(b *bridge) {}, "", "", 65536, "Admin PIN exceeds 65535"},
+		{"running", func(b *bridge) { b.pairing.running = true }, "", "", 0, "Pairing is already running"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newPairingTestBridge(t)
+			tt.setup(b)
+			if _, err := b.startPairing(tt.address, time.Minute, tt.auth, tt.pin); err == nil || err.Error() != tt.want {
+				t.Errorf("error %v, want %s", err, tt.want)
+			}
+			if len(b.pairing.jobs) != 0 {
+				t.Errorf("%d jobs recorded", len(b.pairing.jobs))
+			}
+		})
+	}
+}
+
+func TestPairingJobTimesOut(t *testing.T) {
+	b := newPairingTestBridge(t)
+	sub := b.service.events.subscribe(16)
+	job, err := b.startPairing("aa:bb:cc:dd:ee:ff", 50*time.Millisecond, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != pairingRunning || job.Step != pairingStepWaitingForPairing || job.Address != "AA:BB:CC:DD:EE:FF" || job.Name != defaultAuthorizationName {
+		t.Errorf("started job %+v", job)
+	}
+
+	var events []PairingEvent
+	for len(events) == 0 || events[len(events)-1].State == pairingRunning {
+		select {
+		case event := <-sub.events:
+			if data, ok := event.Data.(PairingEvent); ok {
+				events = append(events, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pairing job not finished, events %+v", events)
+		}
+	}
+	if len(events) != 2 || events[0].Step != pairingStepWaitingForPairing {
+		t.Errorf("events %+v, want waiting for pairing and failure", events)
+	}
+	failed, err := b.pairing.get(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.State != pairingFailed || failed.Step != pairingStepWaitingForPairing || !strings.Contains(failed.Error, "Pairing window was not opened") || failed.Finished == "" {
+		t.Errorf("job %+v, want it failed while waiting for the pairing window", failed)
+	}
+	if b.pairing.pairingWindowOpened("AA:BB:CC:DD:EE:FF") {
+		t.Error("pairing window routed to the finished job")
+	}
+	// The next job may start once the failure is recorded
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.pairing.mutex.Lock()
+		running := b.pairing.running
+		b.pairing.mutex.Unlock()
+		if !running {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("pairing job still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := b.pairing.get("unknown"); err == nil {
+		t.Error("unknown job found")
+	}
+}
@@ -0,0 +1,166 @@
+package nukibridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errRateLimited = errors.New("Too many lock actions")
+
+// RateLimitOptions configures the throttling of failed authentications and lock actions
+type RateLimitOptions struct {
+	// AuthFailures bans a source address after that many failed authentications within AuthFailureWindow, disabled if 0
+	AuthFailures      int
+	AuthFailureWindow time.Duration
+	// BanDuration is the time all requests of a banned source address are rejected
+	BanDuration time.Duration
+	// LockActions limits the lock actions per identity and lock within LockActionWindow, disabled if 0
+	LockActions      int
+	LockActionWindow time.Duration
+}
+
+type authFailures struct {
+	times       []time.Time
+	bannedUntil time.Time
+}
+
+// rateLimiter keeps the recent auth failures per source address and lock actions per identity and lock
+type rateLimiter struct {
+	options   RateLimitOptions
+	mutex     sync.Mutex
+	failures  map[string]*authFailures
+	actions   map[string][]time.Time
+	lastPrune time.Time
+}
+
+func newRateLimiter(options RateLimitOptions) *rateLimiter {
+	if options.AuthFailureWindow <= 0 {
+		options.AuthFailureWindow = 10 * time.Minute
+	}
+	if options.BanDuration <= 0 {
+		options.BanDuration = 15 * time.Minute
+	}
+	if options.LockActionWindow <= 0 {
+		options.LockActionWindow = time.Minute
+	}
+	return &rateLimiter{
+		options:  options,
+		failures: make(map[string]*authFailures),
+		actions:  make(map[string][]time.Time),
+	}
+}
+
+// sourceAddress is the ip of the client without port, proxy headers are not trusted
+func sourceAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recent drops the times before the window
+func recent(times []time.Time, window time.Duration, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= window {
+		i++
+	}
+	return times[i:]
+}
+
+// banned returns the remaining ban time of the source address, 0 if it is not banned
+func (l *rateLimiter) banned(source string) time.Duration {
+	if l.options.AuthFailures <= 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, ok := l.failures[source]
+	if !ok {
+		return 0
+	}
+	if remaining := time.Until(f.bannedUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// authFailed records a failed authentication and bans the source address if there were too many
+func (l *rateLimiter) authFailed(source string) {
+	authFailuresTotal.Inc()
+	if l.options.AuthFailures <= 0 {
+		return
+	}
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(now)
+	f, ok := l.failures[source]
+	if !ok {
+		f = &authFailures{}
+		l.failures[source] = f
+	}
+	f.times = append(recent(f.times, l.options.AuthFailureWindow, now), now)
+	if len(f.times) >= l.options.AuthFailures {
+		f.bannedUntil = now.Add(l.options.BanDuration)
+		f.times = nil
+		authBans.Inc()
+		log.WithField("source", source).WithField("duration", l.options.BanDuration).Warningln("Banned source after repeated authentication failures")
+	}
+}
+
+// allowLockAction counts the lock action of the identity if it is within the limit
+func (l *rateLimiter) allowLockAction(name string, nukiId uint32) bool {
+	if l.options.LockActions <= 0 {
+		return true
+	}
+	now := time.Now()
+	key := fmt.Sprintf("%s/%d", name, nukiId)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(now)
+	times := recent(l.actions[key], l.options.LockActionWindow, now)
+	if len(times) >= l.options.LockActions {
+		l.actions[key] = times
+		lockActionsLimited.Inc()
+		log.WithField("identity", name).WithField("nukiId", nukiId).Warningln("Lock action rate limit exceeded")
+		return false
+	}
+	l.actions[key] = append(times, now)
+	return true
+}
+
+// prune removes expired entries once a minute, must be called with the mutex held
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for source, f := range l.failures {
+		if len(recent(f.times, l.options.AuthFailureWindow, now)) == 0 && now.After(f.bannedUntil) {
+			delete(l.failures, source)
+		}
+	}
+	for key, times := range l.actions {
+		if len(recent(times, l.options.LockActionWindow, now)) == 0 {
+			delete(l.actions, key)
+		}
+	}
+}
+
+// rejectBanned answers requests of banned source addresses with 429
+func (b *bridge) rejectBanned(w http.ResponseWriter, r *http.Request) bool {
+	remaining := b.limiter.banned(sourceAddress(r))
+	if remaining <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(remaining.Seconds())+1))
+	http.Error(w, "Too many failed requests", http.StatusTooManyRequests)
+	return true
+}
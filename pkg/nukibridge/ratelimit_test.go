@@ -0,0 +1,132 @@
+package nukibridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecent(t *testing.T) {
+	now := time.Now()
+	times := []time.Time{now.Add(-3 * time.Minute), now.Add(-time.Minute), now.Add(-time.Second), now}
+	tests := []struct {
+		window time.Duration
+		want   int
+	}{
+		{time.Hour, 4},
+		{2 * time.Minute, 3},
+		{time.Minute, 2},
+		{time.Second, 1},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := recent(times, tt.window, now); len(got) != tt.want {
+			t.Errorf("%d times within %v, want %d", len(got), tt.window, tt.want)
+		}
+	}
+}
+
+func TestAllowLockAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		actions int
+		allowed int
+	}{
+		{"disabled", 0, 20, 20},
+		{"within limit", 3, 3, 3},
+		{"over limit", 3, 5, 3},
+		{"single action", 1, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(RateLimitOptions{LockActions: tt.limit, LockActionWindow: time.Hour})
+			allowed := 0
+			for i := 0; i < tt.actions; i++ {
+				if l.allowLockAction("alice", 1) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("%d actions allowed, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAllowLockActionPerIdentityAndLock(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{LockActions: 1, LockActionWindow: time.Hour})
+	if !l.allowLockAction("alice", 1) {
+		t.Fatal("first action denied")
+	}
+	if l.allowLockAction("alice", 1) {
+		t.Error("second action of alice on lock 1 allowed")
+	}
+	if !l.allowLockAction("alice", 2) {
+		t.Error("action of alice on lock 2 denied")
+	}
+	if !l.allowLockAction("bob", 1) {
+		t.Error("action of bob on lock 1 denied")
+	}
+}
+
+func TestAllowLockActionWindow(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{LockActions: 1, LockActionWindow: time.Hour})
+	l.allowLockAction("alice", 1)
+	// Move the recorded action out of the window
+	l.actions["alice/1"][0] = time.Now().Add(-2 * time.Hour)
+	if !l.allowLockAction("alice", 1) {
+		t.Error("action denied after the window passed")
+	}
+}
+
+func TestAuthFailedBans(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		failures int
+		banned   bool
+	}{
+		{"disabled", 0, 20, false},
+		{"below limit", 3, 2, false},
+		{"at limit", 3, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(RateLimitOptions{AuthFailures: tt.limit, BanDuration: time.Hour})
+			for i := 0; i < tt.failures; i++ {
+				l.authFailed("10.0.0.1")
+			}
+			if banned := l.banned("10.0.0.1") > 0; banned != tt.banned {
+				t.Errorf("banned %v, want %v", banned, tt.banned)
+			}
+			if l.banned("10.0.0.2") > 0 {
+				t.Error("other source banned")
+			}
+		})
+	}
+}
+
+func TestRejectBanned(t *testing.T) {
+	b := &bridge{limiter: newRateLimiter(RateLimitOptions{AuthFailures: 1, BanDuration: time.Minute})}
+	b.limiter.authFailed("10.0.0.1")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:51234"
+	w := httptest.NewRecorder()
+	if !b.rejectBanned(w, r) {
+		t.Fatal("banned source not rejected")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After %s, want 60", w.Header().Get("Retry-After"))
+	}
+
+	r.RemoteAddr = "10.0.0.2:51234"
+	if b.rejectBanned(httptest.NewRecorder(), r) {
+		t.Error("other source rejected")
+	}
+}
@@ -0,0 +1,259 @@
+package nukibridge
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	rotationRunning   = "running"
+	rotationCompleted = "completed"
+	rotationFailed    = "failed"
+
+	// Steps of a lock during the key rotation
+	rotationStepPending               = "pending"
+	rotationStepWaitingForPairing     = "waitingForPairing"
+	rotationStepAuthenticating        = "authenticating"
+	rotationStepRemovingAuthorization = "removingAuthorization"
+	rotationStepCompleted             = "completed"
+	rotationStepFailed                = "failed"
+
+	defaultRotationTimeout = 2 * time.Minute
+	// pairingServiceData is advertised by locks with an open pairing window
+	pairingServiceData = "a92ee100550111e4916c0800200c9a66"
+)
+
+// keyRotation tracks the progress of the last key rotation
+type keyRotation struct {
+	mutex   sync.Mutex
+	running bool
+	status  api.KeyRotation
+	// waiting is the address of the lock whose pairing window is awaited
+	waiting string
+	opened  chan struct{}
+}
+
+// pairingWindowOpened signals the rotation if it waits for the lock, it is false otherwise
+func (r *keyRotation) pairingWindowOpened(address string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.waiting == "" || r.waiting != address {
+		return false
+	}
+	select {
+	case r.opened <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (r *keyRotation) wait(address string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.waiting = address
+	select {
+	case <-r.opened:
+	default:
+	}
+}
+
+func (r *keyRotation) setStep(i int, step string, err string, warning string) api.KeyRotationLock {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status.Locks[i].Step = step
+	r.status.Locks[i].Error = err
+	if warning != "" {
+		r.status.Locks[i].Warning = warning
+	}
+	return r.status.Locks[i]
+}
+
+func (r *keyRotation) progress() api.KeyRotation {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := r.status
+	status.Locks = append([]api.KeyRotationLock{}, r.status.Locks...)
+	return status
+}
+
+// KeyRotationEvent reports the progress of a lock during the key rotation
+type KeyRotationEvent struct {
+	LockEvent
+	Step    string `json:"step"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// startKeyRotation generates a new key pair and authorizes the locks with it in the background
+func (b *bridge) startKeyRotation(nukiIds []uint32, timeout time.Duration) (api.KeyRotation, error) {
+	if !b.deviceAvailable() {
+		return api.KeyRotation{}, errDeviceUnavailable
+	}
+	if timeout <= 0 {
+		timeout = defaultRotationTimeout
+	}
+	locks := make([]*lock, 0)
+	if len(nukiIds) == 0 {
+		for _, l := range b.GetLocks() {
+			locks = append(locks, l)
+		}
+	}
+	for _, id := range nukiIds {
+		l, err := b.GetLock(uint(id))
+		if err != nil {
+			return api.KeyRotation{}, err
+		}
+		locks = append(locks, l)
+	}
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return api.KeyRotation{}, err
+	}
+	r := &b.rotation
+	r.mutex.Lock()
+	if r.running {
+		r.mutex.Unlock()
+		return api.KeyRotation{}, errors.New("Key rotation is already running")
+	}
+	r.running = true
+	r.opened = make(chan struct{}, 1)
+	r.status = api.KeyRotation{
+		State:     rotationRunning,
+		PublicKey: base64.StdEncoding.EncodeToString(pub[:]),
+		Started:   time.Now().UTC().Format(time.RFC3339),
+		Locks:     make([]api.KeyRotationLock, 0, len(locks)),
+	}
+	for _, l := range locks {
+		r.status.Locks = append(r.status.Locks, api.KeyRotationLock{
+			NukiId: int32(l.nukiID),
+			Name:   l.lastConfig.Name,
+			Step:   rotationStepPending,
+		})
+	}
+	r.mutex.Unlock()
+	log.WithField("locks", len(locks)).Infoln("Starting key rotation")
+	go b.rotateKeys(locks, *pub, *priv, timeout)
+	return r.progress(), nil
+}
+
+// rotateKeys authorizes the locks one after another. The bridge switches to the
+// new key pair if a lock succeeded, the others keep the previous keys as lock keys.
+func (b *bridge) rotateKeys(locks []*lock, pub [32]byte, priv [32]byte, timeout time.Duration) {
+	succeeded := 0
+	for i, l := range locks {
+		e := AuditEntry{
+			Identity:  "bridge",
+			Source:    l.address,
+			Operation: "lockKeyRotated",
+			NukiId:    l.nukiID,
+			Outcome:   AuditSuccess,
+		}
+		warning, err := b.rotateLockKeys(i, l, pub, priv, timeout)
+		if err != nil {
+			log.WithError(err).WithField("nukiId", l.nukiID).Errorln("Failed to rotate keys of lock")
+			b.publishRotationStep(l, b.rotation.setStep(i, rotationStepFailed, err.Error(), ""))
+			e.Outcome = AuditFailure
+			e.Error = err.Error()
+			b.audit.record(e)
+			continue
+		}
+		if warning != "" {
+			e.Params = map[string]string{"warning": warning}
+		}
+		b.audit.record(e)
+		succeeded++
+	}
+	if succeeded > 0 || len(locks) == 0 {
+		b.setKeys(pub, priv)
+		if err := b.saveConfig(); err != nil {
+			log.WithError(err).Errorln("Failed to save rotated keys")
+		}
+	}
+	r := &b.rotation
+	r.mutex.Lock()
+	r.running = false
+	r.waiting = ""
+	r.status.State = rotationCompleted
+	if succeeded < len(locks) {
+		r.status.State = rotationFailed
+	}
+	r.status.Finished = time.Now().UTC().Format(time.RFC3339)
+	r.mutex.Unlock()
+	log.WithField("succeeded", succeeded).WithField("failed", len(locks)-succeeded).Infoln("Key rotation finished")
+}
+
+// rotateLockKeys waits for the pairing window of the lock, authorizes the new keys
+// and removes the previous authorization. A failed removal is returned as warning.
+func (b *bridge) rotateLockKeys(i int, l *lock, pub [32]byte, priv [32]byte, timeout time.Duration) (string, error) {
+	b.rotation.wait(l.address)
+	defer b.rotation.wait("")
+	b.publishRotationStep(l, b.rotation.setStep(i, rotationStepWaitingForPairing, "", ""))
+	log.WithField("nukiId", l.nukiID).WithField("timeout", timeout).Infoln("Press the button of the lock for 5 seconds to open its pairing window")
+	select {
+	case <-b.rotation.opened:
+	case <-time.After(timeout):
+		return "", errors.New("Pairing window was not opened in time")
+	}
+
+	if err := b.aquireDevice(); err != nil {
+		return "", err
+	}
+	defer b.releaseDevice()
+	b.publishRotationStep(l, b.rotation.setStep(i, rotationStepAuthenticating, "", ""))
+	l.Disconnect()
+	rotated := &lock{
+		nukiID:   l.nukiID,
+		address:  l.address,
+		adminPIN: l.adminPIN,
+		publish:  b.publish,
+	}
+	defer rotated.Disconnect()
+	if err := rotated.Authenticate(pub, priv); err != nil {
+		return "", err
+	}
+	config, err := rotated.RequestConfig()
+	if err != nil {
+		return "", err
+	}
+	if config.NukiID != l.nukiID {
+		return "", fmt.Errorf("Lock at %s is %d", l.address, config.NukiID)
+	}
+
+	b.publishRotationStep(l, b.rotation.setStep(i, rotationStepRemovingAuthorization, "", ""))
+	warning := ""
+	if err := rotated.RemoveUserAuthorization(l.authorizationID); err != nil {
+		warning = fmt.Sprintf("Previous authorization %d was not removed: %v", l.authorizationID, err)
+		log.WithField("nukiId", l.nukiID).Warnln(warning)
+	}
+
+	l.bridgePublicKey = pub
+	l.bridgePrivateKey = priv
+	l.authorizationID = rotated.authorizationID
+	l.peersPublicKey = rotated.peersPublicKey
+	l.lastConfig = config
+	if err := b.saveConfig(); err != nil {
+		log.WithError(err).Errorln("Failed to save rotated keys of lock")
+	}
+	b.publishRotationStep(l, b.rotation.setStep(i, rotationStepCompleted, "", warning))
+	return warning, nil
+}
+
+func (b *bridge) publishRotationStep(l *lock, step api.KeyRotationLock) {
+	b.publish(Event{
+		Event: EventKeyRotation,
+		Data: KeyRotationEvent{
+			LockEvent: newLockEvent(l.nukiID),
+			Step:      step.Step,
+			Error:     step.Error,
+			Warning:   step.Warning,
+		},
+	})
+}
@@ -0,0 +1,127 @@
+package nukibridge
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// newRotationTestBridge returns an initialized bridge with lock 1 authorized by the keys of the bridge
+func newRotationTestBridge(t *testing.T) *bridge {
+	b := newPairingTestBridge(t)
+	if err := b.init(); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLock("AA:BB:CC:DD:EE:FF", 1, make([]byte, 32), 1234)
+	l.nukiID = 1
+	l.bridgePublicKey, l.bridgePrivateKey = b.keys()
+	b.Locks[1] = l
+	if err := b.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// waitForRotation returns once the rotation finished
+func waitForRotation(t *testing.T, b *bridge) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.rotation.mutex.Lock()
+		running := b.rotation.running
+		b.rotation.mutex.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("key rotation still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeyRotationPairingWindowOpened(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiting string
+		opened  string
+		want    bool
+	}{
+		{"not waiting", "", "AA:BB:CC:DD:EE:FF", false},
+		{"the lock", "AA:BB:CC:DD:EE:FF", "AA:BB:CC:DD:EE:FF", true},
+		{"other lock", "AA:BB:CC:DD:EE:FF", "11:22:33:44:55:66", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &keyRotation{opened: make(chan struct{}, 1)}
+			r.wait(tt.waiting)
+			if got := r.pairingWindowOpened(tt.opened); got != tt.want {
+				t.Errorf("pairing window routed to the rotation: %v, want %v", got, tt.want)
+			}
+			// Waiting for the next lock drops the signal of the previous one
+			r.wait("11:22:33:44:55:66")
+			select {
+			case <-r.opened:
+				t.Error("stale pairing window signaled")
+			default:
+			}
+		})
+	}
+}
+
+func TestKeyRotationFailedKeepsKeys(t *testing.T) {
+	b := newRotationTestBridge(t)
+	pub, priv := b.keys()
+	status, err := b.startKeyRotation(nil, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Locks) != 1 || status.Locks[0].Step != rotationStepPending {
+		t.Errorf("started rotation %+v, want lock 1 pending", status)
+	}
+	waitForRotation(t, b)
+	status = b.rotation.progress()
+	if status.State != rotationFailed || status.Locks[0].Step != rotationStepFailed || status.Locks[0].Error == "" {
+		t.Errorf("rotation %+v, want lock 1 failed", status)
+	}
+	if rotatedPub, rotatedPriv := b.keys(); rotatedPub != pub || rotatedPriv != priv {
+		t.Error("bridge switched keys without a rotated lock")
+	}
+	if l := b.Locks[1]; l.bridgePublicKey != pub || l.bridgePrivateKey != priv {
+		t.Error("lock lost the keys it is authorized by")
+	}
+}
+
+func TestKeyRotationKeepsKeysOfOtherLocks(t *testing.T) {
+	b := newRotationTestBridge(t)
+	oldPub, oldPriv := b.keys()
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.rotation.running = true
+	b.rotateKeys(nil, *pub, *priv, time.Minute)
+	if state := b.rotation.progress().State; state != rotationCompleted {
+		t.Errorf("rotation %s, want %s", state, rotationCompleted)
+	}
+
+	// A restarted bridge
+	restarted := newConfigTestBridge(t, b.dir, Options{})
+	if err := restarted.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if rotatedPub, rotatedPriv := restarted.keys(); rotatedPub != *pub || rotatedPriv != *priv {
+		t.Error("bridge did not switch to the rotated keys")
+	}
+	l, err := restarted.GetLock(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.bridgePublicKey != oldPub || l.bridgePrivateKey != oldPriv {
+		t.Error("lock outside of the rotation lost the keys it is authorized by")
+	}
+	if cfg := restarted.currentConfig(); cfg.Locks["1"].BridgePrivateKey == "" {
+		t.Error("keys of the lock not persisted")
+	}
+}
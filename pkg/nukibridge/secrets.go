@@ -0,0 +1,285 @@
+package nukibridge
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// passphraseCredential is the name of the systemd credential holding the passphrase
+	passphraseCredential = "nukibridge-passphrase"
+
+	secretsKDF = "scrypt"
+	// scrypt parameters recommended for interactive logins, derived once at startup
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+var errPassphraseRequired = errors.New("Configuration is encrypted, a passphrase is required")
+
+// EncryptionConfiguration holds the secrets of the configuration encrypted
+// with a key derived from the passphrase
+type EncryptionConfiguration struct {
+	KDF  string `json:"kdf"`
+	Salt string `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	// Secrets is the nonce followed by the sealed configSecrets
+	Secrets string `json:"secrets"`
+}
+
+// configSecrets are the fields of the configuration that allow opening the locks
+type configSecrets struct {
+	PrivateKey string                 `json:"privateKey"`
+	JWTKey     string                 `json:"jwtKey,omitempty"`
+	Locks      map[string]lockSecrets `json:"locks,omitempty"`
+}
+
+type lockSecrets struct {
+	PublicKey        string `json:"publicKey"`
+	AdminPIN         uint   `json:"adminPIN"`
+	BridgePrivateKey string `json:"bridgePrivateKey,omitempty"`
+}
+
+// secretKey is the key derived from the passphrase with its parameters
+type secretKey struct {
+	key  [32]byte
+	salt []byte
+	n    int
+	r    int
+	p    int
+}
+
+func deriveSecretKey(passphrase string, salt []byte, n int, r int, p int) (*secretKey, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	k := &secretKey{salt: salt, n: n, r: r, p: p}
+	copy(k.key[:], derived)
+	return k, nil
+}
+
+// newSecretKey derives a key with a new salt
+func newSecretKey(passphrase string) (*secretKey, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return deriveSecretKey(passphrase, salt, scryptN, scryptR, scryptP)
+}
+
+// seal moves the secrets of the configuration into its encrypted part
+func (k *secretKey) seal(cfg *Configuration) error {
+	secrets := configSecrets{
+		PrivateKey: cfg.PrivateKey,
+		JWTKey:     cfg.JWTKey,
+		Locks:      make(map[string]lockSecrets),
+	}
+	cfg.PrivateKey = ""
+	cfg.JWTKey = ""
+	for id, lockCfg := range cfg.Locks {
+		secrets.Locks[id] = lockSecrets{PublicKey: lockCfg.PublicKey, AdminPIN: lockCfg.AdminPIN, BridgePrivateKey: lockCfg.BridgePrivateKey}
+		lockCfg.PublicKey = ""
+		lockCfg.AdminPIN = 0
+		lockCfg.BridgePrivateKey = ""
+		cfg.Locks[id] = lockCfg
+	}
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	cfg.Encryption, err = k.sealData(data)
+	return err
+}
+
+// sealData encrypts the data with a new nonce
+func (k *secretKey) sealData(data []byte) (*EncryptionConfiguration, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	sealed := secretbox.Seal(nonce[:], data, &nonce, &k.key)
+	return &EncryptionConfiguration{
+		KDF:     secretsKDF,
+		Salt:    base64.StdEncoding.EncodeToString(k.salt),
+		N:       k.n,
+		R:       k.r,
+		P:       k.p,
+		Secrets: base64.StdEncoding.EncodeToString(sealed),
+	}, nil
+}
+
+// openData derives the key from the passphrase and decrypts the data
+func openData(enc *EncryptionConfiguration, passphrase string) (*secretKey, []byte, error) {
+	if passphrase == "" {
+		return nil, nil, errPassphraseRequired
+	}
+	if enc.KDF != secretsKDF {
+		return nil, nil, errors.New("Unknown key derivation " + enc.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(enc.Secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sealed) < 24 {
+		return nil, nil, errors.New("Encrypted data is too short")
+	}
+	k, err := deriveSecretKey(passphrase, salt, enc.N, enc.R, enc.P)
+	if err != nil {
+		return nil, nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	data, ok := secretbox.Open(nil, sealed[24:], &nonce, &k.key)
+	if !ok {
+		return nil, nil, errors.New("Wrong passphrase or corrupted data")
+	}
+	return k, data, nil
+}
+
+// openSecrets decrypts the secrets of the configuration and returns the key to seal it again
+func openSecrets(cfg *Configuration, passphrase string) (*secretKey, error) {
+	k, data, err := openData(cfg.Encryption, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var secrets configSecrets
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, err
+	}
+	cfg.PrivateKey = secrets.PrivateKey
+	cfg.JWTKey = secrets.JWTKey
+	for id, s := range secrets.Locks {
+		if lockCfg, ok := cfg.Locks[id]; ok {
+			lockCfg.PublicKey = s.PublicKey
+			lockCfg.AdminPIN = s.AdminPIN
+			lockCfg.BridgePrivateKey = s.BridgePrivateKey
+			cfg.Locks[id] = lockCfg
+		}
+	}
+	cfg.Encryption = nil
+	return k, nil
+}
+
+// unlockConfig decrypts the configuration with the passphrase of the options.
+// It is true if a plain configuration has to be encrypted.
+func (b *bridge) unlockConfig(cfg *Configuration) (bool, error) {
+	if cfg.Encryption != nil {
+		k, err := openSecrets(cfg, b.options.Passphrase)
+		if err != nil {
+			return false, err
+		}
+		b.secrets = k
+		log.Infoln("Configuration unlocked")
+		return false, nil
+	}
+	if b.options.Passphrase == "" {
+		return false, nil
+	}
+	k, err := newSecretKey(b.options.Passphrase)
+	if err != nil {
+		return false, err
+	}
+	b.secrets = k
+	return true, nil
+}
+
+// removeBackups deletes all backups of the configuration, they hold the secrets unencrypted or
+// encrypted with a previous passphrase
+func removeBackups(dir string) error {
+	file := path.Join(dir, filename)
+	backups, err := filepath.Glob(file + ".*")
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		if _, err := strconv.Atoi(strings.TrimPrefix(backup, file+".")); err != nil {
+			continue
+		}
+		if err := os.Remove(backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPassphrase returns the passphrase if set, otherwise the content of the
+// file or of the systemd credential nukibridge-passphrase
+func ReadPassphrase(passphrase string, file string) (string, error) {
+	if passphrase != "" {
+		return passphrase, nil
+	}
+	if file == "" {
+		dir, ok := os.LookupEnv("CREDENTIALS_DIRECTORY")
+		if !ok {
+			return "", nil
+		}
+		file = path.Join(dir, passphraseCredential)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return "", nil
+		}
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ChangePassphrase encrypts the configuration in the path with a new
+// passphrase, the old one is empty if it is not encrypted yet. The bridge
+// must not run while the passphrase is changed.
+func ChangePassphrase(dir string, oldPassphrase string, newPassphrase string) error {
+	if newPassphrase == "" {
+		return errors.New("New passphrase is empty")
+	}
+	file := path.Join(dir, filename)
+	cfg, _, err := readConfigFile(file)
+	if err != nil {
+		return err
+	}
+	if cfg.Encryption != nil {
+		if _, err := openSecrets(&cfg, oldPassphrase); err != nil {
+			return err
+		}
+	}
+	k, err := newSecretKey(newPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := k.seal(&cfg); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := writeConfigFile(dir, data, 0); err != nil {
+		return err
+	}
+	if err := removeBackups(dir); err != nil {
+		return err
+	}
+	// Not audited, the audit log belongs to the bridge
+	log.Infoln("Passphrase of the configuration changed")
+	return nil
+}
@@ -0,0 +1,163 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newSecretsTestConfig() Configuration {
+	return Configuration{
+		Version:    1,
+		PrivateKey: "bridge-private-key",
+		PublicKey:  "bridge-public-key",
+		JWTKey:     "jwt-key",
+		Locks: map[string]LockConfiguration{
+			"42": {PublicKey: "lock-public-key", Address: "AA:BB:CC:DD:EE:FF", AuthorizationId: "3", AdminPIN: 1234, BridgePrivateKey: "old-private-key"},
+		},
+	}
+}
+
+func TestSealOpenSecrets(t *testing.T) {
+	k, err := newSecretKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newSecretsTestConfig()
+	if err := k.seal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"bridge-private-key", "jwt-key", "lock-public-key", "old-private-key", "1234"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("sealed configuration contains %s", secret)
+		}
+	}
+	if !strings.Contains(string(data), "bridge-public-key") || !strings.Contains(string(data), "AA:BB:CC:DD:EE:FF") {
+		t.Error("sealed configuration misses the public parts")
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		wantErr    bool
+	}{
+		{"empty passphrase", "", true},
+		{"wrong passphrase", "wrong horse", true},
+		{"passphrase", "correct horse", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opened Configuration
+			if err := json.Unmarshal(data, &opened); err != nil {
+				t.Fatal(err)
+			}
+			_, err := openSecrets(&opened, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.passphrase == "" && err != errPassphraseRequired {
+				t.Errorf("error %v, want %v", err, errPassphraseRequired)
+			}
+			if err == nil && !reflect.DeepEqual(opened, newSecretsTestConfig()) {
+				t.Errorf("opened %+v, want %+v", opened, newSecretsTestConfig())
+			}
+		})
+	}
+}
+
+func TestOpenDataTampered(t *testing.T) {
+	k, err := newSecretKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := k.sealData([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tamper func(enc EncryptionConfiguration) EncryptionConfiguration
+	}{
+		{"secrets", func(enc EncryptionConfiguration) EncryptionConfiguration {
+			enc.Secrets = enc.Secrets[:len(enc.Secrets)-4] + "AAAA"
+			return enc
+		}},
+		{"salt", func(enc EncryptionConfiguration) EncryptionConfiguration {
+			enc.Salt = "AAAAAAAAAAAAAAAAAAAAAA=="
+			return enc
+		}},
+		{"too short", func(enc EncryptionConfiguration) EncryptionConfiguration {
+			enc.Secrets = "AAAA"
+			return enc
+		}},
+		{"kdf", func(enc EncryptionConfiguration) EncryptionConfiguration {
+			enc.KDF = "pbkdf2"
+			return enc
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(*enc)
+			if _, _, err := openData(&tampered, "correct horse"); err == nil {
+				t.Error("tampered data opened")
+			}
+		})
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	dir := newConfigTestDir(t)
+	data, err := json.Marshal(newSecretsTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfigFile(dir, data, 0); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, filename)
+	// A backup with the unencrypted secrets
+	if err := ioutil.WriteFile(backupFilename(file, 1), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		old     string
+		new     string
+		wantErr bool
+	}{
+		{"encrypt", "", "first", false},
+		{"wrong passphrase", "second", "third", true},
+		{"change", "first", "second", false},
+		{"empty passphrase", "second", "", true},
+	}
+	for _, step := range steps {
+		err := ChangePassphrase(dir, step.old, step.new)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: error %v, want error %v", step.name, err, step.wantErr)
+		}
+	}
+	if _, err := ioutil.ReadFile(backupFilename(file, 1)); err == nil {
+		t.Error("backup with unencrypted secrets kept")
+	}
+	cfg, _, err := readConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSecrets(&cfg, "first"); err == nil {
+		t.Error("configuration opened with the old passphrase")
+	}
+	if _, err := openSecrets(&cfg, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, newSecretsTestConfig()) {
+		t.Errorf("opened %+v, want %+v", cfg, newSecretsTestConfig())
+	}
+}
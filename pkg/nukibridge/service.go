@@ -1,114 +1,140 @@
 package nukibridge
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/mapero/nuki-bridge/pkg/nukibridge/api"
 	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/models"
 	log "github.com/sirupsen/logrus"
 )
 
-type SseEvent struct {
-	Event string
-	Data  interface{}
-}
+const (
+	sseClientBuffer      = 32
+	sseHeartbeatInterval = 15 * time.Second
+)
 
 type NukiBridgeService struct {
 	bridge *bridge
 
-	callbackNotifier  chan api.CallbackObject
-	newCallbacks      chan string
-	removingCallbacks chan int
-	callbacks         map[int]string
+	callbacksMutex sync.RWMutex
+	callbacks      map[int]*callback
 
-	sseNotifier       chan SseEvent
-	newSseClients     chan chan SseEvent
-	closingSseClients chan chan SseEvent
-	sseClients        map[chan SseEvent]bool
+	events *eventHub
 }
 
 func NewBridgeService(bridge *bridge) *NukiBridgeService {
 	s := &NukiBridgeService{
-		bridge:            bridge,
-		callbackNotifier:  make(chan api.CallbackObject, 1),
-		newCallbacks:      make(chan string),
-		removingCallbacks: make(chan int),
-		callbacks:         make(map[int]string),
-		sseNotifier:       make(chan SseEvent, 1),
-		newSseClients:     make(chan chan SseEvent),
-		closingSseClients: make(chan chan SseEvent),
-		sseClients:        make(map[chan SseEvent]bool),
-	}
-	go s.listen()
+		bridge:    bridge,
+		callbacks: make(map[int]*callback),
+		events:    newEventHub(bridge.options.EventHistorySize, bridge.options.EventHistoryAge),
+	}
 
 	return s
 }
 
-func (s *NukiBridgeService) listen() {
-	for {
-		select {
+// notifyCallbacks queues the event for all matching callbacks without blocking
+func (s *NukiBridgeService) notifyCallbacks(event CallbackEvent) {
+	s.callbacksMutex.RLock()
+	defer s.callbacksMutex.RUnlock()
+	for _, c := range s.callbacks {
+		if c.matches(event) {
+			c.enqueue(event)
+		}
+	}
+}
 
-		case url := <-s.newCallbacks:
+func (s *NukiBridgeService) addCallback(c *callback) int {
+	s.callbacksMutex.Lock()
+	i := 0
+	for {
+		if _, ok := s.callbacks[i]; !ok {
+			break
+		}
+		i++
+	}
+	c.id = i
+	c.start()
+	s.callbacks[i] = c
+	log.WithField("count", len(s.callbacks)).Infoln("Callback added")
+	s.callbacksMutex.Unlock()
+	s.saveCallbacks()
+	return i
+}
 
-			// A new client has connected.
-			// Register their message channel
-			i := 0
-			for {
-				if _, ok := s.callbacks[i]; !ok {
-					break
-				}
-				i++
-			}
-			s.callbacks[i] = url
-			log.WithField("count", len(s.callbacks)).Infoln("Callback added")
-
-		case id := <-s.removingCallbacks:
-			// A client has dettached and we want to
-			// stop sending them messages.
-			delete(s.callbacks, id)
-			log.WithField("count", len(s.callbacks)).Infoln("Callback removed")
-
-		case event := <-s.callbackNotifier:
-			// We got a new event from the outside!
-			// Send event to all connected clients
-			body, err := json.Marshal(event)
-			if err != nil {
-				log.WithError(err).Errorln("Failed to receive callback event")
-				continue
-			}
-			for _, url := range s.callbacks {
-				_, err := http.Post(url, "application/json", bytes.NewBuffer(body))
-				if err != nil {
-					log.WithError(err).Errorln("Failed to send callback event")
-				}
-			}
+func (s *NukiBridgeService) removeCallback(id int) {
+	s.callbacksMutex.Lock()
+	if c, ok := s.callbacks[id]; ok {
+		c.stop()
+		delete(s.callbacks, id)
+	}
+	log.WithField("count", len(s.callbacks)).Infoln("Callback removed")
+	s.callbacksMutex.Unlock()
+	s.saveCallbacks()
+}
 
-		case c := <-s.newSseClients:
-			s.sseClients[c] = true
-			log.WithField("count", len(s.sseClients)).Infoln("SSE client added")
+// saveCallbacks stores the callbacks with the configuration to keep them across restarts
+func (s *NukiBridgeService) saveCallbacks() {
+	if err := s.bridge.saveConfig(); err != nil {
+		log.WithError(err).Errorln("Failed to save callbacks")
+	}
+}
 
-		case c := <-s.closingSseClients:
-			delete(s.sseClients, c)
-			log.WithField("count", len(s.sseClients)).Infoln("SSE client removed")
+// callbackConfigs returns the configuration of all callbacks ordered by id
+func (s *NukiBridgeService) callbackConfigs() []api.CallbackConfig {
+	s.callbacksMutex.RLock()
+	defer s.callbacksMutex.RUnlock()
+	configs := make([]api.CallbackConfig, 0, len(s.callbacks))
+	for _, c := range s.callbacks {
+		configs = append(configs, c.config())
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Id < configs[j].Id
+	})
+	return configs
+}
 
-		case event := <-s.sseNotifier:
-			for ch, _ := range s.sseClients {
-				ch <- event
-			}
+// restoreCallbacks replaces all callbacks keeping their ids, invalid callbacks are skipped
+func (s *NukiBridgeService) restoreCallbacks(configs []api.CallbackConfig) (int, error) {
+	callbacks := make(map[int]*callback)
+	var err error
+	for _, cfg := range configs {
+		c, cbErr := newCallback(cfg)
+		if cbErr != nil {
+			err = cbErr
+			continue
 		}
-
+		c.id = int(cfg.Id)
+		callbacks[c.id] = c
 	}
+	s.callbacksMutex.Lock()
+	for _, c := range s.callbacks {
+		c.stop()
+	}
+	for _, c := range callbacks {
+		c.start()
+	}
+	s.callbacks = callbacks
+	s.callbacksMutex.Unlock()
+	return len(callbacks), err
 }
 
-func (s *NukiBridgeService) ListGet() (interface{}, error) {
+func (s *NukiBridgeService) ListGet(ctx context.Context) (interface{}, error) {
 	locks := s.bridge.GetLocks()
+	caller := identityFrom(ctx)
 
 	list := make([]api.NukiLock, 0)
 	for key, lock := range locks {
+		if !caller.allowsLock(uint32(key)) {
+			continue
+		}
 		entry := api.NukiLock{
 			NukiId: int32(key),
 			Name:   lock.lastConfig.Name,
@@ -132,7 +158,9 @@ func (s *NukiBridgeService) LockStateGet(nukiId string) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	s.bridge.aquireDevice()
+	if err := s.bridge.aquireDevice(); err != nil {
+		return nil, err
+	}
 	defer s.bridge.releaseDevice()
 	state, err := lock.RequestKeyturnerState()
 	if err != nil {
@@ -155,13 +183,11 @@ func (s *NukiBridgeService) LockActionGet(nukiId string, action string, noWait s
 	if err != nil {
 		return nil, err
 	}
-	lock, err := s.bridge.GetLock(uint(id))
-	if err != nil {
-		return nil, err
+	if noWait == "1" || noWait == "true" {
+		err = s.bridge.lockActionNoWait(uint(id), enums.LockAction(act), "")
+	} else {
+		_, err = s.bridge.lockAction(uint(id), enums.LockAction(act), "")
 	}
-	s.bridge.aquireDevice()
-	defer s.bridge.releaseDevice()
-	_, err = lock.LockAction(enums.LockAction(act), "")
 	if err != nil {
 		return nil, err
 	}
@@ -171,18 +197,24 @@ func (s *NukiBridgeService) LockActionGet(nukiId string, action string, noWait s
 }
 
 func (s *NukiBridgeService) CallbackAddGet(url string) (interface{}, error) {
-	s.newCallbacks <- url
+	c, err := newCallback(api.CallbackConfig{Url: url})
+	if err != nil {
+		return nil, err
+	}
+	s.addCallback(c)
 	return &api.SimpleResponse{
 		Success: true,
 	}, nil
 }
 
 func (s *NukiBridgeService) CallbackListGet() (interface{}, error) {
+	s.callbacksMutex.RLock()
+	defer s.callbacksMutex.RUnlock()
 	callbacks := api.Callbacks{}
-	for id, url := range s.callbacks {
+	for id, c := range s.callbacks {
 		callback := api.Callback{
 			Id:  int32(id),
-			Url: url,
+			Url: c.url,
 		}
 		callbacks.Callbacks = append(callbacks.Callbacks, callback)
 	}
@@ -194,12 +226,209 @@ func (s *NukiBridgeService) CallbackRemoveGet(nukiId string) (interface{}, error
 	if err != nil {
 		return nil, err
 	}
-	s.removingCallbacks <- int(id)
+	s.removeCallback(int(id))
 	return &api.SimpleResponse{
 		Success: true,
 	}, nil
 }
 
+// ApiKeysGet - Returns all api keys without their tokens
+func (s *NukiBridgeService) ApiKeysGet() (interface{}, error) {
+	s.bridge.apiKeysMutex.RLock()
+	defer s.bridge.apiKeysMutex.RUnlock()
+	keys := make([]api.ApiKey, 0, len(s.bridge.apiKeys))
+	for _, k := range s.bridge.apiKeys {
+		keys = append(keys, k.toApi())
+	}
+	return keys, nil
+}
+
+// ApiKeysIdDelete - Revokes an api key
+func (s *NukiBridgeService) ApiKeysIdDelete(id string) (interface{}, error) {
+	if err := s.bridge.removeAPIKey(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ApiKeysPost - Creates an api key, the token is only returned once
+func (s *NukiBridgeService) ApiKeysPost(key api.ApiKey) (interface{}, error) {
+	nukiIds := make([]uint32, 0, len(key.NukiIds))
+	for _, id := range key.NukiIds {
+		nukiIds = append(nukiIds, uint32(id))
+	}
+	k, token, err := s.bridge.addAPIKey(key.Name, key.Scopes, nukiIds)
+	if err != nil {
+		log.WithError(err).Warnln("Invalid api key")
+		return nil, err
+	}
+	result := k.toApi()
+	result.Token = token
+	return result, nil
+}
+
+// ClientCertsGet - Returns all client certificate identities
+func (s *NukiBridgeService) ClientCertsGet() (interface{}, error) {
+	s.bridge.clientCertsMutex.RLock()
+	defer s.bridge.clientCertsMutex.RUnlock()
+	certs := make([]api.ClientCert, 0, len(s.bridge.clientCerts))
+	for _, c := range s.bridge.clientCerts {
+		certs = append(certs, c.toApi())
+	}
+	return certs, nil
+}
+
+// ClientCertsIdDelete - Removes a client certificate identity
+func (s *NukiBridgeService) ClientCertsIdDelete(id string) (interface{}, error) {
+	if err := s.bridge.removeClientCert(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ClientCertsPost - Maps a client certificate subject to an identity
+func (s *NukiBridgeService) ClientCertsPost(cert api.ClientCert) (interface{}, error) {
+	nukiIds := make([]uint32, 0, len(cert.NukiIds))
+	for _, id := range cert.NukiIds {
+		nukiIds = append(nukiIds, uint32(id))
+	}
+	c, err := s.bridge.addClientCert(cert.Subject, cert.Scopes, nukiIds)
+	if err != nil {
+		log.WithError(err).Warnln("Invalid client certificate identity")
+		return nil, err
+	}
+	return c.toApi(), nil
+}
+
+// IdentityExportPost - Exports the identity of the bridge as encrypted archive
+func (s *NukiBridgeService) IdentityExportPost(request api.IdentityExportRequest) (interface{}, error) {
+	return s.bridge.exportIdentity(request.Passphrase)
+}
+
+// IdentityImportPost - Imports the identity of an archive and verifies the authorizations of the locks
+func (s *NukiBridgeService) IdentityImportPost(request api.IdentityImportRequest) (interface{}, error) {
+	result, err := s.bridge.importIdentity(request.Archive, request.Passphrase, request.Force)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to import identity")
+		return nil, err
+	}
+	return result, nil
+}
+
+// BridgeKeysRotatePost - Starts the rotation of the bridge key pair
+func (s *NukiBridgeService) BridgeKeysRotatePost(request api.KeyRotationRequest) (interface{}, error) {
+	nukiIds := make([]uint32, 0, len(request.NukiIds))
+	for _, id := range request.NukiIds {
+		nukiIds = append(nukiIds, uint32(id))
+	}
+	rotation, err := s.bridge.startKeyRotation(nukiIds, time.Duration(request.Timeout)*time.Second)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to start key rotation")
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// BridgeKeysRotationGet - Returns the progress of the last key rotation
+func (s *NukiBridgeService) BridgeKeysRotationGet() (interface{}, error) {
+	return s.bridge.rotation.progress(), nil
+}
+
+// PairingPost - Starts a pairing job
+func (s *NukiBridgeService) PairingPost(request api.PairingRequest) (interface{}, error) {
+	var pin uint
+	if request.Pin != nil {
+		pin = uint(*request.Pin)
+	}
+	job, err := s.bridge.startPairing(request.Address, time.Duration(request.Timeout)*time.Second, request.Name, pin)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to start pairing")
+		return nil, err
+	}
+	return job, nil
+}
+
+// PairingIdGet - Returns the progress of a pairing job
+func (s *NukiBridgeService) PairingIdGet(id string) (interface{}, error) {
+	return s.bridge.pairing.get(id)
+}
+
+// AuditGet - Returns entries of the audit log, the newest first
+func (s *NukiBridgeService) AuditGet(from string, to string, nukiId string, identity string, operation string, outcome string, limit string) (interface{}, error) {
+	filter := AuditFilter{
+		Identity:  identity,
+		Operation: operation,
+		Outcome:   outcome,
+		Limit:     100,
+	}
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, err
+		}
+		filter.From = t
+	}
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, err
+		}
+		filter.To = t
+	}
+	if nukiId != "" {
+		id, err := strconv.ParseUint(nukiId, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		filter.NukiId = uint32(id)
+	}
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, err
+		}
+		filter.Limit = l
+	}
+	return s.bridge.audit.query(filter)
+}
+
+// AuditVerifyGet - Verifies the hash chain of the audit log
+func (s *NukiBridgeService) AuditVerifyGet() (interface{}, error) {
+	return s.bridge.audit.verify(), nil
+}
+
+// CallbacksGet - Returns all registered callbacks including their filters
+func (s *NukiBridgeService) CallbacksGet() (interface{}, error) {
+	s.callbacksMutex.RLock()
+	defer s.callbacksMutex.RUnlock()
+	callbacks := make([]api.CallbackConfig, 0)
+	for _, c := range s.callbacks {
+		callbacks = append(callbacks, c.config())
+	}
+	return callbacks, nil
+}
+
+// CallbacksIdDelete - Removes a callback
+func (s *NukiBridgeService) CallbacksIdDelete(id string) (interface{}, error) {
+	callbackId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	s.removeCallback(int(callbackId))
+	return nil, nil
+}
+
+// CallbacksPost - Registers a new callback with filters and payload format
+func (s *NukiBridgeService) CallbacksPost(cfg api.CallbackConfig) (interface{}, error) {
+	c, err := newCallback(cfg)
+	if err != nil {
+		log.WithError(err).Warnln("Invalid callback")
+		return nil, err
+	}
+	s.addCallback(c)
+	return c.config(), nil
+}
+
 func (s *NukiBridgeService) LocksIdCurrentStateGet(id string) (interface{}, error) {
 	nukiId, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
@@ -209,7 +438,9 @@ func (s *NukiBridgeService) LocksIdCurrentStateGet(id string) (interface{}, erro
 	if err != nil {
 		return nil, err
 	}
-	s.bridge.aquireDevice()
+	if err := s.bridge.aquireDevice(); err != nil {
+		return nil, err
+	}
 	defer s.bridge.releaseDevice()
 	res, err := lock.RequestKeyturnerState()
 	if err != nil {
@@ -219,9 +450,13 @@ func (s *NukiBridgeService) LocksIdCurrentStateGet(id string) (interface{}, erro
 	return res, nil
 }
 
-func (s *NukiBridgeService) LocksGet() (interface{}, error) {
+func (s *NukiBridgeService) LocksGet(ctx context.Context) (interface{}, error) {
+	caller := identityFrom(ctx)
 	locks := make([]api.Lock, 0)
 	for id, l := range s.bridge.GetLocks() {
+		if !caller.allowsLock(uint32(id)) {
+			continue
+		}
 		nukiId := fmt.Sprint(id)
 		lock := api.Lock{
 			Address: &l.address,
@@ -233,26 +468,26 @@ func (s *NukiBridgeService) LocksGet() (interface{}, error) {
 	return locks, nil
 }
 
-func (s *NukiBridgeService) LocksIdHistoryGet(id string, offset string, count string) (interface{}, error) {
+// LocksIdHistoryGet - Returns the history of the lock from the history store
+func (s *NukiBridgeService) LocksIdHistoryGet(id string, offset string, count string, from string, to string, type_ string, authId string, name string, action string, order string, sync string) (interface{}, error) {
 	nukiId, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
 		return nil, err
 	}
-	off, err := strconv.ParseUint(offset, 10, 32)
+	lock, err := s.bridge.GetLock(uint(nukiId))
 	if err != nil {
 		return nil, err
 	}
-	c, err := strconv.ParseUint(count, 10, 32)
+	filter, err := parseHistoryFilter(offset, count, from, to, type_, authId, name, action, order)
 	if err != nil {
 		return nil, err
 	}
-	lock, err := s.bridge.GetLock(uint(nukiId))
-	if err != nil {
-		return nil, err
+	if sync == "true" {
+		if err := s.bridge.syncHistory(lock); err != nil {
+			return nil, err
+		}
 	}
-	s.bridge.aquireDevice()
-	defer s.bridge.releaseDevice()
-	return lock.RequestLogEntries(uint32(off), uint16(c))
+	return s.bridge.history.query(uint32(nukiId), filter)
 }
 
 func (s *NukiBridgeService) LocksIdLastStateGet(id string) (interface{}, error) {
@@ -293,12 +528,18 @@ func (s *NukiBridgeService) LocksIdConfigGet(id string) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	s.bridge.aquireDevice()
+	if err := s.bridge.aquireDevice(); err != nil {
+		return nil, err
+	}
 	defer s.bridge.releaseDevice()
 	c, err := lock.RequestConfig()
 	if err != nil {
 		return nil, err
 	}
+	return toLockConfig(id, c), nil
+}
+
+func toLockConfig(id string, c models.Config) api.LockConfig {
 	timezoneOffset := int32(c.TimezoneOffset.Minutes())
 	advertisingMode := int32(c.AdvertisingMode)
 	fobAction1 := int32(c.FobAction1)
@@ -307,7 +548,7 @@ func (s *NukiBridgeService) LocksIdConfigGet(id string) (interface{}, error) {
 	homekitstatus := int32(c.HomeKitStatus)
 	ledbrightness := int32(c.LEDBrightness)
 	timezoneId := int32(c.TimezoneID)
-	config := api.LockConfig{
+	return api.LockConfig{
 		NukiId:           &id,
 		Name:             &c.Name,
 		AdvertisingMode:  &advertisingMode,
@@ -331,18 +572,23 @@ func (s *NukiBridgeService) LocksIdConfigGet(id string) (interface{}, error) {
 		TimezoneId:       &timezoneId,
 		TimezoneOffset:   &timezoneOffset,
 	}
-	return config, nil
 }
 
-// LocksIdDelete - Update a linked lock
-func (s *NukiBridgeService) LocksIdDelete(id string) (interface{}, error) {
+// LocksIdDelete - Remove a linked lock
+func (s *NukiBridgeService) LocksIdDelete(id string, force string) (interface{}, error) {
 	nukiId, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
 		return nil, err
 	}
-	delete(s.bridge.Locks, uint(nukiId))
-	s.bridge.saveConfig()
-	return nil, nil
+	result, err := s.bridge.removeLock(uint(nukiId), force == "true")
+	if err == errLockNotRemoved {
+		// Answered with 409, the result tells why the lock was kept
+		return result, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // LocksIdGet - Returns a linked lock
@@ -393,42 +639,109 @@ func (s *NukiBridgeService) EventsGet(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Each connection registers its own message channel with the Broker's connections registry
-	messageChan := make(chan SseEvent)
-
-	// Signal the broker that we have a new connection
-	s.newSseClients <- messageChan
-
-	// Remove this client from the map of connected clients
-	// when this handler exits.
-	defer func() {
-		s.closingSseClients <- messageChan
-	}()
+	// Reconnecting clients send the id of the last received event
+	// to get all events they missed in between.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
 
-	// Listen to connection close and un-register messageChan
-	// notify := rw.(http.CloseNotifier).CloseNotify()
-	notify := r.Context().Done()
+	// Each connection registers its own bounded queue with the event hub
+	// and removes it when this handler exits.
+	sub, replay := s.events.subscribeSince(lastID, sseClientBuffer)
+	defer s.events.unsubscribe(sub)
+	sseClients.Inc()
+	defer sseClients.Dec()
 
-	go func() {
-		<-notify
-		s.closingSseClients <- messageChan
-	}()
+	// Comments are ignored by clients but keep proxies from closing idle connections
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
 
+	caller := identityFrom(r.Context())
+	for _, event := range replay {
+		if caller.allowsEvent(event) {
+			writeSseEvent(w, event)
+		}
+	}
+	flusher.Flush()
 	for {
-
-		// Write to the ResponseWriter
-		// Server Sent Events compatible
-		event := <-messageChan
-		data, err := json.Marshal(event.Data)
-		if err != nil {
-			log.WithError(err).Warnln("Failed to json marshal sse event")
-			continue
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.dropped:
+			log.WithField("source", r.RemoteAddr).Warnln("SSE client dropped")
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-sub.events:
+			if !caller.allowsEvent(event) {
+				continue
+			}
+			writeSseEvent(w, event)
 		}
-		fmt.Fprintf(w, "event: %s\n", event.Event)
-		fmt.Fprintf(w, "data: %s\n\n", data)
 
 		// Flush the data immediatly instead of buffering it for later.
 		flusher.Flush()
 	}
-	return
+}
+
+// writeSseEvent writes the event to the ResponseWriter
+// Server Sent Events compatible
+func writeSseEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to json marshal sse event")
+		return
+	}
+	fmt.Fprintf(w, "id: %d\n", event.ID)
+	fmt.Fprintf(w, "event: %s\n", event.Event)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// TokensPost - Issues a signed json web token
+func (s *NukiBridgeService) TokensPost(req api.TokenRequest) (interface{}, error) {
+	token, err := s.bridge.issueToken(req)
+	if err != nil {
+		log.WithError(err).Warnln("Invalid token request")
+		return nil, err
+	}
+	return token, nil
+}
+
+// GrantsGet - Returns all guest grants including their uses
+func (s *NukiBridgeService) GrantsGet() (interface{}, error) {
+	s.bridge.guests.mutex.Lock()
+	defer s.bridge.guests.mutex.Unlock()
+	grants := make([]api.Grant, 0, len(s.bridge.guests.grants))
+	for _, g := range s.bridge.guests.grants {
+		grants = append(grants, g.toApi(""))
+	}
+	return grants, nil
+}
+
+// GrantsIdDelete - Revokes a guest grant
+func (s *NukiBridgeService) GrantsIdDelete(id string) (interface{}, error) {
+	if err := s.bridge.removeGrant(id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// GrantsPost - Creates a guest grant, the link is only returned once
+func (s *NukiBridgeService) GrantsPost(grant api.Grant) (interface{}, error) {
+	result, err := s.bridge.addGrant(grant)
+	if err != nil {
+		log.WithError(err).Warnln("Invalid guest grant")
+		return nil, err
+	}
+	return result, nil
 }
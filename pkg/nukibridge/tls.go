@@ -0,0 +1,207 @@
+package nukibridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tlsCertFilename = "tls.crt"
+	tlsKeyFilename  = "tls.key"
+
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+	// selfSignedRenewal renews self-signed certificates this long before they expire
+	selfSignedRenewal = 30 * 24 * time.Hour
+)
+
+// TLSOptions configures https for the api
+type TLSOptions struct {
+	// Enabled serves https with a self-signed certificate if no certificate file is set
+	Enabled bool
+	// CertFile and KeyFile are PEM files of the certificate, they enable https if set
+	CertFile string
+	KeyFile  string
+	// Hosts are additional names and addresses of the self-signed certificate
+	Hosts []string
+	// ClientCAFile is the PEM file of the ca signing client certificates, enables client certificate authentication if set
+	ClientCAFile string
+	// RequireClientCert rejects connections without valid client certificate, otherwise tokens are accepted as well
+	RequireClientCert bool
+	// RedirectPort serves redirects from http to https, e.g. :8081, disabled if empty
+	RedirectPort string
+}
+
+func (o TLSOptions) enabled() bool {
+	return o.Enabled || o.CertFile != ""
+}
+
+// loadCertificate returns the configured certificate or a self-signed one kept in the config directory
+func (b *bridge) loadCertificate() (tls.Certificate, error) {
+	options := b.options.TLS
+	if options.CertFile != "" {
+		if options.KeyFile == "" {
+			return tls.Certificate{}, errors.New("Key file of the certificate is missing")
+		}
+		return tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	}
+	certFile := path.Join(b.dir, tlsCertFilename)
+	keyFile := path.Join(b.dir, tlsKeyFilename)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Add(selfSignedRenewal).Before(leaf.NotAfter) {
+			return cert, nil
+		}
+		log.Infoln("Self-signed certificate expires, renewing it")
+	} else if !os.IsNotExist(err) {
+		return tls.Certificate{}, err
+	}
+	if err := generateCertificate(certFile, keyFile, options.Hosts); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.WithField("file", certFile).Infoln("Generated self-signed certificate")
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// generateCertificate writes a self-signed certificate for the hostname and all addresses of the host
+func generateCertificate(certFile string, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Nuki Bridge"}, CommonName: "nukibridge"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	names := append([]string{"localhost"}, hosts...)
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				names = append(names, ipNet.IP.String())
+			}
+		}
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if name != "" {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// fingerprint is the SHA-256 fingerprint of the leaf certificate as shown by browsers
+func fingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// redirectHandler redirects all requests to the https port of the api
+func redirectHandler(port string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// serve listens by http or https depending on the tls options
+func (b *bridge) serve(handler http.Handler) error {
+	if !b.options.TLS.enabled() {
+		if b.options.TLS.ClientCAFile != "" {
+			log.Warningln("Client certificates need https, the client ca is ignored")
+		}
+		log.WithField("port", b.port).Infoln("serving web services")
+		return http.ListenAndServe(b.port, handler)
+	}
+	cert, err := b.loadCertificate()
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if b.options.TLS.ClientCAFile != "" {
+		pool, err := loadClientCAs(b.options.TLS.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if b.options.TLS.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if b.options.TLS.RequireClientCert {
+		return errors.New("Client certificates are required but no client ca is set")
+	}
+	server := &http.Server{
+		Addr:      b.port,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	if b.options.TLS.RedirectPort != "" {
+		go func() {
+			log.WithField("port", b.options.TLS.RedirectPort).Infoln("Redirecting http to https")
+			if err := http.ListenAndServe(b.options.TLS.RedirectPort, redirectHandler(b.port)); err != nil {
+				log.WithError(err).Errorln("Failed to serve http redirects")
+			}
+		}()
+	}
+	log.WithField("port", b.port).WithField("fingerprint", fingerprint(cert)).Infoln("serving web services by https")
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,62 @@
+package nukibridge
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   string
+		target string
+		want   string
+	}{
+		{"https port", ":8443", "http://bridge:8081/api/v1/info?token=a", "https://bridge:8443/api/v1/info?token=a"},
+		{"default port", ":443", "http://bridge:8081/api/v1/info", "https://bridge/api/v1/info"},
+		{"host without port", "0.0.0.0:8443", "http://bridge/", "https://bridge:8443/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirectHandler(tt.port).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.want {
+				t.Errorf("%d to %s, want redirect to %s", w.Code, w.Header().Get("Location"), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCertificateKeepsSelfSigned(t *testing.T) {
+	b := newConfigTestBridge(t, newConfigTestDir(t), Options{TLS: TLSOptions{Enabled: true, Hosts: []string{"bridge.local", "10.0.0.2"}}})
+	cert, err := b.loadCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "bridge.local", "10.0.0.2"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+	// A restarted bridge
+	reloaded, err := b.loadCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint(reloaded) != fingerprint(cert) {
+		t.Error("self-signed certificate generated again")
+	}
+}
+
+func TestLoadCertificateWithoutKeyFile(t *testing.T) {
+	b := newConfigTestBridge(t, newConfigTestDir(t), Options{TLS: TLSOptions{CertFile: "tls.crt"}})
+	if _, err := b.loadCertificate(); err == nil {
+		t.Error("certificate loaded without key file")
+	}
+}
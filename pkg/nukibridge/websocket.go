@@ -0,0 +1,289 @@
+package nukibridge
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mapero/nuki-bridge/pkg/nukibridge/enums"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wsClientBuffer = 32
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	// wsMaxInflight is the number of commands a client may run at the same time
+	wsMaxInflight = 4
+
+	WsCommandLockAction = "lockAction"
+	WsCommandRefresh    = "refresh"
+	WsCommandSubscribe  = "subscribe"
+)
+
+var errTooManyCommands = errors.New("Too many commands in progress")
+
+// checkOrigin accepts clients without origin, like scripts, pages served by the bridge host
+// and the configured origins, so other pages can't use a token stored in the browser
+func (s *NukiBridgeService) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.bridge.options.WebsocketOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// WsCommand is sent by the client, the id is returned in the reply
+type WsCommand struct {
+	ID      string   `json:"id"`
+	Command string   `json:"command"`
+	NukiId  uint32   `json:"nukiId,omitempty"`
+	Action  uint8    `json:"action,omitempty"`
+	Suffix  string   `json:"suffix,omitempty"`
+	NukiIds []uint32 `json:"nukiIds,omitempty"`
+}
+
+// WsMessage is sent by the bridge, either an event or the reply to a command
+type WsMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	EventID uint64      `json:"eventId,omitempty"`
+	Event   string      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Success *bool       `json:"success,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type wsClient struct {
+	service *NukiBridgeService
+	caller  *identity
+	source  string
+	conn    *websocket.Conn
+	replies chan WsMessage
+	// done is closed when the reader returns, stopped when the writer returns
+	done    chan struct{}
+	stopped chan struct{}
+	// inflight limits the commands running at the same time
+	inflight chan struct{}
+
+	mutex   sync.RWMutex
+	nukiIds map[uint32]bool
+}
+
+func (s *NukiBridgeService) WsGet(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	if lastEventID := r.URL.Query().Get("lastEventId"); lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid lastEventId", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to upgrade websocket connection")
+		return
+	}
+	client := &wsClient{
+		service: s,
+		caller:  identityFrom(r.Context()),
+		source:  sourceAddress(r),
+		conn:    conn,
+		replies: make(chan WsMessage, wsClientBuffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		nukiIds: make(map[uint32]bool),
+
+		inflight: make(chan struct{}, wsMaxInflight),
+	}
+	sub, replay := s.events.subscribeSince(lastID, wsClientBuffer)
+	defer s.events.unsubscribe(sub)
+
+	go client.read()
+	client.write(sub, replay)
+	conn.Close()
+	log.WithField("source", r.RemoteAddr).Infoln("Websocket client disconnected")
+}
+
+// write is the only goroutine writing to the connection
+func (c *wsClient) write(sub *subscription, replay []Event) {
+	defer close(c.stopped)
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for _, event := range replay {
+		if err := c.writeEvent(event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-sub.dropped:
+			log.Warnln("Websocket client dropped")
+			return
+		case event := <-sub.events:
+			if err := c.writeEvent(event); err != nil {
+				return
+			}
+		case reply := <-c.replies:
+			if err := c.writeJSON(reply); err != nil {
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) writeEvent(event Event) error {
+	if !c.subscribed(event) {
+		return nil
+	}
+	return c.writeJSON(WsMessage{
+		Type:    "event",
+		EventID: event.ID,
+		Event:   event.Event,
+		Data:    event.Data,
+	})
+}
+
+func (c *wsClient) writeJSON(message WsMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteJSON(message); err != nil {
+		log.WithError(err).Warnln("Failed to write websocket message")
+		return err
+	}
+	return nil
+}
+
+// subscribed returns true for events of the bridge itself and events of subscribed locks
+func (c *wsClient) subscribed(event Event) bool {
+	if !c.caller.allowsEvent(event) {
+		return false
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if len(c.nukiIds) == 0 {
+		return true
+	}
+	e, ok := event.Data.(lockEventer)
+	if !ok || e.lockEvent().NukiId == 0 {
+		return true
+	}
+	return c.nukiIds[e.lockEvent().NukiId]
+}
+
+func (c *wsClient) read() {
+	defer close(c.done)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		return nil
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.WithError(err).Warnln("Failed to read websocket message")
+			}
+			return
+		}
+		var cmd WsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			c.reply(cmd, nil, err)
+			continue
+		}
+		select {
+		case c.inflight <- struct{}{}:
+		default:
+			c.reply(cmd, nil, errTooManyCommands)
+			continue
+		}
+		// Commands may take a while, replies are correlated by their id
+		go c.execute(cmd)
+	}
+}
+
+func (c *wsClient) execute(cmd WsCommand) {
+	defer func() { <-c.inflight }()
+	switch cmd.Command {
+	case WsCommandLockAction:
+		if !c.caller.allowsAction(cmd.NukiId, enums.LockAction(cmd.Action)) {
+			c.reply(cmd, nil, errForbidden)
+			return
+		}
+		if !c.service.bridge.limiter.allowLockAction(c.caller.Name, cmd.NukiId) {
+			c.reply(cmd, nil, errRateLimited)
+			return
+		}
+		log.WithField("identity", c.caller.Name).WithField("nukiId", cmd.NukiId).WithField("action", enums.LockAction(cmd.Action).String()).Infoln("Websocket lock action")
+		state, err := c.service.bridge.lockAction(uint(cmd.NukiId), enums.LockAction(cmd.Action), cmd.Suffix)
+		c.service.bridge.auditLockAction(c.caller.Name, c.source, cmd.NukiId, enums.LockAction(cmd.Action).String(), err)
+		c.reply(cmd, state, err)
+	case WsCommandRefresh:
+		if !c.caller.allowsLock(cmd.NukiId) {
+			c.reply(cmd, nil, errForbidden)
+			return
+		}
+		l, err := c.service.bridge.GetLock(uint(cmd.NukiId))
+		if err != nil {
+			c.reply(cmd, nil, err)
+			return
+		}
+		state, err := c.service.bridge.refreshState(l)
+		c.reply(cmd, state, err)
+	case WsCommandSubscribe:
+		c.mutex.Lock()
+		c.nukiIds = make(map[uint32]bool)
+		for _, id := range cmd.NukiIds {
+			c.nukiIds[id] = true
+		}
+		c.mutex.Unlock()
+		c.reply(cmd, cmd.NukiIds, nil)
+	default:
+		c.reply(cmd, nil, errors.New("Unknown command"))
+	}
+}
+
+func (c *wsClient) reply(cmd WsCommand, data interface{}, err error) {
+	success := err == nil
+	message := WsMessage{
+		Type:    "reply",
+		ID:      cmd.ID,
+		Data:    data,
+		Success: &success,
+	}
+	if err != nil {
+		message.Data = nil
+		message.Error = err.Error()
+	}
+	// Replies are dropped once the writer returned, the reader must not block then
+	select {
+	case c.replies <- message:
+	case <-c.done:
+	case <-c.stopped:
+	}
+}
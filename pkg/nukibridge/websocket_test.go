@@ -0,0 +1,108 @@
+package nukibridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newWebsocketTestConn connects a client of the identity to the websocket endpoint of a bridge without locks
+func newWebsocketTestConn(t *testing.T, id *identity) (*NukiBridgeService, *websocket.Conn) {
+	b := &bridge{Locks: make(map[uint]*lock)}
+	b.service = NewBridgeService(b)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.service.WsGet(w, r.WithContext(withIdentity(r.Context(), id)))
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return b.service, conn
+}
+
+func TestWebsocketCommands(t *testing.T) {
+	lockOnly := &identity{Name: "alice", Scopes: []string{ScopeLock}, NukiIds: []uint32{1}}
+	tests := []struct {
+		name    string
+		command string
+		success bool
+		error   string
+	}{
+		{"subscribe", `{"id": "1", "command": "subscribe", "nukiIds": [1, 2]}`, true, ""},
+		{"unknown command", `{"id": "2", "command": "open"}`, false, "Unknown command"},
+		{"broken command", `{"id": `, false, ""},
+		{"action on other lock", `{"id": "3", "command": "lockAction", "nukiId": 2, "action": 2}`, false, errForbidden.Error()},
+		{"refresh of other lock", `{"id": "4", "command": "refresh", "nukiId": 2}`, false, errForbidden.Error()},
+		{"refresh of unknown lock", `{"id": "5", "command": "refresh", "nukiId": 1}`, false, ""},
+	}
+	_, conn := newWebsocketTestConn(t, lockOnly)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.command)); err != nil {
+				t.Fatal(err)
+			}
+			var reply WsMessage
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Type != "reply" || reply.Success == nil || *reply.Success != tt.success {
+				t.Fatalf("reply %+v, want success %v", reply, tt.success)
+			}
+			if tt.error != "" && reply.Error != tt.error {
+				t.Errorf("error %q, want %q", reply.Error, tt.error)
+			}
+		})
+	}
+}
+
+func TestWebsocketSubscription(t *testing.T) {
+	s, conn := newWebsocketTestConn(t, masterIdentity)
+	if err := conn.WriteJSON(WsCommand{ID: "1", Command: WsCommandSubscribe, NukiIds: []uint32{2}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply WsMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	s.events.publish(Event{Event: EventLockActionRequested, Data: LockActionEvent{LockEvent: newLockEvent(1)}})
+	s.events.publish(Event{Event: EventLockActionRequested, Data: LockActionEvent{LockEvent: newLockEvent(2)}})
+	var event WsMessage
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := event.Data.(map[string]interface{})
+	if event.Type != "event" || data["nukiId"] != 2.0 {
+		t.Errorf("event %+v, want the action of the subscribed lock 2", event)
+	}
+}
+
+func TestWebsocketReplyAfterWriterStopped(t *testing.T) {
+	c := &wsClient{
+		replies: make(chan WsMessage, wsClientBuffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for i := 0; i < wsClientBuffer; i++ {
+		c.reply(WsCommand{ID: "queued"}, nil, nil)
+	}
+	close(c.stopped)
+	replied := make(chan struct{})
+	go func() {
+		c.reply(WsCommand{ID: "blocked"}, nil, nil)
+		close(replied)
+	}()
+	select {
+	case <-replied:
+	case <-time.After(time.Second):
+		t.Fatal("reply blocks after the writer stopped")
+	}
+}